@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	// DeviceIDMetadataKey is the metadata key the ID of a device is sent in
+	DeviceIDMetadataKey = "x-registrar-device-id"
+
+	// DeviceSecretMetadataKey is the metadata key the secret of a device is sent in
+	DeviceSecretMetadataKey = "x-registrar-device-secret"
+
+	// AdminTokenMetadataKey is the metadata key the admin token is sent in
+	AdminTokenMetadataKey = "x-registrar-admin-token"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ credentials.PerRPCCredentials = &DeviceCredentials{}
+	_ credentials.PerRPCCredentials = &AdminCredentials{}
+)
+
+// DeviceCredentials authenticates RPCs as a device, using the secret issued
+// to it on it's first registration
+type DeviceCredentials struct {
+	// ID is the ID of the device
+	ID string
+
+	// Secret is the device secret returned by Register
+	Secret string
+
+	// AllowInsecure allows sending credentials over an insecure connection
+	AllowInsecure bool
+}
+
+// GetRequestMetadata returns the metadata used to authenticate a request
+func (c *DeviceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		DeviceIDMetadataKey:     c.ID,
+		DeviceSecretMetadataKey: c.Secret,
+	}, nil
+}
+
+// RequireTransportSecurity returns true if the credentials require TLS
+func (c *DeviceCredentials) RequireTransportSecurity() bool {
+	return !c.AllowInsecure
+}
+
+// AdminCredentials authenticates RPCs to the RegistrarAdmin service, using
+// the admin token registrard is configured with
+type AdminCredentials struct {
+	// Token is the admin token
+	Token string
+
+	// AllowInsecure allows sending credentials over an insecure connection
+	AllowInsecure bool
+}
+
+// GetRequestMetadata returns the metadata used to authenticate a request
+func (c *AdminCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{AdminTokenMetadataKey: c.Token}, nil
+}
+
+// RequireTransportSecurity returns true if the credentials require TLS
+func (c *AdminCredentials) RequireTransportSecurity() bool {
+	return !c.AllowInsecure
+}
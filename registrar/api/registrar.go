@@ -2,11 +2,14 @@ package api
 
 import "context"
 
-//go:generate protoc -I. --go_out=plugins=grpc,paths=source_relative:. ./registrar.proto
+//go:generate protoc -I. --go_out=plugins=grpc,paths=source_relative:. ./registrar.proto ./admin.proto
 
 // Service is the registrar server interface
 //
 // This interface is implemented by the server and the rpc client
 type Service interface {
 	Register(ctx context.Context, r *RegisterRequest) (*RegisterResponse, error)
+	Deregister(ctx context.Context, r *DeregisterRequest) (*DeregisterResponse, error)
+	ReportStatus(ctx context.Context, r *ReportStatusRequest) (*ReportStatusResponse, error)
+	WatchConfig(r *WatchConfigRequest, stream Registrar_WatchConfigServer) error
 }
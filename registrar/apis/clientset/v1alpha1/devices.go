@@ -23,6 +23,7 @@ type DeviceInterface interface {
 	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
 	DeleteCollection(context.Context, metav1.DeleteOptions, metav1.ListOptions) error
 	Update(context.Context, *v1alpha1.Device) (*v1alpha1.Device, error)
+	UpdateStatus(context.Context, *v1alpha1.Device) (*v1alpha1.Device, error)
 	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.Device, err error)
 	Create(context.Context, *v1alpha1.Device, metav1.CreateOptions) (*v1alpha1.Device, error)
 	Watch(context.Context, metav1.ListOptions) (watch.Interface, error)
@@ -102,6 +103,21 @@ func (c *deviceClient) Update(ctx context.Context, d *v1alpha1.Device) (result *
 	return
 }
 
+// UpdateStatus updates the status subresource of a device. Changes to anything
+// but the status are ignored.
+func (c *deviceClient) UpdateStatus(ctx context.Context, d *v1alpha1.Device) (result *v1alpha1.Device, err error) {
+	result = &v1alpha1.Device{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("devices").
+		Name(d.Name).
+		SubResource("status").
+		Body(d).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 // Patch applies the patch and returns the patched device.
 func (c *deviceClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.Device, err error) {
 	result = &v1alpha1.Device{}
@@ -0,0 +1,55 @@
+// Package fake contains a fake registrar clientset backed by an in-memory
+// object tracker, for testing without a cluster.
+package fake
+
+import (
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ v1alpha1.Interface = &Clientset{}
+)
+
+// Clientset is a fake kubernetes and registrar clientset. Unlike the
+// client-go fake, resource versions are checked and bumped on every write.
+// Reactors for registrar resources are added to the FakeRegistrarV1Alpha1
+// returned by RegistrarV1Alpha1Client.
+type Clientset struct {
+	*kubefake.Clientset
+	registrar *FakeRegistrarV1Alpha1
+}
+
+// NewSimpleClientset returns a clientset that responds with the objects
+// it was given, as if they were stored in a cluster
+func NewSimpleClientset(objects ...runtime.Object) *Clientset {
+	r := NewRegistrarV1Alpha1()
+
+	kubeObjects := make([]runtime.Object, 0, len(objects))
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *registrar.Device, *registrar.JoinToken:
+			if err := r.tracker.Add(obj); err != nil {
+				panic(err)
+			}
+		default:
+			kubeObjects = append(kubeObjects, obj)
+		}
+	}
+
+	kube := kubefake.NewSimpleClientset(kubeObjects...)
+	kube.PrependReactor("*", "*", newObjectTracker(kube.Tracker()).react)
+
+	return &Clientset{
+		Clientset: kube,
+		registrar: r,
+	}
+}
+
+// RegistrarV1Alpha1Client returns the fake registrar client
+func (c *Clientset) RegistrarV1Alpha1Client() v1alpha1.RegistrarV1Alpha1Interface {
+	return c.registrar
+}
@@ -0,0 +1,108 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/testing"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ v1alpha1.DeviceInterface = &FakeDevices{}
+)
+
+var (
+	devicesResource = registrar.GroupVersion.WithResource("devices")
+	devicesKind     = registrar.GroupVersion.WithKind("Device")
+)
+
+// FakeDevices is a fake client for devices in a namespace
+type FakeDevices struct {
+	Fake *FakeRegistrarV1Alpha1
+	ns   string
+}
+
+// List returns all devices in a namespace matching the selectors of opts
+func (c *FakeDevices) List(ctx context.Context, opts metav1.ListOptions) (*registrar.DeviceList, error) {
+	obj, err := c.Fake.Invokes(testing.NewListAction(devicesResource, devicesKind, c.ns, opts), &registrar.DeviceList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, field, _ := testing.ExtractFromListOptions(opts)
+	list := &registrar.DeviceList{ListMeta: obj.(*registrar.DeviceList).ListMeta}
+	for i := range obj.(*registrar.DeviceList).Items {
+		d := &obj.(*registrar.DeviceList).Items[i]
+		if matches(d, label, field) {
+			list.Items = append(list.Items, *d)
+		}
+	}
+	return list, err
+}
+
+// Get returns a given device by it's name
+func (c *FakeDevices) Get(ctx context.Context, name string, opts metav1.GetOptions) (*registrar.Device, error) {
+	obj, err := c.Fake.Invokes(testing.NewGetAction(devicesResource, c.ns, name), &registrar.Device{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), err
+}
+
+// Delete deletes a device by it's name
+func (c *FakeDevices) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	_, err := c.Fake.Invokes(testing.NewDeleteAction(devicesResource, c.ns, name), &registrar.Device{})
+	return err
+}
+
+// DeleteCollection deletes a collection of devices
+func (c *FakeDevices) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	_, err := c.Fake.Invokes(testing.NewDeleteCollectionAction(devicesResource, c.ns, listOpts), &registrar.DeviceList{})
+	return err
+}
+
+// Update updates a device, ignoring changes to it's status
+func (c *FakeDevices) Update(ctx context.Context, d *registrar.Device) (*registrar.Device, error) {
+	obj, err := c.Fake.Invokes(testing.NewUpdateAction(devicesResource, c.ns, d), &registrar.Device{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), err
+}
+
+// UpdateStatus updates the status subresource of a device
+func (c *FakeDevices) UpdateStatus(ctx context.Context, d *registrar.Device) (*registrar.Device, error) {
+	obj, err := c.Fake.Invokes(testing.NewUpdateSubresourceAction(devicesResource, "status", c.ns, d), &registrar.Device{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), err
+}
+
+// Patch applies the patch and returns the patched device
+func (c *FakeDevices) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (*registrar.Device, error) {
+	obj, err := c.Fake.Invokes(testing.NewPatchSubresourceAction(devicesResource, c.ns, name, pt, data, subresources...), &registrar.Device{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), err
+}
+
+// Create creates a device, ignoring it's status
+func (c *FakeDevices) Create(ctx context.Context, d *registrar.Device, opts metav1.CreateOptions) (*registrar.Device, error) {
+	obj, err := c.Fake.Invokes(testing.NewCreateAction(devicesResource, c.ns, d), &registrar.Device{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), err
+}
+
+// Watch returns a watch of the devices matching the selectors of opts
+func (c *FakeDevices) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.Fake.InvokesWatch(testing.NewWatchAction(devicesResource, c.ns, opts))
+}
@@ -0,0 +1,99 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/testing"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ v1alpha1.JoinTokenInterface = &FakeJoinTokens{}
+)
+
+var (
+	joinTokensResource = registrar.GroupVersion.WithResource("jointokens")
+	joinTokensKind     = registrar.GroupVersion.WithKind("JoinToken")
+)
+
+// FakeJoinTokens is a fake client for join tokens in a namespace
+type FakeJoinTokens struct {
+	Fake *FakeRegistrarV1Alpha1
+	ns   string
+}
+
+// List returns all join tokens in a namespace matching the selectors of opts
+func (c *FakeJoinTokens) List(ctx context.Context, opts metav1.ListOptions) (*registrar.JoinTokenList, error) {
+	obj, err := c.Fake.Invokes(testing.NewListAction(joinTokensResource, joinTokensKind, c.ns, opts), &registrar.JoinTokenList{})
+	if obj == nil {
+		return nil, err
+	}
+
+	label, field, _ := testing.ExtractFromListOptions(opts)
+	list := &registrar.JoinTokenList{ListMeta: obj.(*registrar.JoinTokenList).ListMeta}
+	for i := range obj.(*registrar.JoinTokenList).Items {
+		jt := &obj.(*registrar.JoinTokenList).Items[i]
+		if matches(jt, label, field) {
+			list.Items = append(list.Items, *jt)
+		}
+	}
+	return list, err
+}
+
+// Get returns a given join token by it's name
+func (c *FakeJoinTokens) Get(ctx context.Context, name string, opts metav1.GetOptions) (*registrar.JoinToken, error) {
+	obj, err := c.Fake.Invokes(testing.NewGetAction(joinTokensResource, c.ns, name), &registrar.JoinToken{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), err
+}
+
+// Delete deletes a join token by it's name
+func (c *FakeJoinTokens) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	_, err := c.Fake.Invokes(testing.NewDeleteAction(joinTokensResource, c.ns, name), &registrar.JoinToken{})
+	return err
+}
+
+// DeleteCollection deletes a collection of join tokens
+func (c *FakeJoinTokens) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	_, err := c.Fake.Invokes(testing.NewDeleteCollectionAction(joinTokensResource, c.ns, listOpts), &registrar.JoinTokenList{})
+	return err
+}
+
+// Update updates a join token
+func (c *FakeJoinTokens) Update(ctx context.Context, jt *registrar.JoinToken) (*registrar.JoinToken, error) {
+	obj, err := c.Fake.Invokes(testing.NewUpdateAction(joinTokensResource, c.ns, jt), &registrar.JoinToken{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), err
+}
+
+// Patch applies the patch and returns the patched join token
+func (c *FakeJoinTokens) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (*registrar.JoinToken, error) {
+	obj, err := c.Fake.Invokes(testing.NewPatchSubresourceAction(joinTokensResource, c.ns, name, pt, data, subresources...), &registrar.JoinToken{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), err
+}
+
+// Create creates a join token
+func (c *FakeJoinTokens) Create(ctx context.Context, jt *registrar.JoinToken, opts metav1.CreateOptions) (*registrar.JoinToken, error) {
+	obj, err := c.Fake.Invokes(testing.NewCreateAction(joinTokensResource, c.ns, jt), &registrar.JoinToken{})
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), err
+}
+
+// Watch returns a watch of the join tokens matching the selectors of opts
+func (c *FakeJoinTokens) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.Fake.InvokesWatch(testing.NewWatchAction(joinTokensResource, c.ns, opts))
+}
@@ -0,0 +1,99 @@
+package fake
+
+import (
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/testing"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ v1alpha1.RegistrarV1Alpha1Interface = &FakeRegistrarV1Alpha1{}
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	if err := registrar.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+}
+
+// FakeRegistrarV1Alpha1 is a fake registrar client. Unlike the client-go
+// fakes, selectors are applied to lists and watches.
+type FakeRegistrarV1Alpha1 struct {
+	*testing.Fake
+
+	tracker *objectTracker
+}
+
+// NewRegistrarV1Alpha1 returns a fake registrar client without any objects
+func NewRegistrarV1Alpha1() *FakeRegistrarV1Alpha1 {
+	c := &FakeRegistrarV1Alpha1{
+		Fake:    &testing.Fake{},
+		tracker: newObjectTracker(testing.NewObjectTracker(scheme, serializer.NewCodecFactory(scheme).UniversalDecoder())),
+	}
+	c.AddReactor("*", "*", c.tracker.react)
+	c.AddWatchReactor("*", c.reactWatch)
+
+	return c
+}
+
+// Devices returns a fake client for devices in a namespace
+func (c *FakeRegistrarV1Alpha1) Devices(namespace string) v1alpha1.DeviceInterface {
+	return &FakeDevices{c, namespace}
+}
+
+// JoinTokens returns a fake client for join tokens in a namespace
+func (c *FakeRegistrarV1Alpha1) JoinTokens(namespace string) v1alpha1.JoinTokenInterface {
+	return &FakeJoinTokens{c, namespace}
+}
+
+// reactWatch watches registrar resources using the object tracker,
+// filtering events by the selectors of the watch
+func (c *FakeRegistrarV1Alpha1) reactWatch(action testing.Action) (bool, watch.Interface, error) {
+	w, err := c.tracker.Watch(action.GetResource(), action.GetNamespace())
+	if err != nil {
+		return true, nil, err
+	}
+
+	var label labels.Selector
+	var field fields.Selector
+	if a, ok := action.(testing.WatchActionImpl); ok {
+		label = a.WatchRestrictions.Labels
+		field = a.WatchRestrictions.Fields
+	}
+
+	return true, watch.Filter(w, func(e watch.Event) (watch.Event, bool) {
+		if e.Type == watch.Error {
+			return e, true
+		}
+		return e, matches(e.Object, label, field)
+	}), nil
+}
+
+// matches returns true if the labels and fields of obj match the selectors.
+// Only the metadata.name and metadata.namespace fields are supported, nil
+// selectors match everything.
+func matches(obj runtime.Object, label labels.Selector, field fields.Selector) bool {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+
+	if label != nil && !label.Matches(labels.Set(m.GetLabels())) {
+		return false
+	}
+
+	if field != nil && !field.Matches(fields.Set{"metadata.name": m.GetName(), "metadata.namespace": m.GetNamespace()}) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,100 @@
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestStatusSubresource(t *testing.T) {
+	ctx := context.Background()
+	devices := NewSimpleClientset().RegistrarV1Alpha1Client().Devices("registrar")
+
+	d, err := devices.Create(ctx, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id"},
+		Status:     registrar.DeviceStatus{CredentialHash: "hash"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+
+	if d.Status.CredentialHash != "" {
+		t.Error("expected the status to be ignored on create")
+	}
+
+	d.Labels = map[string]string{"updated": "true"}
+	d.Status.CredentialHash = "hash"
+	d, err = devices.Update(ctx, d)
+	if err != nil {
+		t.Fatalf("failed to update device: %v", err)
+	}
+
+	if d.Labels["updated"] != "true" || d.Status.CredentialHash != "" {
+		t.Errorf("expected only the labels to be updated, got %v and %+v", d.Labels, d.Status)
+	}
+
+	d.Labels = nil
+	d.Status.CredentialHash = "hash"
+	d, err = devices.UpdateStatus(ctx, d)
+	if err != nil {
+		t.Fatalf("failed to update device status: %v", err)
+	}
+
+	if d.Labels["updated"] != "true" || d.Status.CredentialHash != "hash" {
+		t.Errorf("expected only the status to be updated, got %v and %+v", d.Labels, d.Status)
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	ctx := context.Background()
+	devices := NewSimpleClientset(&registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "registrar", Name: "device-id"},
+	}).RegistrarV1Alpha1Client().Devices("registrar")
+
+	stale, err := devices.Get(ctx, "device-id", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if _, err := devices.UpdateStatus(ctx, stale.DeepCopy()); err != nil {
+		t.Fatalf("failed to update device status: %v", err)
+	}
+
+	if _, err := devices.Update(ctx, stale); !kerrors.IsConflict(err) {
+		t.Errorf("expected updating a stale device to conflict, got %v", err)
+	}
+}
+
+func TestWatchFieldSelector(t *testing.T) {
+	ctx := context.Background()
+	devices := NewSimpleClientset().RegistrarV1Alpha1Client().Devices("registrar")
+
+	w, err := devices.Watch(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", "watched").String(),
+	})
+	if err != nil {
+		t.Fatalf("failed to watch devices: %v", err)
+	}
+	defer w.Stop()
+
+	for _, name := range []string{"other", "watched"} {
+		if _, err := devices.Create(ctx, &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+	}
+
+	select {
+	case e := <-w.ResultChan():
+		if d := e.Object.(*registrar.Device); e.Type != watch.Added || d.Name != "watched" {
+			t.Errorf("expected only the watched device to be sent, got %s %s", e.Type, d.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the watched device to be sent")
+	}
+}
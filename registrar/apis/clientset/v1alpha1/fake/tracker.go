@@ -0,0 +1,212 @@
+package fake
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/testing"
+)
+
+// objectTracker wraps an object tracker so it behaves like the API server
+// for the parts registrard relies on. Resource versions are checked and
+// bumped on every write, and the status of a device can only be changed
+// through it's status subresource.
+type objectTracker struct {
+	testing.ObjectTracker
+
+	lock            sync.Mutex
+	resourceVersion uint64
+}
+
+func newObjectTracker(tracker testing.ObjectTracker) *objectTracker {
+	return &objectTracker{ObjectTracker: tracker}
+}
+
+// Add stores an object as is, other than giving it a resource version
+// if it doesn't have one
+func (t *objectTracker) Add(obj runtime.Object) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	obj = obj.DeepCopyObject()
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	if m.GetResourceVersion() == "" {
+		m.SetResourceVersion(t.nextResourceVersion())
+	}
+
+	return t.ObjectTracker.Add(obj)
+}
+
+// nextResourceVersion returns a new resource version, t.lock must be held
+func (t *objectTracker) nextResourceVersion() string {
+	t.resourceVersion++
+	return strconv.FormatUint(t.resourceVersion, 10)
+}
+
+// react handles an action using the object tracker
+func (t *objectTracker) react(action testing.Action) (bool, runtime.Object, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	objectReaction := testing.ObjectReaction(t.ObjectTracker)
+	gvr := action.GetResource()
+	ns := action.GetNamespace()
+
+	switch action := action.(type) {
+	case testing.CreateActionImpl:
+		if action.GetSubresource() != "" {
+			return objectReaction(action)
+		}
+
+		obj := action.GetObject().DeepCopyObject()
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return true, nil, err
+		}
+
+		if m.GetName() == "" {
+			return true, nil, kerrors.NewBadRequest("name is required")
+		}
+
+		m.SetUID(types.UID(uuid.New().String()))
+		m.SetCreationTimestamp(metav1.Now())
+		m.SetResourceVersion(t.nextResourceVersion())
+
+		// the status can't be set on create
+		resetStatus(obj)
+
+		return objectReaction(testing.NewCreateAction(gvr, ns, obj))
+	case testing.UpdateActionImpl:
+		obj := action.GetObject().DeepCopyObject()
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return true, nil, err
+		}
+
+		existing, err := t.ObjectTracker.Get(gvr, ns, m.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		if err := checkResourceVersion(gvr.GroupResource(), obj, existing); err != nil {
+			return true, nil, err
+		}
+
+		obj, err = t.updated(obj, existing, action.GetSubresource())
+		if err != nil {
+			return true, nil, err
+		}
+
+		return objectReaction(testing.NewUpdateAction(gvr, ns, obj))
+	case testing.PatchActionImpl:
+		existing, err := t.ObjectTracker.Get(gvr, ns, action.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		// the object tracker stores the patched object as is, so it's
+		// fixed up and stored again
+		_, obj, err := objectReaction(action)
+		if err != nil {
+			return true, nil, err
+		}
+
+		obj, err = t.updated(obj, existing, action.GetSubresource())
+		if err != nil {
+			return true, nil, err
+		}
+
+		if err := t.ObjectTracker.Update(gvr, obj, ns); err != nil {
+			return true, nil, err
+		}
+		return true, obj, nil
+	}
+
+	return objectReaction(action)
+}
+
+// updated returns the object that's stored when existing is updated to
+// obj through subresource. Only the status of objects with a status
+// subresource can be updated through it, and never through the object
+// itself. t.lock must be held.
+func (t *objectTracker) updated(obj, existing runtime.Object, subresource string) (runtime.Object, error) {
+	if hasStatusSubresource(obj) {
+		if subresource == "status" {
+			status := obj
+			obj = existing.DeepCopyObject()
+			copyStatus(obj, status)
+		} else {
+			copyStatus(obj, existing)
+		}
+	}
+
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	em, err := meta.Accessor(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	m.SetUID(em.GetUID())
+	m.SetCreationTimestamp(em.GetCreationTimestamp())
+	m.SetResourceVersion(t.nextResourceVersion())
+	return obj, nil
+}
+
+// checkResourceVersion returns a conflict if obj sets a resource version
+// that isn't the version of existing
+func checkResourceVersion(resource schema.GroupResource, obj, existing runtime.Object) error {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	em, err := meta.Accessor(existing)
+	if err != nil {
+		return err
+	}
+
+	if rv := m.GetResourceVersion(); rv != "" && rv != em.GetResourceVersion() {
+		return kerrors.NewConflict(resource, m.GetName(),
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	return nil
+}
+
+// hasStatusSubresource returns true if obj is of a type with a status
+// subresource
+func hasStatusSubresource(obj runtime.Object) bool {
+	_, ok := obj.(*registrar.Device)
+	return ok
+}
+
+// copyStatus copies the status of src into dst, if they're of a type
+// with a status subresource
+func copyStatus(dst, src runtime.Object) {
+	if d, ok := dst.(*registrar.Device); ok {
+		d.Status = *src.(*registrar.Device).Status.DeepCopy()
+	}
+}
+
+// resetStatus clears the status of obj, if it's of a type with a status
+// subresource
+func resetStatus(obj runtime.Object) {
+	copyStatus(obj, &registrar.Device{})
+}
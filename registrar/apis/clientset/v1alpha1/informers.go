@@ -0,0 +1,150 @@
+package v1alpha1
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// SharedInformerFactory provides shared informers for registrar resources
+// in a namespace
+type SharedInformerFactory interface {
+	// Start starts every informer that was requested, until stopCh is closed
+	Start(stopCh <-chan struct{})
+
+	// WaitForCacheSync waits for the caches of every started informer to
+	// be synced
+	WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool
+
+	// Devices returns the shared informer for devices
+	Devices() DeviceInformer
+}
+
+// DeviceInformer provides access to a shared informer and lister for devices
+type DeviceInformer interface {
+	Informer() cache.SharedIndexInformer
+	Lister() DeviceLister
+}
+
+type sharedInformerFactory struct {
+	client        RegistrarV1Alpha1Interface
+	namespace     string
+	defaultResync time.Duration
+
+	lock             sync.Mutex
+	informers        map[reflect.Type]cache.SharedIndexInformer
+	startedInformers map[reflect.Type]bool
+}
+
+// NewSharedInformerFactory returns a SharedInformerFactory for the resources
+// in namespace, resyncing every defaultResync
+func NewSharedInformerFactory(client RegistrarV1Alpha1Interface, namespace string, defaultResync time.Duration) SharedInformerFactory {
+	return &sharedInformerFactory{
+		client:           client,
+		namespace:        namespace,
+		defaultResync:    defaultResync,
+		informers:        make(map[reflect.Type]cache.SharedIndexInformer),
+		startedInformers: make(map[reflect.Type]bool),
+	}
+}
+
+// Start starts every informer that was requested, until stopCh is closed
+func (f *sharedInformerFactory) Start(stopCh <-chan struct{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for informerType, informer := range f.informers {
+		if !f.startedInformers[informerType] {
+			go informer.Run(stopCh)
+			f.startedInformers[informerType] = true
+		}
+	}
+}
+
+// WaitForCacheSync waits for the caches of every started informer to be synced
+func (f *sharedInformerFactory) WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool {
+	informers := func() map[reflect.Type]cache.SharedIndexInformer {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		informers := map[reflect.Type]cache.SharedIndexInformer{}
+		for informerType, informer := range f.informers {
+			if f.startedInformers[informerType] {
+				informers[informerType] = informer
+			}
+		}
+		return informers
+	}()
+
+	res := map[reflect.Type]bool{}
+	for informerType, informer := range informers {
+		res[informerType] = cache.WaitForCacheSync(stopCh, informer.HasSynced)
+	}
+	return res
+}
+
+// informerFor returns the shared informer for obj, creating it if it
+// doesn't exist yet
+func (f *sharedInformerFactory) informerFor(obj runtime.Object, newFunc func() cache.SharedIndexInformer) cache.SharedIndexInformer {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	informerType := reflect.TypeOf(obj)
+	if informer, ok := f.informers[informerType]; ok {
+		return informer
+	}
+
+	informer := newFunc()
+	f.informers[informerType] = informer
+	return informer
+}
+
+// Devices returns the shared informer for devices
+func (f *sharedInformerFactory) Devices() DeviceInformer {
+	return &deviceInformer{f}
+}
+
+type deviceInformer struct {
+	factory *sharedInformerFactory
+}
+
+// Informer returns the shared informer for devices, indexed by namespace
+// and by DeviceIndexers
+func (i *deviceInformer) Informer() cache.SharedIndexInformer {
+	f := i.factory
+	return f.informerFor(&v1alpha1.Device{}, func() cache.SharedIndexInformer {
+		indexers := DeviceIndexers()
+		indexers[cache.NamespaceIndex] = cache.MetaNamespaceIndexFunc
+		return NewDeviceInformer(f.client, f.namespace, f.defaultResync, indexers)
+	})
+}
+
+// Lister returns a lister backed by the shared informer for devices
+func (i *deviceInformer) Lister() DeviceLister {
+	return NewDeviceLister(i.Informer().GetIndexer())
+}
+
+// NewDeviceInformer returns an informer for devices in namespace. Always
+// prefer using a SharedInformerFactory, which shares informers.
+func NewDeviceInformer(client RegistrarV1Alpha1Interface, namespace string, resyncPeriod time.Duration, indexers cache.Indexers) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return client.Devices(namespace).List(context.TODO(), opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return client.Devices(namespace).Watch(context.TODO(), opts)
+			},
+		},
+		&v1alpha1.Device{},
+		resyncPeriod,
+		indexers,
+	)
+}
@@ -0,0 +1,143 @@
+package v1alpha1
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ JoinTokenInterface = &joinTokenClient{}
+)
+
+type JoinTokenInterface interface {
+	List(context.Context, metav1.ListOptions) (*v1alpha1.JoinTokenList, error)
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.JoinToken, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+	DeleteCollection(context.Context, metav1.DeleteOptions, metav1.ListOptions) error
+	Update(context.Context, *v1alpha1.JoinToken) (*v1alpha1.JoinToken, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.JoinToken, err error)
+	Create(context.Context, *v1alpha1.JoinToken, metav1.CreateOptions) (*v1alpha1.JoinToken, error)
+	Watch(context.Context, metav1.ListOptions) (watch.Interface, error)
+}
+
+type joinTokenClient struct {
+	client rest.Interface
+	ns     string
+}
+
+// List returns all join tokens in a namespace
+func (c *joinTokenClient) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.JoinTokenList, error) {
+	result := v1alpha1.JoinTokenList{}
+	err := c.client.
+		Get().
+		Namespace(c.ns).
+		Resource("jointokens").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do(ctx).
+		Into(&result)
+
+	return &result, err
+}
+
+// Get returns a given join token by it's name
+func (c *joinTokenClient) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.JoinToken, error) {
+	result := v1alpha1.JoinToken{}
+	err := c.client.
+		Get().
+		Namespace(c.ns).
+		Resource("jointokens").
+		Name(name).
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Do(ctx).
+		Into(&result)
+
+	return &result, err
+}
+
+// Delete takes name of the join token and deletes it. Returns an error if one occurs.
+func (c *joinTokenClient) Delete(ctx context.Context, name string, options metav1.DeleteOptions) error {
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("jointokens").
+		Name(name).
+		Body(options).
+		Do(ctx).
+		Error()
+}
+
+// DeleteCollection deletes a collection of objects.
+func (c *joinTokenClient) DeleteCollection(ctx context.Context, options metav1.DeleteOptions, listOptions metav1.ListOptions) error {
+	var timeout time.Duration
+	if listOptions.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOptions.TimeoutSeconds) * time.Second
+	}
+	return c.client.Delete().
+		Namespace(c.ns).
+		Resource("jointokens").
+		VersionedParams(&listOptions, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(options).
+		Do(ctx).
+		Error()
+}
+
+// Update takes the representation of a join token and updates it. Returns the server's representation of the join token, and an error, if there is any.
+func (c *joinTokenClient) Update(ctx context.Context, t *v1alpha1.JoinToken) (result *v1alpha1.JoinToken, err error) {
+	result = &v1alpha1.JoinToken{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("jointokens").
+		Name(t.Name).
+		Body(t).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Patch applies the patch and returns the patched join token.
+func (c *joinTokenClient) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (result *v1alpha1.JoinToken, err error) {
+	result = &v1alpha1.JoinToken{}
+	err = c.client.Patch(pt).
+		Namespace(c.ns).
+		Resource("jointokens").
+		SubResource(subresources...).
+		Name(name).
+		Body(data).
+		Do(ctx).
+		Into(result)
+	return
+}
+
+// Create creates a join token
+func (c *joinTokenClient) Create(ctx context.Context, token *v1alpha1.JoinToken, opts metav1.CreateOptions) (*v1alpha1.JoinToken, error) {
+	result := v1alpha1.JoinToken{}
+	err := c.client.
+		Post().
+		Namespace(c.ns).
+		Resource("jointokens").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(token).
+		Do(ctx).
+		Into(&result)
+
+	return &result, err
+}
+
+// Watch creates a watch that will return join tokens when they are modified
+func (c *joinTokenClient) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	opts.Watch = true
+	return c.client.
+		Get().
+		Namespace(c.ns).
+		Resource("jointokens").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Watch(ctx)
+}
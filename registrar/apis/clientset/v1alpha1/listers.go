@@ -0,0 +1,159 @@
+package v1alpha1
+
+import (
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// DeviceTunnelIPIndex indexes devices by their tunnel address
+	DeviceTunnelIPIndex = "tunnelIP"
+
+	// DeviceNodeNameIndex indexes devices by the name of their node
+	DeviceNodeNameIndex = "nodeName"
+
+	// DeviceSerialIndex indexes devices by their hardware serial number
+	DeviceSerialIndex = "serial"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ DeviceLister          = &deviceLister{}
+	_ DeviceNamespaceLister = &deviceNamespaceLister{}
+)
+
+// DeviceIndexers returns the indexers a device lister uses
+func DeviceIndexers() cache.Indexers {
+	return cache.Indexers{
+		DeviceTunnelIPIndex: func(obj interface{}) ([]string, error) {
+			d, ok := obj.(*v1alpha1.Device)
+			if !ok || d.Status.WireGuard == nil || d.Status.WireGuard.IPAddress == "" {
+				return nil, nil
+			}
+			return []string{d.Status.WireGuard.IPAddress}, nil
+		},
+		DeviceNodeNameIndex: func(obj interface{}) ([]string, error) {
+			d, ok := obj.(*v1alpha1.Device)
+			if !ok || d.Status.Node == nil || d.Status.Node.Name == "" {
+				return nil, nil
+			}
+			return []string{d.Status.Node.Name}, nil
+		},
+		DeviceSerialIndex: func(obj interface{}) ([]string, error) {
+			d, ok := obj.(*v1alpha1.Device)
+			if !ok || d.Status.Inventory == nil || d.Status.Inventory.Serial == "" {
+				return nil, nil
+			}
+			return []string{d.Status.Inventory.Serial}, nil
+		},
+	}
+}
+
+// DeviceLister lists devices from a cache. Objects returned by it are
+// shared and must be copied before they're modified.
+type DeviceLister interface {
+	// List lists all devices matching selector
+	List(selector labels.Selector) ([]*v1alpha1.Device, error)
+
+	// Devices returns a lister for devices in a namespace
+	Devices(namespace string) DeviceNamespaceLister
+
+	// ByTunnelIP returns the devices that were leased a tunnel address
+	ByTunnelIP(ip string) ([]*v1alpha1.Device, error)
+
+	// ByNodeName returns the devices that joined the cluster as a node
+	ByNodeName(name string) ([]*v1alpha1.Device, error)
+
+	// BySerial returns the devices with a hardware serial number
+	BySerial(serial string) ([]*v1alpha1.Device, error)
+}
+
+// DeviceNamespaceLister lists devices in a namespace from a cache
+type DeviceNamespaceLister interface {
+	// List lists all devices in the namespace matching selector
+	List(selector labels.Selector) ([]*v1alpha1.Device, error)
+
+	// Get returns a device by it's name
+	Get(name string) (*v1alpha1.Device, error)
+}
+
+type deviceLister struct {
+	indexer cache.Indexer
+}
+
+// NewDeviceLister returns a DeviceLister backed by indexer, which must have
+// the indexes of DeviceIndexers
+func NewDeviceLister(indexer cache.Indexer) DeviceLister {
+	return &deviceLister{indexer: indexer}
+}
+
+// List lists all devices matching selector
+func (l *deviceLister) List(selector labels.Selector) (ret []*v1alpha1.Device, err error) {
+	err = cache.ListAll(l.indexer, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.Device))
+	})
+	return ret, err
+}
+
+// Devices returns a lister for devices in a namespace
+func (l *deviceLister) Devices(namespace string) DeviceNamespaceLister {
+	return &deviceNamespaceLister{indexer: l.indexer, namespace: namespace}
+}
+
+// ByTunnelIP returns the devices that were leased a tunnel address
+func (l *deviceLister) ByTunnelIP(ip string) ([]*v1alpha1.Device, error) {
+	return l.byIndex(DeviceTunnelIPIndex, ip)
+}
+
+// ByNodeName returns the devices that joined the cluster as a node
+func (l *deviceLister) ByNodeName(name string) ([]*v1alpha1.Device, error) {
+	return l.byIndex(DeviceNodeNameIndex, name)
+}
+
+// BySerial returns the devices with a hardware serial number
+func (l *deviceLister) BySerial(serial string) ([]*v1alpha1.Device, error) {
+	return l.byIndex(DeviceSerialIndex, serial)
+}
+
+func (l *deviceLister) byIndex(index, value string) ([]*v1alpha1.Device, error) {
+	objs, err := l.indexer.ByIndex(index, value)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]*v1alpha1.Device, 0, len(objs))
+	for _, obj := range objs {
+		devices = append(devices, obj.(*v1alpha1.Device))
+	}
+	return devices, nil
+}
+
+type deviceNamespaceLister struct {
+	indexer   cache.Indexer
+	namespace string
+}
+
+// List lists all devices in the namespace matching selector
+func (l *deviceNamespaceLister) List(selector labels.Selector) (ret []*v1alpha1.Device, err error) {
+	err = cache.ListAllByNamespace(l.indexer, l.namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.Device))
+	})
+	return ret, err
+}
+
+// Get returns a device by it's name
+func (l *deviceNamespaceLister) Get(name string) (*v1alpha1.Device, error) {
+	obj, exists, err := l.indexer.GetByKey(l.namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, kerrors.NewNotFound(schema.GroupResource{Group: v1alpha1.GroupVersion.Group, Resource: "devices"}, name)
+	}
+
+	return obj.(*v1alpha1.Device), nil
+}
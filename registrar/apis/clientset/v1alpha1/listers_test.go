@@ -0,0 +1,73 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestLister(t *testing.T, devices ...*v1alpha1.Device) DeviceLister {
+	indexers := DeviceIndexers()
+	indexers[cache.NamespaceIndex] = cache.MetaNamespaceIndexFunc
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+	for _, d := range devices {
+		if err := indexer.Add(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewDeviceLister(indexer)
+}
+
+func TestDeviceLister(t *testing.T) {
+	l := newTestLister(t,
+		&v1alpha1.Device{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "registrar", Name: "pi-1"},
+			Status: v1alpha1.DeviceStatus{
+				WireGuard: &v1alpha1.WireGuardStatus{IPAddress: "10.10.0.2"},
+				Node:      &v1alpha1.NodeStatus{Name: "node-1"},
+				Inventory: &v1alpha1.InventoryStatus{Serial: "10000000abcdef"},
+			},
+		},
+		&v1alpha1.Device{ObjectMeta: metav1.ObjectMeta{Namespace: "registrar", Name: "pi-2"}},
+		&v1alpha1.Device{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "pi-3"}},
+	)
+
+	all, err := l.List(labels.Everything())
+	if err != nil || len(all) != 3 {
+		t.Errorf("expected 3 devices, got %d (%v)", len(all), err)
+	}
+
+	namespaced, err := l.Devices("registrar").List(labels.Everything())
+	if err != nil || len(namespaced) != 2 {
+		t.Errorf("expected 2 devices in namespace, got %d (%v)", len(namespaced), err)
+	}
+
+	if d, err := l.Devices("registrar").Get("pi-2"); err != nil || d.Name != "pi-2" {
+		t.Errorf("failed to get device: %v", err)
+	}
+
+	if _, err := l.Devices("registrar").Get("pi-3"); !kerrors.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	for name, fn := range map[string]func(string) ([]*v1alpha1.Device, error){
+		"10.10.0.2":      l.ByTunnelIP,
+		"node-1":         l.ByNodeName,
+		"10000000abcdef": l.BySerial,
+	} {
+		devices, err := fn(name)
+		if err != nil || len(devices) != 1 || devices[0].Name != "pi-1" {
+			t.Errorf("expected pi-1 to be indexed by %s, got %v (%v)", name, devices, err)
+		}
+	}
+
+	if devices, _ := l.ByNodeName("node-2"); len(devices) != 0 {
+		t.Errorf("expected no devices, got %v", devices)
+	}
+}
@@ -8,6 +8,17 @@ import (
 	"k8s.io/client-go/rest"
 )
 
+// Interface is a clientset for the kubernetes and registrar APIs
+type Interface interface {
+	kubernetes.Interface
+	RegistrarV1Alpha1Client() RegistrarV1Alpha1Interface
+}
+
+// verify we satisfy the interface on compile time
+var (
+	_ Interface = &RegistrarClientset{}
+)
+
 type RegistrarClientset struct {
 	*kubernetes.Clientset
 	registrarV1Alpha1Client RegistrarV1Alpha1Interface
@@ -19,6 +30,7 @@ func (rc *RegistrarClientset) RegistrarV1Alpha1Client() RegistrarV1Alpha1Interfa
 
 type RegistrarV1Alpha1Interface interface {
 	Devices(namespace string) DeviceInterface
+	JoinTokens(namespace string) JoinTokenInterface
 }
 
 type RegistrarV1Alpha1Client struct {
@@ -31,11 +43,6 @@ func NewForConfig(c *rest.Config) (*RegistrarClientset, error) {
 		return nil, err
 	}
 
-	v1alpha1.SchemeBuilder.Register(
-		&v1alpha1.DeviceList{},
-		&v1alpha1.Device{},
-	)
-
 	if err := v1alpha1.AddToScheme(scheme.Scheme); err != nil {
 		panic(err)
 	}
@@ -60,3 +67,10 @@ func (c *RegistrarV1Alpha1Client) Devices(namespace string) DeviceInterface {
 		ns:     namespace,
 	}
 }
+
+func (c *RegistrarV1Alpha1Client) JoinTokens(namespace string) JoinTokenInterface {
+	return &joinTokenClient{
+		client: c.client,
+		ns:     namespace,
+	}
+}
@@ -1,16 +1,342 @@
 package v1alpha1
 
-import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+import (
+	"encoding/json"
 
-type DeviceSpec struct{}
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DeviceLabel is the label on a node that stores the device it belongs to
+const DeviceLabel = "registrar.jaredallard.me/device"
+
+// DeviceApproval is the decision of an operator on a device
+type DeviceApproval string
+
+const (
+	// DeviceApproved allows a device to join the cluster
+	DeviceApproved DeviceApproval = "Approved"
+
+	// DeviceRejected prevents a device from joining the cluster
+	DeviceRejected DeviceApproval = "Rejected"
+)
+
+// DevicePhase is where a device is in it's lifecycle
+type DevicePhase string
+
+const (
+	// DevicePhasePending is a device waiting to be approved
+	DevicePhasePending DevicePhase = "Pending"
+
+	// DevicePhaseProvisioning is a device that has been given access to the
+	// cluster, but isn't ready yet
+	DevicePhaseProvisioning DevicePhase = "Provisioning"
+
+	// DevicePhaseActive is a device that is ready
+	DevicePhaseActive DevicePhase = "Active"
+
+	// DevicePhaseUnreachable is a device that stopped reporting it's status,
+	// or lost it's tunnel
+	DevicePhaseUnreachable DevicePhase = "Unreachable"
+
+	// DevicePhaseRejected is a device an operator rejected
+	DevicePhaseRejected DevicePhase = "Rejected"
+
+	// DevicePhaseDecommissioned is a device that has been removed from
+	// the cluster
+	DevicePhaseDecommissioned DevicePhase = "Decommissioned"
+)
+
+// DeviceConditionType is the type of a condition of a device
+type DeviceConditionType string
+
+const (
+	// DeviceRegistered is true when a device has credentials and has been
+	// given access to the cluster
+	DeviceRegistered DeviceConditionType = "Registered"
+
+	// DeviceTunnelUp is true when a device recently completed a WireGuard
+	// handshake with the hub
+	DeviceTunnelUp DeviceConditionType = "TunnelUp"
+
+	// DeviceNodeJoined is true when a device joined the cluster as a node
+	DeviceNodeJoined DeviceConditionType = "NodeJoined"
+
+	// DeviceReady is true when a device is registered, it's tunnel is up
+	// and it's node is ready
+	DeviceReady DeviceConditionType = "Ready"
+)
+
+// DeviceCondition is an observation of the state of a device
+type DeviceCondition struct {
+	// Type is the type of this condition
+	Type DeviceConditionType `json:"type"`
+
+	// Status is one of True, False or Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// ObservedGeneration is the generation of the device this condition
+	// was set for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastTransitionTime is when this condition last changed it's status
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+
+	// Reason is a CamelCase reason for the last transition
+	Reason string `json:"reason"`
+
+	// Message is a human readable message with details on the last transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// DeviceSpec is the desired configuration of a device. Fields that aren't
+// set fall back to the defaults of registrard.
+type DeviceSpec struct {
+	// ClusterHost is the URL of the cluster this device should join
+	// +optional
+	ClusterHost string `json:"clusterHost,omitempty"`
+
+	// ClusterToken is the token this device should join the cluster with
+	// +optional
+	ClusterToken string `json:"clusterToken,omitempty"`
+
+	// NodeLabels are the labels the node of this device should have
+	// +optional
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+
+	// NodeTaints are the taints the node of this device should have
+	// +optional
+	NodeTaints []corev1.Taint `json:"nodeTaints,omitempty"`
+
+	// K3sVersion is the version of k3s this device should run,
+	// e.g. v1.18.8+k3s1
+	// +optional
+	K3sVersion string `json:"k3sVersion,omitempty"`
+
+	// RancherRoles are the roles the node of this device has when it joins
+	// a Rancher custom cluster, any of etcd, controlplane and worker.
+	// Defaults to worker.
+	// +optional
+	RancherRoles []string `json:"rancherRoles,omitempty"`
+
+	// Approval is the decision of an operator on this device, either
+	// Approved or Rejected. It's only required when registrard is
+	// configured to require approval of new devices.
+	// +optional
+	// +kubebuilder:validation:Enum=Approved;Rejected
+	Approval DeviceApproval `json:"approval,omitempty"`
+}
 
 type DeviceStatus struct {
+	// Phase is where this device is in it's lifecycle
+	// +optional
+	Phase DevicePhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation of this device the status was
+	// last computed for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions are the latest observations of the state of this device
+	// +optional
+	Conditions []DeviceCondition `json:"conditions,omitempty"`
+
 	// Registered denotes wether or not this device is considered as
 	// being registered or not.
 	Registered bool `json:"registered"`
+
+	// Decommissioned is set when this device has been removed from the
+	// cluster. It has to register with a join token again to rejoin.
+	// +optional
+	Decommissioned bool `json:"decommissioned,omitempty"`
+
+	// Stale is set when this device wasn't seen for longer than the garbage
+	// collection TTL, and it's tunnel address and peer were released. It's
+	// cleared when the device registers again.
+	// +optional
+	Stale bool `json:"stale,omitempty"`
+
+	// CredentialHash is the hex encoded SHA-256 hash of the secret this
+	// device authenticates with
+	// +optional
+	CredentialHash string `json:"credentialHash,omitempty"`
+
+	// WireGuard is the WireGuard peer configuration of this device
+	// +optional
+	WireGuard *WireGuardStatus `json:"wireguard,omitempty"`
+
+	// Certificate is the client certificate currently issued to this device
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+
+	// RevokedCertificates are the certificates issued to this device that
+	// are no longer accepted. They're removed once they've expired.
+	// +optional
+	RevokedCertificates []RevokedCertificate `json:"revokedCertificates,omitempty"`
+
+	// LastSeen is when this device last reported it's status
+	// +optional
+	LastSeen *metav1.Time `json:"lastSeen,omitempty"`
+
+	// Agent is the status last reported by this device
+	// +optional
+	Agent *AgentStatus `json:"agent,omitempty"`
+
+	// Inventory is the hardware this device reported when it last registered
+	// +optional
+	Inventory *InventoryStatus `json:"inventory,omitempty"`
+
+	// Node is the node this device joined the cluster as
+	// +optional
+	Node *NodeStatus `json:"node,omitempty"`
+
+	// BootstrapToken is the kubeadm bootstrap token this device joins the
+	// cluster with, when registrard runs with JOIN_MODE=kubeadm
+	// +optional
+	BootstrapToken *BootstrapTokenStatus `json:"bootstrapToken,omitempty"`
+}
+
+type BootstrapTokenStatus struct {
+	// ID is the ID of the token. It's secret is only stored in the
+	// bootstrap-token-<id> Secret in kube-system, since it lets anyone join
+	// a node to the cluster.
+	ID string `json:"id"`
+
+	// Expires is when the token expires
+	Expires metav1.Time `json:"expires"`
+}
+
+type NodeStatus struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+
+	// Ready denotes if the node is ready
+	Ready bool `json:"ready"`
+}
+
+type InventoryStatus struct {
+	// Architecture is the GOARCH of this device
+	Architecture string `json:"architecture"`
+
+	// Variant is the ARM variant, e.g. v7, if this device is ARM based
+	// +optional
+	Variant string `json:"variant,omitempty"`
+
+	// Model is the board model, e.g. Raspberry Pi 4 Model B Rev 1.1
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// Serial is the serial number of the board
+	// +optional
+	Serial string `json:"serial,omitempty"`
+
+	// MemoryBytes is the total amount of memory of this device
+	// +optional
+	MemoryBytes int64 `json:"memoryBytes,omitempty"`
+
+	// CPUs is the number of CPUs of this device
+	// +optional
+	CPUs int `json:"cpus,omitempty"`
+
+	// Disks are the disks of this device
+	// +optional
+	Disks []DiskStatus `json:"disks,omitempty"`
+
+	// Interfaces are the physical network interfaces of this device
+	// +optional
+	Interfaces []NetworkInterfaceStatus `json:"interfaces,omitempty"`
+}
+
+type DiskStatus struct {
+	// Name is the kernel name of the disk, e.g. sda
+	Name string `json:"name"`
+
+	// Model is the model of the disk, if known
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// SizeBytes is the size of the disk
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+type NetworkInterfaceStatus struct {
+	// Name is the name of the interface, e.g. eth0
+	Name string `json:"name"`
+
+	// MACAddress is the hardware address of the interface
+	MACAddress string `json:"macAddress"`
+}
+
+type AgentStatus struct {
+	// AgentVersion is the version of registrar running on this device
+	// +optional
+	AgentVersion string `json:"agentVersion,omitempty"`
+
+	// K3sVersion is the version of k3s installed on this device
+	// +optional
+	K3sVersion string `json:"k3sVersion,omitempty"`
+
+	// UptimeSeconds is how long this device had been up for when it
+	// last reported it's status
+	// +optional
+	UptimeSeconds int64 `json:"uptimeSeconds,omitempty"`
+
+	// LastError is the last error the agent ran into
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// WireGuardLastHandshake is when this device last completed a
+	// WireGuard handshake with the hub
+	// +optional
+	WireGuardLastHandshake *metav1.Time `json:"wireguardLastHandshake,omitempty"`
+}
+
+type CertificateStatus struct {
+	// Serial is the hex encoded serial number of the certificate
+	Serial string `json:"serial"`
+
+	// NotAfter is when the certificate expires
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
+type RevokedCertificate struct {
+	// Serial is the hex encoded serial number of the certificate
+	Serial string `json:"serial"`
+
+	// NotAfter is when the certificate expires, it's only unset on
+	// certificates revoked before it was recorded
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+}
+
+// UnmarshalJSON reads a revoked certificate, which used to be stored as
+// just it's serial number
+func (c *RevokedCertificate) UnmarshalJSON(b []byte) error {
+	var serial string
+	if err := json.Unmarshal(b, &serial); err == nil {
+		*c = RevokedCertificate{Serial: serial}
+		return nil
+	}
+
+	type revokedCertificate RevokedCertificate
+	return json.Unmarshal(b, (*revokedCertificate)(c))
+}
+
+type WireGuardStatus struct {
+	// PublicKey is the base64 encoded WireGuard public key of this device
+	PublicKey string `json:"publicKey"`
+
+	// IPAddress is the tunnel address leased to this device
+	IPAddress string `json:"ipAddress"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Node",type=string,JSONPath=`.status.node.name`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 type Device struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -26,3 +352,7 @@ type DeviceList struct {
 
 	Items []Device `json:"items"`
 }
+
+func init() {
+	SchemeBuilder.Register(&Device{}, &DeviceList{})
+}
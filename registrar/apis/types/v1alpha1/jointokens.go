@@ -0,0 +1,62 @@
+package v1alpha1
+
+import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+type JoinTokenSpec struct {
+	// TokenHash is the hex encoded SHA-256 hash of the secret part of this
+	// token. The secret itself is never stored.
+	TokenHash string `json:"tokenHash"`
+
+	// Expires is when this token stops being valid. Tokens without an
+	// expiry never expire.
+	// +optional
+	Expires *metav1.Time `json:"expires,omitempty"`
+
+	// MaxUses is the maximum number of devices that can join using this
+	// token. 0 means unlimited.
+	// +optional
+	MaxUses int `json:"maxUses,omitempty"`
+
+	// Profile is a profile that is applied to devices that join using this
+	// token, as the registrar.jaredallard.me/profile label.
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// Labels are applied to devices that join using this token
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type JoinTokenStatus struct {
+	// Uses is the number of devices that have joined using this token
+	Uses int `json:"uses"`
+
+	// LastUsed is the last time a device joined using this token
+	// +optional
+	LastUsed *metav1.Time `json:"lastUsed,omitempty"`
+
+	// Devices is a list of the devices that have joined using this token
+	// +optional
+	Devices []string `json:"devices,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type JoinToken struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   JoinTokenSpec   `json:"spec"`
+	Status JoinTokenStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type JoinTokenList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []JoinToken `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&JoinToken{}, &JoinTokenList{})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultK3sVersion is the version of k3s we install if registrard
+	// doesn't tell us which one to use
+	defaultK3sVersion = "v1.18.8+k3s1"
+
+	// watchRetryInterval is how long we wait before watching our
+	// configuration again after the stream broke
+	watchRetryInterval = 10 * time.Second
+)
+
+// k3sConfig returns how a device configuration says to join with k3s.
+// Older versions of registrard only set the cluster host, token and k3s
+// version of the configuration.
+func k3sConfig(conf *api.DeviceConfig) *api.K3SConfig {
+	if k3s := conf.GetK3S(); k3s != nil {
+		return k3s
+	}
+
+	return &api.K3SConfig{
+		ServerUrl: conf.ClusterHost,
+		Token:     conf.ClusterToken,
+		Version:   conf.K3SVersion,
+	}
+}
+
+// k3sEnv returns the environment file k3s-agent.service is started with
+// for a device configuration
+func k3sEnv(conf *api.DeviceConfig) string {
+	args := make([]string, 0, len(conf.Labels)+len(conf.Taints))
+
+	keys := make([]string, 0, len(conf.Labels))
+	for k := range conf.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("--node-label=%s=%s", k, conf.Labels[k]))
+	}
+
+	for _, t := range conf.Taints {
+		taint := t.Key
+		if t.Value != "" {
+			taint += "=" + t.Value
+		}
+		args = append(args, fmt.Sprintf("--node-taint=%s:%s", taint, t.Effect))
+	}
+
+	k3s := k3sConfig(conf)
+	return fmt.Sprintf("K3S_URL=%s\nK3S_TOKEN=%s\nK3S_ARGS=\"%s\"\n",
+		k3s.ServerUrl, k3s.Token, strings.Join(args, " "))
+}
+
+// writeIfChanged writes a file, returning true if it's contents changed
+func writeIfChanged(path string, b []byte, perm os.FileMode) (bool, error) {
+	if cur, err := ioutil.ReadFile(path); err == nil && bytes.Equal(cur, b) {
+		return false, nil
+	}
+
+	return true, ioutil.WriteFile(path, b, perm)
+}
+
+// agentMode installs and configures the k3s agent from the desired
+// configuration of this device, restarting it if anything changed
+func agentMode(ctx context.Context, conf *api.DeviceConfig) (bool, error) {
+	version := k3sConfig(conf).Version
+	if version == "" {
+		version = defaultK3sVersion
+	}
+
+	installed, err := installK3S(ctx, version)
+	if err != nil {
+		return false, err
+	}
+
+	log.Info("generating k3s env config")
+	envChanged, err := writeIfChanged(filepath.Join(confDir, "k3s"), []byte(k3sEnv(conf)), 0600)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to write k3s config to host")
+	}
+
+	unit, err := ioutil.ReadFile(filepath.Join("/opt/registrar/systemd", k3sAgentUnit))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read systemd unit file")
+	}
+
+	unitChanged, err := writeIfChanged(filepath.Join("/host/etc/systemd/system", k3sAgentUnit), unit, 0644)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to copy systemd unit file")
+	}
+
+	if !installed && !envChanged && !unitChanged {
+		return false, nil
+	}
+
+	log.Info("configuration changed, restarting k3s agent")
+	if err := hostSystemctl(ctx, "daemon-reload"); err != nil {
+		return true, err
+	}
+
+	if err := hostSystemctl(ctx, "enable", k3sAgentUnit); err != nil {
+		return true, err
+	}
+
+	return true, hostSystemctl(ctx, "restart", k3sAgentUnit)
+}
+
+// applyConfig joins the cluster the way a device configuration says to,
+// with the installer of it's join configuration
+func applyConfig(ctx context.Context, conf *api.DeviceConfig) (bool, error) {
+	switch conf.Join.(type) {
+	case *api.DeviceConfig_Rancher:
+		return rancherAgentMode(ctx, conf)
+	case *api.DeviceConfig_Kubeadm:
+		return kubeadmMode(ctx, conf)
+	default:
+		// older versions of registrard don't send a join configuration
+		// for k3s
+		return agentMode(ctx, conf)
+	}
+}
+
+// watchConfig applies the desired configuration of this device every time
+// registrard sends it, until ctx is canceled
+func watchConfig(ctx context.Context, client api.RegistrarClient, onError func(error)) {
+	for {
+		err := applyConfigStream(ctx, client)
+		if ctx.Err() != nil {
+			return
+		}
+
+		log.WithError(err).Warn("configuration stream broke, retrying")
+		onError(err)
+
+		select {
+		case <-time.After(watchRetryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// applyConfigStream applies every configuration sent on a WatchConfig stream
+func applyConfigStream(ctx context.Context, client api.RegistrarClient) error {
+	stream, err := client.WatchConfig(ctx, &api.WatchConfigRequest{})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch configuration")
+	}
+
+	for {
+		conf, err := stream.Recv()
+		if err != nil {
+			return errors.Wrap(err, "failed to receive configuration")
+		}
+
+		log.Info("received configuration")
+		if _, err := applyConfig(ctx, conf); err != nil {
+			return errors.Wrap(err, "failed to apply configuration")
+		}
+	}
+}
+
+// runAgent keeps this device's configuration up to date and reports it's
+// status until we're asked to stop. It fails if the device has to register
+// again, so we're restarted.
+func runAgent(ctx context.Context, client api.RegistrarClient, wg *api.WireguardConfig, interval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigC)
+
+	go func() {
+		select {
+		case <-sigC:
+			log.Info("shutting down")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	reporter := newStatusReporter(client, wg)
+	go watchConfig(ctx, client, reporter.setError)
+
+	return reporter.Run(ctx, interval)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+)
+
+func TestK3sEnv(t *testing.T) {
+	env := k3sEnv(&api.DeviceConfig{
+		ClusterHost:  "https://10.10.0.1:6443",
+		ClusterToken: "token",
+		Labels:       map[string]string{"b": "2", "a": "1"},
+		Taints: []*api.Taint{
+			{Key: "dedicated", Value: "pi", Effect: "NoSchedule"},
+			{Key: "arm", Effect: "PreferNoSchedule"},
+		},
+	})
+
+	want := "K3S_URL=https://10.10.0.1:6443\nK3S_TOKEN=token\n" +
+		"K3S_ARGS=\"--node-label=a=1 --node-label=b=2 --node-taint=dedicated=pi:NoSchedule --node-taint=arm:PreferNoSchedule\"\n"
+	if env != want {
+		t.Errorf("expected env:\n%s\ngot:\n%s", want, env)
+	}
+}
+
+func TestK3sEnvJoinConfig(t *testing.T) {
+	env := k3sEnv(&api.DeviceConfig{
+		Join: &api.DeviceConfig_K3S{K3S: &api.K3SConfig{ServerUrl: "https://10.10.0.1:6443", Token: "token"}},
+	})
+
+	want := "K3S_URL=https://10.10.0.1:6443\nK3S_TOKEN=token\nK3S_ARGS=\"\"\n"
+	if env != want {
+		t.Errorf("expected env:\n%s\ngot:\n%s", want, env)
+	}
+}
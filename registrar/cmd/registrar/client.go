@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// confDir is where registrar keeps it's state on the host
+const confDir = "/host/etc/registrar"
+
+// localDevice is the identity of this device, as persisted on the host
+type localDevice struct {
+	// ID is the ID registrard assigned to this device
+	ID string
+
+	// Secret is the device secret registrard issued to this device
+	Secret string
+}
+
+// loadLocalDevice reads the identity of this device from the host,
+// fields are empty if we haven't registered yet
+func loadLocalDevice() (*localDevice, error) {
+	if _, err := os.Stat(confDir); err != nil {
+		err := os.MkdirAll(confDir, 0755)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create configuration directory")
+		}
+	}
+
+	d := &localDevice{}
+	if b, err := ioutil.ReadFile(filepath.Join(confDir, "id")); err == nil {
+		d.ID = string(b)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(confDir, "secret")); err == nil {
+		d.Secret = string(b)
+	}
+
+	return d, nil
+}
+
+// save persists the identity of this device on the host
+func (d *localDevice) save() error {
+	if err := ioutil.WriteFile(filepath.Join(confDir, "id"), []byte(d.ID), 0644); err != nil {
+		return errors.Wrap(err, "failed to persist device id")
+	}
+
+	if d.Secret != "" {
+		if err := ioutil.WriteFile(filepath.Join(confDir, "secret"), []byte(d.Secret), 0600); err != nil {
+			return errors.Wrap(err, "failed to persist device secret")
+		}
+	}
+
+	return nil
+}
+
+// dialRegistrard connects to registrard, authenticating as d if it has been
+// registered already
+func dialRegistrard(ctx context.Context, c *cli.Context, d *localDevice, clientCert *tls.Certificate) (*grpc.ClientConn, error) {
+	grpcOption := make([]grpc.DialOption, 0)
+	if c.Bool("registrard-enable-tls") {
+		tlsConf := &tls.Config{}
+		if clientCert != nil {
+			tlsConf.Certificates = []tls.Certificate{*clientCert}
+		}
+		grpcOption = append(grpcOption, grpc.WithTransportCredentials(credentials.NewTLS(tlsConf)))
+	} else {
+		grpcOption = append(grpcOption, grpc.WithInsecure())
+	}
+
+	// once we've been issued a secret, we authenticate with it instead of the
+	// join token
+	if d.ID != "" && d.Secret != "" {
+		grpcOption = append(grpcOption, grpc.WithPerRPCCredentials(&api.DeviceCredentials{
+			ID:            d.ID,
+			Secret:        d.Secret,
+			AllowInsecure: !c.Bool("registrard-enable-tls"),
+		}))
+	}
+
+	conn, err := grpc.DialContext(ctx, c.String("registrard-host"), grpcOption...)
+	return conn, errors.Wrap(err, "failed to connect to registrard")
+}
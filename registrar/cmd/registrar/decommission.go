@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// k3sAgentUnit is the systemd unit agentMode installs on the host
+const k3sAgentUnit = "k3s-agent.service"
+
+// hostExec runs a command in the namespaces of the host's init process
+func hostExec(ctx context.Context, name string, args ...string) error {
+	cmdArgs := append([]string{"-t", "1", "-m", "-p", "--", name}, args...)
+	b, err := exec.CommandContext(ctx, "nsenter", cmdArgs...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "%s %s failed: %s", name, strings.Join(args, " "), strings.TrimSpace(string(b)))
+	}
+
+	return nil
+}
+
+// hostSystemctl runs systemctl on the host
+func hostSystemctl(ctx context.Context, args ...string) error {
+	return hostExec(ctx, "systemctl", args...)
+}
+
+// hostNodeName returns the name this device joined the cluster as, which
+// k3s defaults to the hostname of the host
+func hostNodeName() (string, error) {
+	if b, err := ioutil.ReadFile("/host/etc/hostname"); err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+
+	return os.Hostname()
+}
+
+// removeHostFiles removes files from the host, ignoring ones that don't exist
+func removeHostFiles(paths ...string) error {
+	for _, p := range paths {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove '%s'", p)
+		}
+	}
+
+	return nil
+}
+
+// stopK3sAgent disables the k3s agent and removes it's configuration
+func stopK3sAgent(ctx context.Context) error {
+	log.Info("stopping k3s agent")
+	if err := hostSystemctl(ctx, "disable", "--now", k3sAgentUnit); err != nil {
+		return err
+	}
+
+	if err := removeHostFiles(
+		filepath.Join("/host/etc/systemd/system", k3sAgentUnit),
+		filepath.Join(confDir, "k3s"),
+	); err != nil {
+		return err
+	}
+
+	return hostSystemctl(ctx, "daemon-reload")
+}
+
+// leaveCluster undoes how this device joined the cluster, by removing
+// rancher-agent, resetting kubeadm or stopping the k3s agent
+func leaveCluster(ctx context.Context) error {
+	removed, err := removeRancherAgent(ctx)
+	if err != nil {
+		return err
+	}
+
+	if removed {
+		log.Info("removed rancher-agent, the containers it started are left running")
+		return nil
+	}
+
+	reset, err := resetKubeadm(ctx)
+	if err != nil || reset {
+		return err
+	}
+
+	return stopK3sAgent(ctx)
+}
+
+// decommission removes this device from the cluster and undoes what
+// registering it did on the host
+func decommission(ctx context.Context, c *cli.Context) error {
+	dev, err := loadLocalDevice()
+	if err != nil {
+		return err
+	}
+
+	if dev.ID == "" {
+		return errors.New("device is not registered")
+	}
+
+	conn, err := dialDevice(ctx, c, dev)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	nodeName := c.String("node-name")
+	if nodeName == "" {
+		if nodeName, err = hostNodeName(); err != nil {
+			return errors.Wrap(err, "failed to determine node name")
+		}
+	}
+
+	log.WithFields(log.Fields{"id": dev.ID, "node": nodeName}).Info("decommissioning device")
+	if _, err := api.NewRegistrarClient(conn).Deregister(ctx, &api.DeregisterRequest{
+		NodeName: nodeName,
+	}); err != nil {
+		return errors.Wrap(err, "failed to deregister device")
+	}
+
+	if err := leaveCluster(ctx); err != nil {
+		return err
+	}
+
+	if err := wireguard.NewDevice(wireguard.DefaultInterface).Delete(ctx); err != nil {
+		return err
+	}
+
+	// our credentials were revoked, so forget them. The id and keys are kept
+	// so this device keeps it's identity if it's registered again.
+	if err := removeHostFiles(
+		filepath.Join(confDir, "secret"),
+		filepath.Join(confDir, clientCertFile),
+	); err != nil {
+		return err
+	}
+
+	log.Info("device was decommissioned")
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/inventory"
+)
+
+// collectInventory collects the hardware inventory of this device, reading
+// procfs and sysfs from under root
+func collectInventory(root string) (*api.Inventory, error) {
+	inv, err := inventory.Collect(root)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.Inventory{
+		Architecture: inv.Architecture,
+		Variant:      inv.Variant,
+		Model:        inv.Model,
+		Serial:       inv.Serial,
+		MemoryBytes:  inv.MemoryBytes,
+		Cpus:         int32(inv.CPUs),
+	}
+
+	for _, d := range inv.Disks {
+		resp.Disks = append(resp.Disks, &api.Disk{
+			Name:      d.Name,
+			Model:     d.Model,
+			SizeBytes: d.SizeBytes,
+		})
+	}
+
+	for _, i := range inv.Interfaces {
+		resp.Interfaces = append(resp.Interfaces, &api.NetworkInterface{
+			Name:       i.Name,
+			MacAddress: i.MACAddress,
+		})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// kubeadmConfigFile is the JoinConfiguration kubeadmMode joins with,
+	// in confDir
+	kubeadmConfigFile = "kubeadm-join.yaml"
+
+	// kubeletKubeconfig is written by kubeadm once it joined the cluster
+	kubeletKubeconfig = "/host/etc/kubernetes/kubelet.conf"
+)
+
+// joinConfiguration is the part of a kubeadm JoinConfiguration we use
+type joinConfiguration struct {
+	APIVersion       string           `json:"apiVersion"`
+	Kind             string           `json:"kind"`
+	Discovery        discovery        `json:"discovery"`
+	NodeRegistration nodeRegistration `json:"nodeRegistration"`
+}
+
+type discovery struct {
+	BootstrapToken bootstrapTokenDiscovery `json:"bootstrapToken"`
+}
+
+type bootstrapTokenDiscovery struct {
+	APIServerEndpoint string   `json:"apiServerEndpoint"`
+	Token             string   `json:"token"`
+	CACertHashes      []string `json:"caCertHashes"`
+}
+
+type nodeRegistration struct {
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+
+	// Taints is always set, so kubeadm doesn't add it's default taints
+	Taints []nodeTaint `json:"taints"`
+}
+
+type nodeTaint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// kubeadmJoinConfig returns the JoinConfiguration of a device configuration
+func kubeadmJoinConfig(conf *api.DeviceConfig) ([]byte, error) {
+	kc := conf.GetKubeadm()
+	jc := joinConfiguration{
+		APIVersion: "kubeadm.k8s.io/v1beta2",
+		Kind:       "JoinConfiguration",
+		Discovery: discovery{BootstrapToken: bootstrapTokenDiscovery{
+			APIServerEndpoint: kc.ApiServerEndpoint,
+			Token:             kc.Token,
+			CACertHashes:      kc.CaCertHashes,
+		}},
+		NodeRegistration: nodeRegistration{Taints: []nodeTaint{}},
+	}
+
+	labels := make([]string, 0, len(conf.Labels))
+	for k, v := range conf.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+
+	if len(labels) != 0 {
+		jc.NodeRegistration.KubeletExtraArgs = map[string]string{"node-labels": strings.Join(labels, ",")}
+	}
+
+	for _, t := range conf.Taints {
+		jc.NodeRegistration.Taints = append(jc.NodeRegistration.Taints, nodeTaint{Key: t.Key, Value: t.Value, Effect: t.Effect})
+	}
+
+	return yaml.Marshal(&jc)
+}
+
+// kubeadmMode joins the cluster with `kubeadm join` on the host, which has
+// to have kubeadm, the kubelet and a container runtime installed. Once
+// it's joined, the labels and taints of it's node are left alone.
+func kubeadmMode(ctx context.Context, conf *api.DeviceConfig) (bool, error) {
+	if _, err := os.Stat(kubeletKubeconfig); err == nil {
+		log.Debug("already joined the cluster with kubeadm")
+		return false, nil
+	}
+
+	b, err := kubeadmJoinConfig(conf)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create kubeadm config")
+	}
+
+	path := filepath.Join(confDir, kubeadmConfigFile)
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return false, errors.Wrap(err, "failed to write kubeadm config to host")
+	}
+
+	log.WithField("endpoint", conf.GetKubeadm().ApiServerEndpoint).Info("joining the cluster with kubeadm")
+	return true, hostExec(ctx, "kubeadm", "join", "--config", strings.TrimPrefix(path, "/host"))
+}
+
+// resetKubeadm undoes `kubeadm join` on the host, returning false if this
+// device didn't join with kubeadm
+func resetKubeadm(ctx context.Context) (bool, error) {
+	path := filepath.Join(confDir, kubeadmConfigFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	log.Info("resetting kubeadm")
+	if err := hostExec(ctx, "kubeadm", "reset", "--force"); err != nil {
+		return false, err
+	}
+
+	return true, removeHostFiles(path)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+)
+
+func TestKubeadmJoinConfig(t *testing.T) {
+	b, err := kubeadmJoinConfig(&api.DeviceConfig{
+		Labels: map[string]string{"zone": "garage", "a": "1"},
+		Taints: []*api.Taint{{Key: "dedicated", Value: "pi", Effect: "NoSchedule"}},
+		Join: &api.DeviceConfig_Kubeadm{Kubeadm: &api.KubeadmConfig{
+			ApiServerEndpoint: "10.10.0.1:6443",
+			Token:             "abcdef.0123456789abcdef",
+			CaCertHashes:      []string{"sha256:abc123"},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create join config: %v", err)
+	}
+
+	want := `apiVersion: kubeadm.k8s.io/v1beta2
+discovery:
+  bootstrapToken:
+    apiServerEndpoint: 10.10.0.1:6443
+    caCertHashes:
+    - sha256:abc123
+    token: abcdef.0123456789abcdef
+kind: JoinConfiguration
+nodeRegistration:
+  kubeletExtraArgs:
+    node-labels: a=1,zone=garage
+  taints:
+  - effect: NoSchedule
+    key: dedicated
+    value: pi
+`
+	if string(b) != want {
+		t.Errorf("expected join config:\n%s\ngot:\n%s", want, b)
+	}
+
+	// kubeadm taints nodes that don't set any, so no taints have to be
+	// sent as an empty list
+	b, err = kubeadmJoinConfig(&api.DeviceConfig{Join: &api.DeviceConfig_Kubeadm{Kubeadm: &api.KubeadmConfig{}}})
+	if err != nil {
+		t.Fatalf("failed to create join config: %v", err)
+	}
+
+	if !strings.Contains(string(b), "taints: []") {
+		t.Errorf("expected an empty list of taints, got:\n%s", b)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// dockerSocket is where the Docker API of the host is served
+	dockerSocket = "/var/run/docker.sock"
+
+	// rancherAgentContainer is the name of the container rancher-agent
+	// runs in
+	rancherAgentContainer = "rancher-agent"
+
+	// configHashLabel is the label the hash of the container spec it was
+	// created from is stored in, so it's only recreated when it changes
+	configHashLabel = "registrar.jaredallard.me/config-hash"
+)
+
+// errContainerNotFound is returned when a container doesn't exist
+var errContainerNotFound = errors.New("container not found")
+
+// containerSpec is the body of a Docker container create request
+type containerSpec struct {
+	Image      string            `json:"Image"`
+	Cmd        []string          `json:"Cmd"`
+	Labels     map[string]string `json:"Labels"`
+	HostConfig hostConfig        `json:"HostConfig"`
+}
+
+type hostConfig struct {
+	Privileged    bool          `json:"Privileged"`
+	NetworkMode   string        `json:"NetworkMode"`
+	Binds         []string      `json:"Binds"`
+	RestartPolicy restartPolicy `json:"RestartPolicy"`
+}
+
+type restartPolicy struct {
+	Name string `json:"Name"`
+}
+
+// containerInfo is the part of a Docker container inspect response we use
+type containerInfo struct {
+	ID     string `json:"Id"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+}
+
+// dockerClient talks to the Docker API of the host over it's unix socket
+type dockerClient struct {
+	h *http.Client
+}
+
+// newDockerClient returns a client for the Docker API served on socket
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{h: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+// do sends a request to the Docker API, decoding the response into out if
+// it's not nil. Streamed responses are copied as is when out is a writer.
+func (d *dockerClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode body")
+		}
+		r = bytes.NewReader(b)
+	}
+
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := d.h.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to talk to docker")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errContainerNotFound
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("docker %s %s failed with status %d: %s", method, path, resp.StatusCode, bytes.TrimSpace(raw))
+	}
+
+	switch w := out.(type) {
+	case nil:
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	case io.Writer:
+		_, err := io.Copy(w, resp.Body)
+		return errors.Wrap(err, "failed to read docker response")
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(out), "failed to parse docker response")
+}
+
+// pullImage pulls an image, waiting until it's done
+func (d *dockerClient) pullImage(ctx context.Context, image string) error {
+	var progress bytes.Buffer
+	err := d.do(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil, &progress)
+	if err != nil {
+		return errors.Wrapf(err, "failed to pull image '%s'", image)
+	}
+
+	// the progress is streamed as JSON messages, including errors
+	dec := json.NewDecoder(&progress)
+	for dec.More() {
+		var m struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&m); err != nil {
+			return errors.Wrap(err, "failed to parse pull progress")
+		}
+
+		if m.Error != "" {
+			return fmt.Errorf("failed to pull image '%s': %s", image, m.Error)
+		}
+	}
+
+	return nil
+}
+
+// inspectContainer returns a container by it's name, or errContainerNotFound
+func (d *dockerClient) inspectContainer(ctx context.Context, name string) (*containerInfo, error) {
+	var info containerInfo
+	if err := d.do(ctx, http.MethodGet, "/containers/"+name+"/json", nil, nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// removeContainer stops and removes a container
+func (d *dockerClient) removeContainer(ctx context.Context, name string) error {
+	return d.do(ctx, http.MethodDelete, "/containers/"+name, url.Values{"force": {"true"}}, nil, nil)
+}
+
+// runContainer creates and starts a container
+func (d *dockerClient) runContainer(ctx context.Context, name string, spec *containerSpec) error {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := d.do(ctx, http.MethodPost, "/containers/create", url.Values{"name": {name}}, spec, &created); err != nil {
+		return errors.Wrap(err, "failed to create container")
+	}
+
+	return errors.Wrap(
+		d.do(ctx, http.MethodPost, "/containers/"+created.ID+"/start", nil, nil, nil),
+		"failed to start container",
+	)
+}
+
+// rancherAgentSpec returns the rancher-agent container that joins this
+// device to a Rancher custom cluster, like the node command Rancher shows
+func rancherAgentSpec(conf *api.DeviceConfig) *containerSpec {
+	r := conf.GetRancher()
+	args := []string{"--server", r.ServerUrl, "--token", r.Token}
+	if r.CaChecksum != "" {
+		args = append(args, "--ca-checksum", r.CaChecksum)
+	}
+
+	for _, role := range r.Roles {
+		args = append(args, "--"+role)
+	}
+
+	keys := make([]string, 0, len(conf.Labels))
+	for k := range conf.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		args = append(args, "--label", k+"="+conf.Labels[k])
+	}
+
+	for _, t := range conf.Taints {
+		taint := t.Key
+		if t.Value != "" {
+			taint += "=" + t.Value
+		}
+		args = append(args, "--taints", taint+":"+t.Effect)
+	}
+
+	return &containerSpec{
+		Image: r.AgentImage,
+		Cmd:   args,
+		HostConfig: hostConfig{
+			Privileged:    true,
+			NetworkMode:   "host",
+			Binds:         []string{"/etc/kubernetes:/etc/kubernetes", "/var/run:/var/run"},
+			RestartPolicy: restartPolicy{Name: "unless-stopped"},
+		},
+	}
+}
+
+// runRancherAgent runs the rancher-agent container for a device
+// configuration, returning true if it had to be (re)created
+func runRancherAgent(ctx context.Context, d *dockerClient, conf *api.DeviceConfig) (bool, error) {
+	spec := rancherAgentSpec(conf)
+
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to encode container spec")
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	spec.Labels = map[string]string{configHashLabel: hash}
+
+	info, err := d.inspectContainer(ctx, rancherAgentContainer)
+	switch {
+	case err == errContainerNotFound:
+	case err != nil:
+		return false, errors.Wrap(err, "failed to inspect rancher-agent")
+	case info.Config.Labels[configHashLabel] == hash && info.State.Running:
+		return false, nil
+	default:
+		log.Info("configuration changed, recreating rancher-agent")
+		if err := d.removeContainer(ctx, rancherAgentContainer); err != nil && err != errContainerNotFound {
+			return false, errors.Wrap(err, "failed to remove rancher-agent")
+		}
+	}
+
+	log.WithField("image", spec.Image).Info("pulling rancher-agent")
+	if err := d.pullImage(ctx, spec.Image); err != nil {
+		return false, err
+	}
+
+	log.WithFields(log.Fields{"server": conf.GetRancher().ServerUrl, "roles": conf.GetRancher().Roles}).
+		Info("starting rancher-agent")
+	return true, d.runContainer(ctx, rancherAgentContainer, spec)
+}
+
+// rancherAgentMode joins this device to a Rancher custom cluster by running
+// rancher-agent through the Docker API of the host
+func rancherAgentMode(ctx context.Context, conf *api.DeviceConfig) (bool, error) {
+	return runRancherAgent(ctx, newDockerClient(dockerSocket), conf)
+}
+
+// removeRancherAgent removes the rancher-agent container, returning false
+// if this device doesn't run one
+func removeRancherAgent(ctx context.Context) (bool, error) {
+	if _, err := os.Stat(dockerSocket); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	err := newDockerClient(dockerSocket).removeContainer(ctx, rancherAgentContainer)
+	if err == errContainerNotFound {
+		return false, nil
+	}
+	return err == nil, errors.Wrap(err, "failed to remove rancher-agent")
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+)
+
+// fakeDocker is an in-memory Docker API, served on a unix socket
+type fakeDocker struct {
+	lock       sync.Mutex
+	containers map[string]*containerSpec
+	pulled     []string
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/images/create":
+		f.pulled = append(f.pulled, r.URL.Query().Get("fromImage"))
+		w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"status":"Done"}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/containers/create":
+		var spec containerSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.containers[r.URL.Query().Get("name")] = &spec
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"Id":"` + r.URL.Query().Get("name") + `"}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/containers/"+rancherAgentContainer+"/start":
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && r.URL.Path == "/containers/"+rancherAgentContainer+"/json":
+		spec, ok := f.containers[rancherAgentContainer]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var info containerInfo
+		info.ID = rancherAgentContainer
+		info.Config.Labels = spec.Labels
+		info.State.Running = true
+		json.NewEncoder(w).Encode(&info)
+	case r.Method == http.MethodDelete && r.URL.Path == "/containers/"+rancherAgentContainer:
+		delete(f.containers, rancherAgentContainer)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// newFakeDocker serves a fake Docker API on a unix socket in a temporary
+// directory, returning a client for it
+func newFakeDocker(t *testing.T) (*fakeDocker, *dockerClient, func()) {
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to listen on socket: %v", err)
+	}
+
+	f := &fakeDocker{containers: make(map[string]*containerSpec)}
+	srv := httptest.NewUnstartedServer(f)
+	srv.Listener = l
+	srv.Start()
+
+	return f, newDockerClient(socket), func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func testRancherConfig() *api.DeviceConfig {
+	return &api.DeviceConfig{
+		Labels: map[string]string{"zone": "garage", "a": "1"},
+		Taints: []*api.Taint{{Key: "dedicated", Value: "pi", Effect: "NoSchedule"}},
+		Join: &api.DeviceConfig_Rancher{Rancher: &api.RancherConfig{
+			ServerUrl:  "https://rancher.example.com",
+			Token:      "token",
+			CaChecksum: "abc123",
+			AgentImage: "rancher/rancher-agent:v2.4.5",
+			Roles:      []string{"worker"},
+		}},
+	}
+}
+
+func TestRancherAgentSpec(t *testing.T) {
+	spec := rancherAgentSpec(testRancherConfig())
+
+	want := []string{
+		"--server", "https://rancher.example.com", "--token", "token", "--ca-checksum", "abc123",
+		"--worker", "--label", "a=1", "--label", "zone=garage", "--taints", "dedicated=pi:NoSchedule",
+	}
+	if !reflect.DeepEqual(spec.Cmd, want) {
+		t.Errorf("expected args %v, got %v", want, spec.Cmd)
+	}
+
+	if spec.Image != "rancher/rancher-agent:v2.4.5" || !spec.HostConfig.Privileged || spec.HostConfig.NetworkMode != "host" {
+		t.Errorf("expected a privileged rancher-agent on the host network, got %+v", spec)
+	}
+}
+
+func TestRunRancherAgent(t *testing.T) {
+	f, d, closeDocker := newFakeDocker(t)
+	defer closeDocker()
+
+	ctx := context.Background()
+	conf := testRancherConfig()
+
+	created, err := runRancherAgent(ctx, d, conf)
+	if err != nil {
+		t.Fatalf("failed to run rancher-agent: %v", err)
+	}
+
+	spec, ok := f.containers[rancherAgentContainer]
+	if !created || !ok || spec.Labels[configHashLabel] == "" {
+		t.Fatalf("expected rancher-agent to be created, got %+v", spec)
+	}
+
+	if len(f.pulled) != 1 || f.pulled[0] != conf.GetRancher().AgentImage {
+		t.Errorf("expected the agent image to be pulled, got %v", f.pulled)
+	}
+
+	if created, err := runRancherAgent(ctx, d, conf); err != nil || created {
+		t.Errorf("expected an unchanged rancher-agent to be left alone, got %v, %v", created, err)
+	}
+
+	conf.GetRancher().Roles = []string{"etcd", "worker"}
+	if created, err := runRancherAgent(ctx, d, conf); err != nil || !created {
+		t.Fatalf("expected rancher-agent to be recreated, got %v, %v", created, err)
+	}
+
+	if spec := f.containers[rancherAgentContainer]; spec.Cmd[6] != "--etcd" {
+		t.Errorf("expected the new roles to be used, got %v", spec.Cmd)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// dialDevice connects to registrard with the credentials of this device
+func dialDevice(ctx context.Context, c *cli.Context, dev *localDevice) (*grpc.ClientConn, error) {
+	clientCert, _, err := loadClientCertificate(confDir, dev.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load client certificate")
+	}
+
+	return dialRegistrard(ctx, c, dev, clientCert)
+}
+
+// register registers this device with registrard, saving the credentials
+// it was issued
+func register(ctx context.Context, c *cli.Context, dev *localDevice, wgKey wireguard.Key) (*api.RegisterResponse, error) {
+	clientCert, csr, err := loadClientCertificate(confDir, dev.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load client certificate")
+	}
+
+	conn, err := dialRegistrard(ctx, c, dev, clientCert)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	inv, err := collectInventory(c.String("inventory-root"))
+	if err != nil {
+		// the inventory is informational, so don't fail registering
+		log.WithError(err).Warn("failed to collect hardware inventory")
+	}
+
+	resp, err := api.NewRegistrarClient(conn).Register(ctx, &api.RegisterRequest{
+		Id:                 dev.ID,
+		AuthToken:          c.String("registrard-token"),
+		WireguardPublicKey: wgKey.PublicKey().String(),
+		Csr:                csr,
+		Inventory:          inv,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to register device")
+	}
+
+	dev.ID = resp.Id
+	if resp.DeviceSecret != "" {
+		dev.Secret = resp.DeviceSecret
+	}
+	if err := dev.save(); err != nil {
+		return nil, err
+	}
+
+	return resp, saveClientCertificate(confDir, resp)
+}
+
+// registerAndWait registers this device, waiting for an operator to approve
+// it if registrard requires it. Registering is retried when registrard
+// aborted it because of a concurrent change to the device.
+func registerAndWait(ctx context.Context, c *cli.Context, dev *localDevice, wgKey wireguard.Key) (*api.RegisterResponse, error) {
+	for {
+		interval := c.Duration("approval-poll-interval")
+
+		resp, err := register(ctx, c, dev, wgKey)
+		if status.Code(errors.Cause(err)) == codes.Aborted {
+			log.WithError(err).WithField("retry", interval).Warn("registering was aborted, retrying")
+		} else if err != nil || !resp.PendingApproval {
+			return resp, err
+		} else {
+			log.WithFields(log.Fields{"id": resp.Id, "retry": interval}).
+				Info("device is pending approval, waiting")
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
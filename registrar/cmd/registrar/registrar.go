@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,15 +9,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/tritonmedia/pkg/app"
 	"github.com/urfave/cli/v2"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
 // copyFile is a suitable file copier for small files
@@ -36,12 +35,64 @@ func copyFile(src, dest string) error {
 	return errors.Wrap(ioutil.WriteFile(dest, b, f.Mode()), "failed to copy src to dest")
 }
 
-func installK3S(ctx context.Context) error {
-	k3sBin := "/host/usr/local/bin/k3s"
-	if _, err := os.Stat(k3sBin); !os.IsNotExist(err) {
-		// k3s already exists, skip...
+// loadOrCreateKey loads the WireGuard private key from path, generating and
+// persisting a new one if it doesn't exist
+func loadOrCreateKey(path string) (wireguard.Key, error) {
+	if b, err := ioutil.ReadFile(path); err == nil {
+		return wireguard.ParseKey(strings.TrimSpace(string(b)))
+	} else if !os.IsNotExist(err) {
+		return wireguard.Key{}, errors.Wrap(err, "failed to read wireguard key")
+	}
+
+	log.Info("generating wireguard private key")
+	k, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		return wireguard.Key{}, err
+	}
+
+	return k, errors.Wrap(ioutil.WriteFile(path, []byte(k.String()), 0600), "failed to write wireguard key")
+}
+
+// configureWireguard configures the host's WireGuard interface from the
+// configuration returned by registrard
+func configureWireguard(ctx context.Context, key wireguard.Key, conf *api.WireguardConfig) error {
+	c := &wireguard.Config{
+		PrivateKey: key,
+		Address:    conf.Address,
+		Peers:      make([]wireguard.Peer, 0, len(conf.Peers)),
+	}
+
+	for _, p := range conf.Peers {
+		pub, err := wireguard.ParseKey(p.PublicKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse peer public key")
+		}
+
+		c.Peers = append(c.Peers, wireguard.Peer{
+			PublicKey:           pub,
+			Endpoint:            p.Endpoint,
+			AllowedIPs:          p.AllowedIps,
+			PersistentKeepalive: int(p.PersistentKeepalive),
+		})
+	}
+
+	d := wireguard.NewDevice(wireguard.DefaultInterface)
+	changed, err := d.Configure(ctx, c)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure wireguard interface")
+	}
+
+	log.WithFields(log.Fields{"interface": d.Name(), "address": c.Address, "changed": changed}).
+		Info("configured wireguard interface")
+	return nil
+}
+
+// installK3S installs a version of k3s on the host, returning true if it
+// wasn't installed already
+func installK3S(ctx context.Context, version string) (bool, error) {
+	if current, err := k3sVersion(ctx); err == nil && current == version {
 		// TODO(jaredallard): checksum validation and all of that would be nice
-		return nil
+		return false, nil
 	}
 
 	downloadSuffix := ""
@@ -54,53 +105,45 @@ func installK3S(ctx context.Context) error {
 		downloadSuffix = "-" + runtime.GOARCH
 	}
 
-	url := "https://github.com/rancher/k3s/releases/download/v1.18.8%2Bk3s1/k3s" + downloadSuffix
-	log.WithFields(log.Fields{"url": url, "arch": runtime.GOARCH}).Info("downloading k3s")
+	url := "https://github.com/rancher/k3s/releases/download/" +
+		strings.Replace(version, "+", "%2B", -1) + "/k3s" + downloadSuffix
+	log.WithFields(log.Fields{"url": url, "arch": runtime.GOARCH, "version": version}).Info("downloading k3s")
 	r, err := http.Get(url)
 	if err != nil {
-		return errors.Wrap(err, "failed to download k3s")
+		return false, errors.Wrap(err, "failed to download k3s")
 	}
 	defer r.Body.Close()
 
-	f, err := os.Create(k3sBin)
+	if r.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to download k3s: got status %s", r.Status)
+	}
+
+	// k3s may be running, so we can't write to it directly
+	tmpBin := k3sBin + ".download"
+	f, err := os.Create(tmpBin)
 	if err != nil {
-		return errors.Wrap(err, "failed to open k3s")
+		return false, errors.Wrap(err, "failed to open k3s")
 	}
 	defer f.Close()
 
 	if _, err := io.Copy(f, r.Body); err != nil {
-		return errors.Wrap(err, "failed to download k3s")
+		return false, errors.Wrap(err, "failed to download k3s")
 	}
 
-	return errors.Wrap(os.Chmod(k3sBin, 0777), "failed to +x k3s")
-}
-
-func leaderMode(ctx context.Context, c *cli.Context) error { //nolint:funlen
-	if err := installK3S(ctx); err != nil {
-		return err
+	if err := os.Chmod(tmpBin, 0777); err != nil {
+		return false, errors.Wrap(err, "failed to +x k3s")
 	}
 
-	return errors.Wrap(
-		copyFile("/opt/registrar/systemd/k3s-server.service", "/host/etc/systemd/system/k3s.service"),
-		"failed to copy systemd unit file",
-	)
+	return true, errors.Wrap(os.Rename(tmpBin, k3sBin), "failed to install k3s")
 }
 
-func agentMode(ctx context.Context, resp *api.RegisterResponse) error {
-	if err := installK3S(ctx); err != nil {
+func leaderMode(ctx context.Context, c *cli.Context) error { //nolint:funlen
+	if _, err := installK3S(ctx, defaultK3sVersion); err != nil {
 		return err
 	}
 
-	log.Info("generating k3s env config")
-
-	conf := fmt.Sprintf("K3S_URL=%s\nK3S_TOKEN=%s\n", resp.ClusterHost, resp.ClusterToken)
-
-	if err := ioutil.WriteFile("/host/etc/registrar/k3s", []byte(conf), 0600); err != nil {
-		return errors.Wrap(err, "failed to write k3s config to host")
-	}
-
 	return errors.Wrap(
-		copyFile("/opt/registrar/systemd/k3s-agent.service", "/host/etc/systemd/system/k3s-agent.service"),
+		copyFile("/opt/registrar/systemd/k3s-server.service", "/host/etc/systemd/system/k3s.service"),
 		"failed to copy systemd unit file",
 	)
 }
@@ -131,9 +174,47 @@ func main() { //nolint:funlen,gocyclo
 			},
 			&cli.StringFlag{
 				Name:    "registrard-token",
-				Usage:   "registrard auth token",
+				Usage:   "registrard join token, in the form of <name>.<secret>",
 				EnvVars: []string{"REGISTRARD_TOKEN"},
 			},
+			&cli.DurationFlag{
+				Name:    "status-interval",
+				Usage:   "How often to report the status of this device to registrard, 0 exits after registering",
+				EnvVars: []string{"STATUS_INTERVAL"},
+				Value:   time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:    "approval-poll-interval",
+				Usage:   "How often to check if this device has been approved, when registrard requires approval",
+				EnvVars: []string{"APPROVAL_POLL_INTERVAL"},
+				Value:   30 * time.Second,
+			},
+			&cli.StringFlag{
+				Name:    "inventory-root",
+				Usage:   "Path procfs and sysfs are read from when collecting the hardware inventory",
+				EnvVars: []string{"INVENTORY_ROOT"},
+				Value:   "/",
+			},
+			&cli.StringFlag{
+				Name:    "wireguard-host",
+				Usage:   "Override the WireGuard endpoint (host:port) of the hub returned by registrard",
+				EnvVars: []string{"WIREGUARD_HOST"},
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "decommission",
+				Usage: "Remove this device from the cluster and stop it's k3s agent",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "node-name",
+						Usage: "Name of the node this device joined the cluster as, defaults to the hostname",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return decommission(ctx, c)
+				},
+			},
 		},
 		Action: func(c *cli.Context) error {
 			if c.Bool("leader-mode") {
@@ -145,42 +226,59 @@ func main() { //nolint:funlen,gocyclo
 			log.WithFields(log.Fields{"host": host}).
 				Info("registering device with registrar")
 
-			confDir := "/host/etc/registrar"
-			ipConfDir := filepath.Join(confDir, "id")
-			if _, err := os.Stat(confDir); err != nil {
-				err := os.MkdirAll(confDir, 0755)
-				if err != nil {
-					return errors.Wrap(err, "failed to create configuration directory")
+			dev, err := loadLocalDevice()
+			if err != nil {
+				return err
+			}
+
+			wgKey, err := loadOrCreateKey(filepath.Join(confDir, "wireguard.key"))
+			if err != nil {
+				return errors.Wrap(err, "failed to load wireguard key")
+			}
+
+			regResp, err := registerAndWait(ctx, c, dev, wgKey)
+			if err != nil {
+				return err
+			}
+
+			if wgHost := c.String("wireguard-host"); wgHost != "" {
+				for _, p := range regResp.Wireguard.Peers {
+					p.Endpoint = wgHost
 				}
 			}
 
-			var id string
-			if b, err := ioutil.ReadFile(ipConfDir); err == nil {
-				id = string(b)
+			log.WithFields(log.Fields{"id": regResp.Id, "address": regResp.Wireguard.Address}).
+				Info("registered device")
+
+			if err := configureWireguard(ctx, wgKey, regResp.Wireguard); err != nil {
+				return err
+			}
+
+			conf := regResp.Config
+			if conf == nil {
+				// registrard doesn't support device configuration yet
+				conf = &api.DeviceConfig{
+					ClusterHost:  regResp.ClusterHost,
+					ClusterToken: regResp.ClusterToken,
+				}
 			}
 
-			grpcOption := make([]grpc.DialOption, 0)
-			if c.Bool("registrard-enable-tls") {
-				grpcOption = append(grpcOption, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
-			} else {
-				grpcOption = append(grpcOption, grpc.WithInsecure())
+			if _, err := applyConfig(ctx, conf); err != nil {
+				return errors.Wrap(err, "failed to create agent")
 			}
 
-			conn, err := grpc.DialContext(ctx, host, grpcOption...)
-			if err != nil {
-				return errors.Wrap(err, "failed to connect to registrard")
+			interval := c.Duration("status-interval")
+			if interval <= 0 {
+				return nil
 			}
 
-			r := api.NewRegistrarClient(conn)
-			regResp, err := r.Register(ctx, &api.RegisterRequest{
-				Id:        id,
-				AuthToken: c.String("registrard-token"),
-			})
+			conn, err := dialDevice(ctx, c, dev)
 			if err != nil {
-				return errors.Wrap(err, "failed to register devices")
+				return err
 			}
+			defer conn.Close()
 
-			return errors.Wrap(agentMode(ctx, regResp), "failed to create agent")
+			return runAgent(ctx, api.NewRegistrarClient(conn), regResp.Wireguard, interval)
 		},
 	}
 
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tritonmedia/pkg/app"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// k3sBin is where k3s is installed on the host
+const k3sBin = "/host/usr/local/bin/k3s"
+
+// statusReporter periodically reports the status of this device to registrard
+type statusReporter struct {
+	client api.RegistrarClient
+	wg     *wireguard.Device
+
+	// hubs are the public keys of the peers we report the handshake age of
+	hubs []wireguard.Key
+
+	// lastErr is the last error we ran into
+	mu      sync.Mutex
+	lastErr string
+}
+
+// newStatusReporter creates a status reporter for the WireGuard configuration
+// returned by registrard
+func newStatusReporter(client api.RegistrarClient, conf *api.WireguardConfig) *statusReporter {
+	r := &statusReporter{
+		client: client,
+		wg:     wireguard.NewDevice(wireguard.DefaultInterface),
+	}
+
+	for _, p := range conf.Peers {
+		if pub, err := wireguard.ParseKey(p.PublicKey); err == nil {
+			r.hubs = append(r.hubs, pub)
+		}
+	}
+
+	return r
+}
+
+// Run reports our status every interval until ctx is canceled, or
+// registrard tells us that we have to register again
+func (r *statusReporter) Run(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := r.report(ctx); status.Code(err) == codes.FailedPrecondition {
+			// our tunnel address and peer were released
+			return errors.Wrap(err, "device has to register again")
+		} else if err != nil {
+			log.WithError(err).Warn("failed to report status")
+			r.setError(err)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// setError records an error to report with our next status
+func (r *statusReporter) setError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastErr = err.Error()
+}
+
+// report sends our current status to registrard
+func (r *statusReporter) report(ctx context.Context) error {
+	r.mu.Lock()
+	req := &api.ReportStatusRequest{
+		AgentVersion:                 app.Version,
+		LastError:                    r.lastErr,
+		WireguardHandshakeAgeSeconds: -1,
+	}
+	r.mu.Unlock()
+
+	var err error
+	if req.K3SVersion, err = k3sVersion(ctx); err != nil {
+		log.WithError(err).Warn("failed to get k3s version")
+	}
+
+	if req.UptimeSeconds, err = uptime(); err != nil {
+		log.WithError(err).Warn("failed to get uptime")
+	}
+
+	handshakes, err := r.wg.LatestHandshakes(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to get wireguard handshakes")
+	}
+	for _, pub := range r.hubs {
+		if t, ok := handshakes[pub]; ok {
+			age := int64(time.Since(t).Seconds())
+			if req.WireguardHandshakeAgeSeconds == -1 || age < req.WireguardHandshakeAgeSeconds {
+				req.WireguardHandshakeAgeSeconds = age
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, err = r.client.ReportStatus(ctx, req)
+	return err
+}
+
+// k3sVersion returns the version of k3s installed on the host
+func k3sVersion(ctx context.Context) (string, error) {
+	if _, err := os.Stat(k3sBin); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	b, err := exec.CommandContext(ctx, k3sBin, "--version").Output()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to run k3s")
+	}
+
+	return parseK3sVersion(string(b)), nil
+}
+
+// parseK3sVersion parses the version out of the output of k3s --version,
+// e.g. k3s version v1.18.8+k3s1 (6b595318)
+func parseK3sVersion(out string) string {
+	fields := strings.Fields(out)
+	if len(fields) < 3 || fields[1] != "version" {
+		return ""
+	}
+
+	return fields[2]
+}
+
+// uptime returns how long the host has been up for, in seconds
+func uptime() (int64, error) {
+	b, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read uptime")
+	}
+
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, errors.New("failed to parse uptime")
+	}
+
+	f, err := strconv.ParseFloat(fields[0], 64)
+	return int64(f), errors.Wrap(err, "failed to parse uptime")
+}
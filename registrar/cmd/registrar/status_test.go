@@ -0,0 +1,17 @@
+package main
+
+import "testing"
+
+func TestParseK3sVersion(t *testing.T) {
+	tests := map[string]string{
+		"k3s version v1.18.8+k3s1 (6b595318)\n": "v1.18.8+k3s1",
+		"":                                      "",
+		"something else":                        "",
+	}
+
+	for out, want := range tests {
+		if got := parseK3sVersion(out); got != want {
+			t.Errorf("parseK3sVersion(%q) = %q, expected %q", out, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	clientKeyFile  = "tls.key"
+	clientCertFile = "tls.crt"
+	caCertFile     = "ca.crt"
+)
+
+// loadClientCertificate returns the client certificate of this device, if it has
+// a valid one, and a CSR if a new certificate should be requested because it
+// has none or it's close to expiring.
+func loadClientCertificate(confDir, id string) (*tls.Certificate, []byte, error) {
+	keyPath := filepath.Join(confDir, clientKeyFile)
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		log.Info("generating tls private key")
+		key, err := pki.GenerateKey()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keyPEM, err = pki.EncodeKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to write tls key")
+		}
+	} else if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read tls key")
+	}
+
+	key, err := pki.ParseKey(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cert *tls.Certificate
+	certPEM, err := ioutil.ReadFile(filepath.Join(confDir, clientCertFile))
+	if err == nil {
+		if c, err := pki.ParseCertificate(certPEM); err == nil && time.Now().Before(c.NotAfter) {
+			kp, err := tls.X509KeyPair(certPEM, keyPEM)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to load client certificate")
+			}
+			cert = &kp
+		}
+	}
+
+	if !pki.NeedsRenewal(certPEM, time.Now()) {
+		return cert, nil, nil
+	}
+
+	log.Info("requesting a new client certificate")
+	csr, err := pki.CreateCSR(key, id)
+	return cert, csr, err
+}
+
+// saveClientCertificate persists the certificates returned by registrard
+func saveClientCertificate(confDir string, resp *api.RegisterResponse) error {
+	if len(resp.Certificate) != 0 {
+		if err := ioutil.WriteFile(filepath.Join(confDir, clientCertFile), resp.Certificate, 0644); err != nil {
+			return errors.Wrap(err, "failed to write client certificate")
+		}
+	}
+
+	if len(resp.CaCertificate) != 0 {
+		if err := ioutil.WriteFile(filepath.Join(confDir, caCertFile), resp.CaCertificate, 0644); err != nil {
+			return errors.Wrap(err, "failed to write ca certificate")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/kube"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/storage"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// errAdminAPIRequired is returned for operations that have to go through
+// registrard's admin api
+var errAdminAPIRequired = fmt.Errorf("registrard's admin api is required, set --registrard-host and --admin-token")
+
+// client manages the devices, join tokens and tunnel address leases of
+// registrard
+type client interface {
+	// ListDevices returns all devices
+	ListDevices(ctx context.Context) ([]registrar.Device, error)
+
+	// GetDevice returns a device by it's name
+	GetDevice(ctx context.Context, name string) (*registrar.Device, error)
+
+	// SetApproval approves, or rejects, a device
+	SetApproval(ctx context.Context, name string, approval registrar.DeviceApproval) (*registrar.Device, error)
+
+	// DecommissionDevice removes a device from the cluster, releases it's
+	// tunnel address and revokes it's credentials. It's done by registrard,
+	// through RevokeDevice of the admin api.
+	DecommissionDevice(ctx context.Context, name string) error
+
+	// CreateJoinToken creates a join token, returning it and the token
+	// devices use
+	CreateJoinToken(ctx context.Context, spec registrar.JoinTokenSpec) (*registrar.JoinToken, string, error)
+
+	// ListJoinTokens returns all join tokens
+	ListJoinTokens(ctx context.Context) ([]registrar.JoinToken, error)
+
+	// RevokeJoinToken expires a join token, so no more devices can join
+	// using it
+	RevokeJoinToken(ctx context.Context, name string) (*registrar.JoinToken, error)
+
+	// Leases returns the owner of every leased tunnel address
+	Leases(ctx context.Context) (map[string]string, error)
+}
+
+// verify we satisfy the interface on compile time
+var (
+	_ client = &crdClient{}
+)
+
+// crdClient manages registrard through it's custom resources, without
+// registrard being involved, except for decommissioning devices
+type crdClient struct {
+	k         v1alpha1.Interface
+	namespace string
+
+	// cidr is the network tunnel addresses are leased from
+	cidr string
+
+	// admin is registrard's admin api, which devices are decommissioned
+	// through. It's nil unless registrard's host was given.
+	admin api.RegistrarAdminClient
+}
+
+// newCRDClient returns a client using the kube config of the environment
+func newCRDClient(namespace, cidr string) (*crdClient, error) {
+	conf, err := kube.New()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kube config")
+	}
+
+	k, err := v1alpha1.NewForConfig(conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kubernetes and registrar clientset")
+	}
+
+	return &crdClient{k: k, namespace: namespace, cidr: cidr}, nil
+}
+
+func (c *crdClient) devices() v1alpha1.DeviceInterface {
+	return c.k.RegistrarV1Alpha1Client().Devices(c.namespace)
+}
+
+func (c *crdClient) joinTokens() v1alpha1.JoinTokenInterface {
+	return c.k.RegistrarV1Alpha1Client().JoinTokens(c.namespace)
+}
+
+func (c *crdClient) ipam() (*ipam.IPAM, error) {
+	return ipam.New(c.cidr, storage.NewKubernetes(c.k, c.namespace).IPAMStore())
+}
+
+func (c *crdClient) ListDevices(ctx context.Context) ([]registrar.Device, error) {
+	l, err := c.devices().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list devices")
+	}
+	return l.Items, nil
+}
+
+func (c *crdClient) GetDevice(ctx context.Context, name string) (*registrar.Device, error) {
+	d, err := c.devices().Get(ctx, name, metav1.GetOptions{})
+	return d, errors.Wrapf(err, "failed to get device '%s'", name)
+}
+
+func (c *crdClient) SetApproval(ctx context.Context, name string, approval registrar.DeviceApproval) (*registrar.Device, error) {
+	var d *registrar.Device
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		d, err = c.devices().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if d.Spec.Approval == approval {
+			return nil
+		}
+
+		d.Spec.Approval = approval
+		d, err = c.devices().Update(ctx, d)
+		return err
+	})
+	return d, errors.Wrapf(err, "failed to update device '%s'", name)
+}
+
+func (c *crdClient) DecommissionDevice(ctx context.Context, name string) error {
+	if c.admin == nil {
+		return errAdminAPIRequired
+	}
+
+	_, err := c.admin.RevokeDevice(ctx, &api.RevokeDeviceRequest{Name: name})
+	return errors.Wrapf(err, "failed to decommission device '%s'", name)
+}
+
+func (c *crdClient) CreateJoinToken(ctx context.Context, spec registrar.JoinTokenSpec) (*registrar.JoinToken, string, error) {
+	tok, err := jointoken.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	spec.TokenHash = tok.Hash()
+	jt, err := c.joinTokens().Create(ctx, &registrar.JoinToken{
+		ObjectMeta: metav1.ObjectMeta{Name: tok.Name, Namespace: c.namespace},
+		Spec:       spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create join token")
+	}
+
+	return jt, tok.String(), nil
+}
+
+func (c *crdClient) ListJoinTokens(ctx context.Context) ([]registrar.JoinToken, error) {
+	l, err := c.joinTokens().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list join tokens")
+	}
+	return l.Items, nil
+}
+
+func (c *crdClient) RevokeJoinToken(ctx context.Context, name string) (*registrar.JoinToken, error) {
+	var jt *registrar.JoinToken
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		jt, err = c.joinTokens().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		now := metav1.NewTime(time.Now())
+		if jt.Spec.Expires != nil && jt.Spec.Expires.Before(&now) {
+			return nil
+		}
+
+		jt.Spec.Expires = &now
+		jt, err = c.joinTokens().Update(ctx, jt)
+		return err
+	})
+	return jt, errors.Wrapf(err, "failed to revoke join token '%s'", name)
+}
+
+func (c *crdClient) Leases(ctx context.Context) (map[string]string, error) {
+	i, err := c.ipam()
+	if err != nil {
+		return nil, err
+	}
+	return i.Leases(ctx)
+}
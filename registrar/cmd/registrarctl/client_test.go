@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1/fake"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newTestClient(objects ...runtime.Object) *crdClient {
+	logrus.SetLevel(logrus.WarnLevel)
+	return &crdClient{k: fake.NewSimpleClientset(objects...), namespace: "registrar", cidr: "10.10.0.0/24"}
+}
+
+func TestSetApproval(t *testing.T) {
+	c := newTestClient(&registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: "registrar", Name: "device-id"}})
+
+	for _, approval := range []registrar.DeviceApproval{registrar.DeviceApproved, registrar.DeviceRejected} {
+		d, err := c.SetApproval(context.Background(), "device-id", approval)
+		if err != nil {
+			t.Fatalf("failed to set approval: %v", err)
+		}
+
+		if d.Spec.Approval != approval {
+			t.Errorf("expected the device to be %s, got %s", approval, d.Spec.Approval)
+		}
+	}
+
+	if _, err := c.SetApproval(context.Background(), "unknown", registrar.DeviceApproved); !kerrors.IsNotFound(errors.Cause(err)) {
+		t.Errorf("expected approving an unknown device to fail with not found, got %v", err)
+	}
+}
+
+func TestJoinTokens(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+
+	jt, token, err := c.CreateJoinToken(ctx, registrar.JoinTokenSpec{MaxUses: 1})
+	if err != nil {
+		t.Fatalf("failed to create join token: %v", err)
+	}
+
+	tok, err := jointoken.Parse(token)
+	if err != nil {
+		t.Fatalf("failed to parse join token: %v", err)
+	}
+
+	if tok.Name != jt.Name || !jointoken.Verify(tok.Secret, jt.Spec.TokenHash) || jt.Spec.MaxUses != 1 {
+		t.Errorf("expected the join token to match the token, got %+v", jt)
+	}
+
+	revoked, err := c.RevokeJoinToken(ctx, jt.Name)
+	if err != nil {
+		t.Fatalf("failed to revoke join token: %v", err)
+	}
+
+	if revoked.Spec.Expires == nil || revoked.Spec.Expires.After(time.Now()) {
+		t.Errorf("expected the join token to be expired, got %v", revoked.Spec.Expires)
+	}
+}
+
+// fakeAdmin is registrard's admin api, recording the devices revoked
+type fakeAdmin struct {
+	api.RegistrarAdminClient
+
+	revoked []string
+}
+
+func (a *fakeAdmin) RevokeDevice(ctx context.Context, r *api.RevokeDeviceRequest, opts ...grpc.CallOption) (*api.Device, error) {
+	a.revoked = append(a.revoked, r.Name)
+	return &api.Device{Name: r.Name}, nil
+}
+
+func TestDecommissionDevice(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient()
+
+	if err := c.DecommissionDevice(ctx, "device-id"); !errors.Is(err, errAdminAPIRequired) {
+		t.Errorf("expected decommissioning without the admin api to fail, got %v", err)
+	}
+
+	admin := &fakeAdmin{}
+	c.admin = admin
+	if err := c.DecommissionDevice(ctx, "device-id"); err != nil {
+		t.Fatalf("failed to decommission device: %v", err)
+	}
+
+	if len(admin.revoked) != 1 || admin.revoked[0] != "device-id" {
+		t.Errorf("expected the device to be revoked through the admin api, got %v", admin.revoked)
+	}
+}
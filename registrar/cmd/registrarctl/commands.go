@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// requireArg returns the only argument of a command
+func requireArg(c *cli.Context, name string) (string, error) {
+	if c.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one argument, the %s", name)
+	}
+	return c.Args().First(), nil
+}
+
+func listDevices(ctx context.Context, c *cli.Context) error {
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	devices, err := cl.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	return newPrinter(c).printDevices(devices)
+}
+
+func getDevice(ctx context.Context, c *cli.Context) error {
+	name, err := requireArg(c, "device")
+	if err != nil {
+		return err
+	}
+
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	d, err := cl.GetDevice(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(c)
+	if p.format == outputTable || p.format == "" {
+		return p.printDevices([]registrar.Device{*d})
+	}
+	return p.print(d, nil, nil)
+}
+
+func approveDevice(ctx context.Context, c *cli.Context) error {
+	return setApproval(ctx, c, registrar.DeviceApproved)
+}
+
+func rejectDevice(ctx context.Context, c *cli.Context) error {
+	return setApproval(ctx, c, registrar.DeviceRejected)
+}
+
+func setApproval(ctx context.Context, c *cli.Context, approval registrar.DeviceApproval) error {
+	name, err := requireArg(c, "device")
+	if err != nil {
+		return err
+	}
+
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	d, err := cl.SetApproval(ctx, name, approval)
+	if err != nil {
+		return err
+	}
+
+	return newPrinter(c).printDevices([]registrar.Device{*d})
+}
+
+func decommissionDevice(ctx context.Context, c *cli.Context) error {
+	name, err := requireArg(c, "device")
+	if err != nil {
+		return err
+	}
+
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	if err := cl.DecommissionDevice(ctx, name); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.App.Writer, "device '%s' was decommissioned\n", name)
+	return nil
+}
+
+// parseLabels parses labels in the form of key=value
+func parseLabels(raw []string) (map[string]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	labels := make(map[string]string, len(raw))
+	for _, l := range raw {
+		spl := strings.SplitN(l, "=", 2)
+		if len(spl) != 2 || spl[0] == "" {
+			return nil, fmt.Errorf("invalid label '%s', expected key=value", l)
+		}
+		labels[spl[0]] = spl[1]
+	}
+
+	return labels, nil
+}
+
+// createdJoinToken is what's printed when a join token is created, it's
+// the only time the token can be seen
+type createdJoinToken struct {
+	Token     string               `json:"token"`
+	JoinToken *registrar.JoinToken `json:"joinToken"`
+}
+
+func createJoinToken(ctx context.Context, c *cli.Context) error {
+	labels, err := parseLabels(c.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+
+	spec := registrar.JoinTokenSpec{
+		MaxUses: c.Int("max-uses"),
+		Profile: c.String("profile"),
+		Labels:  labels,
+	}
+
+	if ttl := c.Duration("ttl"); ttl > 0 {
+		expires := metav1.NewTime(time.Now().Add(ttl))
+		spec.Expires = &expires
+	}
+
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	jt, token, err := cl.CreateJoinToken(ctx, spec)
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(c)
+	if p.format == outputTable || p.format == "" {
+		_, err := fmt.Fprintf(p.w, "REGISTRARD_TOKEN=%s\n", token)
+		return err
+	}
+	return p.print(&createdJoinToken{Token: token, JoinToken: jt}, nil, nil)
+}
+
+func listJoinTokens(ctx context.Context, c *cli.Context) error {
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := cl.ListJoinTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	return newPrinter(c).printJoinTokens(tokens)
+}
+
+func revokeJoinToken(ctx context.Context, c *cli.Context) error {
+	name, err := requireArg(c, "token name")
+	if err != nil {
+		return err
+	}
+
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	jt, err := cl.RevokeJoinToken(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	return newPrinter(c).printJoinTokens([]registrar.JoinToken{*jt})
+}
+
+// leases returns the leased tunnel addresses, with the nodes of the
+// devices they're leased to
+func leases(ctx context.Context, cl client) ([]lease, error) {
+	leases, err := cl.Leases(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get leases")
+	}
+
+	devices, err := cl.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLeases(leases, devices), nil
+}
+
+func listLeases(ctx context.Context, c *cli.Context) error {
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	l, err := leases(ctx, cl)
+	if err != nil {
+		return err
+	}
+
+	return newPrinter(c).printLeases(l)
+}
+
+func exportLeases(ctx context.Context, c *cli.Context) error {
+	cl, err := newClient(c)
+	if err != nil {
+		return err
+	}
+
+	l, err := leases(ctx, cl)
+	if err != nil {
+		return err
+	}
+
+	return writeHosts(c.App.Writer, l)
+}
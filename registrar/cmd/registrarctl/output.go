@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+)
+
+// output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// printer writes objects in the output format selected by the user
+type printer struct {
+	w      io.Writer
+	format string
+
+	// now is used to compute ages
+	now time.Time
+}
+
+// print writes obj as JSON or YAML, or as a table of rows under header
+func (p *printer) print(obj interface{}, header []string, rows [][]string) error {
+	switch p.format {
+	case outputJSON:
+		b, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(p.w, string(b))
+		return err
+	case outputYAML:
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.w.Write(b)
+		return err
+	case outputTable, "":
+		tw := tabwriter.NewWriter(p.w, 0, 8, 3, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format '%s', expected one of table, json or yaml", p.format)
+	}
+}
+
+// age returns how long ago t was, or <none> if it's not set
+func (p *printer) age(t *metav1.Time) string {
+	if t == nil || t.IsZero() {
+		return "<none>"
+	}
+	return duration.HumanDuration(p.now.Sub(t.Time))
+}
+
+// orNone returns s, or <none> if it's empty
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+func (p *printer) printDevices(devices []registrar.Device) error {
+	rows := make([][]string, 0, len(devices))
+	for i := range devices {
+		d := &devices[i]
+
+		var ip, node string
+		if d.Status.WireGuard != nil {
+			ip = d.Status.WireGuard.IPAddress
+		}
+		if d.Status.Node != nil {
+			node = d.Status.Node.Name
+		}
+
+		rows = append(rows, []string{
+			d.Name,
+			orNone(string(d.Status.Phase)),
+			orNone(string(d.Spec.Approval)),
+			orNone(ip),
+			orNone(node),
+			p.age(d.Status.LastSeen),
+			p.age(&d.CreationTimestamp),
+		})
+	}
+
+	return p.print(devices, []string{"NAME", "PHASE", "APPROVAL", "TUNNEL IP", "NODE", "LAST SEEN", "AGE"}, rows)
+}
+
+func (p *printer) printJoinTokens(tokens []registrar.JoinToken) error {
+	rows := make([][]string, 0, len(tokens))
+	for i := range tokens {
+		jt := &tokens[i]
+
+		maxUses := "unlimited"
+		if jt.Spec.MaxUses != 0 {
+			maxUses = strconv.Itoa(jt.Spec.MaxUses)
+		}
+
+		expires := "never"
+		if jt.Spec.Expires != nil {
+			if jt.Spec.Expires.Time.After(p.now) {
+				expires = "in " + duration.HumanDuration(jt.Spec.Expires.Time.Sub(p.now))
+			} else {
+				expires = "expired"
+			}
+		}
+
+		rows = append(rows, []string{
+			jt.Name,
+			strconv.Itoa(jt.Status.Uses),
+			maxUses,
+			orNone(jt.Spec.Profile),
+			expires,
+			p.age(&jt.CreationTimestamp),
+		})
+	}
+
+	return p.print(tokens, []string{"NAME", "USES", "MAX USES", "PROFILE", "EXPIRES", "AGE"}, rows)
+}
+
+// lease is a tunnel address leased to a device
+type lease struct {
+	IP     string `json:"ip"`
+	Device string `json:"device"`
+	Node   string `json:"node,omitempty"`
+}
+
+// newLeases returns the leases sorted by address, with the nodes of the
+// devices they're leased to
+func newLeases(leases map[string]string, devices []registrar.Device) []lease {
+	nodes := make(map[string]string, len(devices))
+	for i := range devices {
+		if n := devices[i].Status.Node; n != nil {
+			nodes[devices[i].Name] = n.Name
+		}
+	}
+
+	l := make([]lease, 0, len(leases))
+	for ip, owner := range leases {
+		l = append(l, lease{IP: ip, Device: owner, Node: nodes[owner]})
+	}
+
+	sort.Slice(l, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(l[i].IP).To16(), net.ParseIP(l[j].IP).To16()) < 0
+	})
+	return l
+}
+
+func (p *printer) printLeases(leases []lease) error {
+	rows := make([][]string, 0, len(leases))
+	for _, l := range leases {
+		rows = append(rows, []string{l.IP, l.Device, orNone(l.Node)})
+	}
+
+	return p.print(leases, []string{"IP", "DEVICE", "NODE"}, rows)
+}
+
+// writeHosts writes leases in the format of /etc/hosts, naming addresses
+// after the node of their device, and the device itself
+func writeHosts(w io.Writer, leases []lease) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(tw, "# tunnel addresses leased by registrard")
+	for _, l := range leases {
+		names := l.Device
+		if l.Node != "" && l.Node != l.Device {
+			names = l.Node + " " + l.Device
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", l.IP, names)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPrintDevices(t *testing.T) {
+	now := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	devices := []registrar.Device{{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id", CreationTimestamp: metav1.NewTime(now.Add(-2 * time.Hour))},
+		Spec:       registrar.DeviceSpec{Approval: registrar.DeviceApproved},
+		Status: registrar.DeviceStatus{
+			Phase:     registrar.DevicePhaseActive,
+			WireGuard: &registrar.WireGuardStatus{IPAddress: "10.10.0.2"},
+		},
+	}}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{outputTable, "" +
+			"NAME        PHASE    APPROVAL   TUNNEL IP   NODE     LAST SEEN   AGE\n" +
+			"device-id   Active   Approved   10.10.0.2   <none>   <none>      120m\n"},
+		{outputYAML, "" +
+			"- metadata:\n" +
+			"    creationTimestamp: \"2020-09-01T10:00:00Z\"\n" +
+			"    name: device-id\n" +
+			"  spec:\n" +
+			"    approval: Approved\n" +
+			"  status:\n" +
+			"    phase: Active\n" +
+			"    registered: false\n" +
+			"    wireguard:\n" +
+			"      ipAddress: 10.10.0.2\n" +
+			"      publicKey: \"\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b bytes.Buffer
+			p := &printer{w: &b, format: tt.format, now: now}
+			if err := p.printDevices(devices); err != nil {
+				t.Fatalf("failed to print devices: %v", err)
+			}
+
+			if b.String() != tt.want {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.want, b.String())
+			}
+		})
+	}
+
+	p := &printer{w: &bytes.Buffer{}, format: "xml", now: now}
+	if err := p.printDevices(devices); err == nil {
+		t.Error("expected an unknown output format to fail")
+	}
+}
+
+func TestWriteHosts(t *testing.T) {
+	leases := newLeases(map[string]string{
+		"10.10.0.10": "other",
+		"10.10.0.2":  "device-id",
+	}, []registrar.Device{{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id"},
+		Status:     registrar.DeviceStatus{Node: &registrar.NodeStatus{Name: "node"}},
+	}})
+
+	var b bytes.Buffer
+	if err := writeHosts(&b, leases); err != nil {
+		t.Fatalf("failed to write hosts: %v", err)
+	}
+
+	want := "" +
+		"# tunnel addresses leased by registrard\n" +
+		"10.10.0.2  node device-id\n" +
+		"10.10.0.10 other\n"
+	if b.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/tritonmedia/pkg/app"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// newClient returns the client selected by the global flags. It uses
+// registrard's admin api for decommissioning devices when
+// --registrard-host is set.
+func newClient(c *cli.Context) (client, error) {
+	cl, err := newCRDClient(c.String("namespace"), c.String("tunnel-cidr"))
+	if err != nil {
+		return nil, err
+	}
+
+	host := c.String("registrard-host")
+	if host == "" {
+		return cl, nil
+	}
+
+	opts := []grpc.DialOption{grpc.WithPerRPCCredentials(&api.AdminCredentials{
+		Token:         c.String("admin-token"),
+		AllowInsecure: !c.Bool("registrard-enable-tls"),
+	})}
+	if c.Bool("registrard-enable-tls") {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	// the connection is only closed once we exit
+	conn, err := grpc.DialContext(c.Context, host, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to registrard")
+	}
+	cl.admin = api.NewRegistrarAdminClient(conn)
+
+	return cl, nil
+}
+
+// newPrinter returns a printer for the output format selected by the
+// global flags
+func newPrinter(c *cli.Context) *printer {
+	return &printer{w: c.App.Writer, format: c.String("output"), now: time.Now()}
+}
+
+func main() { //nolint:funlen
+	ctx := context.Background()
+
+	app := cli.App{
+		Name:    "registrarctl",
+		Usage:   "Manage the devices, join tokens and tunnel addresses of registrard",
+		Version: app.Version,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "namespace",
+				Usage:   "Namespace registrard stores it's state in",
+				EnvVars: []string{"REGISTRAR_NAMESPACE"},
+				Value:   "registrar",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "Output format, one of table, json or yaml",
+				Value:   outputTable,
+			},
+			&cli.StringFlag{
+				Name:    "tunnel-cidr",
+				Usage:   "Network registrard leases tunnel addresses from",
+				EnvVars: []string{"WIREGUARD_CIDR"},
+				Value:   "10.10.0.0/24",
+			},
+			&cli.StringFlag{
+				Name:    "registrard-host",
+				Usage:   "Address of registrard's admin api, which devices are decommissioned through",
+				EnvVars: []string{"REGISTRARD_HOST"},
+			},
+			&cli.BoolFlag{
+				Name:    "registrard-enable-tls",
+				Usage:   "Enable TLS when talking to registrard",
+				EnvVars: []string{"REGISTRARD_ENABLE_TLS"},
+			},
+			&cli.StringFlag{
+				Name:    "admin-token",
+				Usage:   "Token of registrard's admin api",
+				EnvVars: []string{"REGISTRARD_ADMIN_TOKEN"},
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "devices",
+				Usage: "Manage devices",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List all devices",
+						Action: func(c *cli.Context) error {
+							return listDevices(ctx, c)
+						},
+					},
+					{
+						Name:      "get",
+						Usage:     "Show a device",
+						ArgsUsage: "<device>",
+						Action: func(c *cli.Context) error {
+							return getDevice(ctx, c)
+						},
+					},
+					{
+						Name:      "approve",
+						Usage:     "Allow a device to join the cluster",
+						ArgsUsage: "<device>",
+						Action: func(c *cli.Context) error {
+							return approveDevice(ctx, c)
+						},
+					},
+					{
+						Name:      "reject",
+						Usage:     "Prevent a device from joining the cluster",
+						ArgsUsage: "<device>",
+						Action: func(c *cli.Context) error {
+							return rejectDevice(ctx, c)
+						},
+					},
+					{
+						Name:      "decommission",
+						Usage:     "Remove a device from the cluster and revoke it's credentials",
+						ArgsUsage: "<device>",
+						Action: func(c *cli.Context) error {
+							return decommissionDevice(ctx, c)
+						},
+					},
+				},
+			},
+			{
+				Name:  "tokens",
+				Usage: "Manage join tokens",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "create",
+						Usage: "Create a join token",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "ttl",
+								Usage: "How long the token is valid for, 0 never expires",
+								Value: 24 * time.Hour,
+							},
+							&cli.IntFlag{
+								Name:  "max-uses",
+								Usage: "How many devices can join using the token, 0 is unlimited",
+							},
+							&cli.StringFlag{
+								Name:  "profile",
+								Usage: "Profile applied to devices that join using the token",
+							},
+							&cli.StringSliceFlag{
+								Name:  "label",
+								Usage: "Label, in the form of key=value, applied to devices that join using the token",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							return createJoinToken(ctx, c)
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List all join tokens",
+						Action: func(c *cli.Context) error {
+							return listJoinTokens(ctx, c)
+						},
+					},
+					{
+						Name:      "revoke",
+						Usage:     "Expire a join token, so no more devices can join using it",
+						ArgsUsage: "<token name>",
+						Action: func(c *cli.Context) error {
+							return revokeJoinToken(ctx, c)
+						},
+					},
+				},
+			},
+			{
+				Name:  "ips",
+				Usage: "Show leased tunnel addresses",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List all leased tunnel addresses",
+						Action: func(c *cli.Context) error {
+							return listLeases(ctx, c)
+						},
+					},
+					{
+						Name:  "export",
+						Usage: "Export leased tunnel addresses in the format of /etc/hosts",
+						Action: func(c *cli.Context) error {
+							return exportLeases(ctx, c)
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatalf("failed to run: %v", err)
+	}
+}
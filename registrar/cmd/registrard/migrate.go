@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/kube"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/storage"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// migrate copies the state of a standalone registrard from it's file into
+// the cluster, so it can be run in the cluster from then on
+func migrate(ctx context.Context, c *cli.Context) error {
+	from, err := storage.OpenFile(c.String("from"))
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+
+	conf, err := kube.New()
+	if err != nil {
+		return errors.Wrap(err, "failed to create kube config")
+	}
+
+	k, err := v1alpha1.NewForConfig(conf)
+	if err != nil {
+		return errors.Wrap(err, "failed to create kubernetes and registrar clientset")
+	}
+
+	ns := c.String("namespace")
+	if err := storage.Migrate(ctx, from, storage.NewKubernetes(k, ns), ns); err != nil {
+		return errors.Wrap(err, "failed to migrate")
+	}
+
+	log.Infof("migrated '%s' into namespace '%s'", c.String("from"), ns)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"syscall"
 
 	"github.com/jaredallard-home/worker-nodes/registrar/internal/registrard"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/storage"
 	"github.com/sirupsen/logrus"
 	"github.com/tritonmedia/pkg/app"
 	"github.com/tritonmedia/pkg/service"
@@ -20,13 +21,35 @@ func main() {
 	app := cli.App{
 		Name:    "registrar",
 		Version: app.Version,
+		Commands: []*cli.Command{
+			{
+				Name:  "migrate",
+				Usage: "Copy the state of a standalone registrard into the cluster",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "from",
+						Usage:   "Path of the file the standalone registrard stored it's state in",
+						EnvVars: []string{"STORAGE_PATH"},
+						Value:   storage.DefaultFilePath,
+					},
+					&cli.StringFlag{
+						Name:  "namespace",
+						Usage: "Namespace registrard runs in",
+						Value: "registrar",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return migrate(ctx, c)
+				},
+			},
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 		r := service.NewServiceRunner(ctx, []service.Service{
 			&registrard.ShutdownService{},
 			&registrard.GRPCService{},
 		})
-		sigC := make(chan os.Signal)
+		sigC := make(chan os.Signal, 1)
 
 		// listen for signals that we want to cancel on, and cancel
 		// the context if one is passed
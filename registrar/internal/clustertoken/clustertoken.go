@@ -0,0 +1,228 @@
+// Package clustertoken provides the token devices join the cluster with.
+package clustertoken
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTTL is how long a token fetched from Rancher is cached for
+	DefaultTTL = 10 * time.Minute
+
+	// readyPolls is how many times a clusterregistrationtoken is polled
+	// for the token Rancher generates after creating it
+	readyPolls = 5
+)
+
+// ErrNoToken is returned when Rancher hasn't generated a token yet
+var ErrNoToken = errors.New("no cluster token is available")
+
+// Source returns the token devices join the cluster with
+type Source interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// Static is a token that never changes, e.g. from CLUSTER_TOKEN
+type Static string
+
+// Token returns the static token
+func (s Static) Token(ctx context.Context) (string, error) {
+	return string(s), nil
+}
+
+// fallback uses it's secondary source when the primary one fails and the
+// secondary one has a token
+type fallback struct {
+	primary   Source
+	secondary Source
+}
+
+// WithFallback returns a source that uses secondary when primary fails
+func WithFallback(primary, secondary Source) Source {
+	return &fallback{primary, secondary}
+}
+
+func (f *fallback) Token(ctx context.Context) (string, error) {
+	token, err := f.primary.Token(ctx)
+	if err == nil {
+		return token, nil
+	}
+
+	secondary, serr := f.secondary.Token(ctx)
+	if serr != nil || secondary == "" {
+		return "", err
+	}
+
+	log.WithError(err).Warn("failed to get cluster token, using fallback")
+	return secondary, nil
+}
+
+// RancherClient is the part of the rancher API used to get registration
+// tokens
+type RancherClient interface {
+	ListClusterRegistrationTokens(ctx context.Context, clusterID string) ([]rancher.ClusterRegistrationTokenData, error)
+	GetClusterRegistrationToken(ctx context.Context, id string) (*rancher.ClusterRegistrationTokenData, error)
+	CreateClusterRegistrationToken(ctx context.Context, clusterID string) (*rancher.ClusterRegistrationTokenData, error)
+}
+
+// Rancher returns the registration token of a Rancher cluster, creating
+// a clusterregistrationtoken if the cluster doesn't have one. Tokens are
+// cached for a TTL, and only one request fetches them at a time.
+type Rancher struct {
+	c         RancherClient
+	clusterID string
+	ttl       time.Duration
+
+	// now returns the current time and pollInterval is how long to wait
+	// between polls of a clusterregistrationtoken, they're replaced in tests
+	now          func() time.Time
+	pollInterval time.Duration
+
+	lock    sync.Mutex
+	crt     *rancher.ClusterRegistrationTokenData
+	expires time.Time
+	fetch   *fetchCall
+}
+
+// fetchCall is a fetch of the token from Rancher, requests that need the
+// token while it's running wait for it's result
+type fetchCall struct {
+	done chan struct{}
+	crt  *rancher.ClusterRegistrationTokenData
+	err  error
+}
+
+// NewRancher returns a source for the registration token of a cluster,
+// cached for ttl
+func NewRancher(c RancherClient, clusterID string, ttl time.Duration) *Rancher {
+	return &Rancher{c: c, clusterID: clusterID, ttl: ttl, now: time.Now, pollInterval: time.Second}
+}
+
+// Token returns the cached token, fetching it from Rancher when the cache
+// has expired. If Rancher can't be reached the expired token is used.
+func (r *Rancher) Token(ctx context.Context) (string, error) {
+	crt, err := r.Registration(ctx)
+	if err != nil {
+		return "", err
+	}
+	return crt.Token, nil
+}
+
+// Registration returns the cached clusterregistrationtoken the token comes
+// from, which also has the command nodes join the cluster with. It's
+// shared, so it must not be modified.
+func (r *Rancher) Registration(ctx context.Context) (*rancher.ClusterRegistrationTokenData, error) {
+	r.lock.Lock()
+	if r.crt != nil && r.now().Before(r.expires) {
+		defer r.lock.Unlock()
+		return r.crt, nil
+	}
+
+	// Rancher isn't called with the lock held, so the cached token can
+	// still be used while it's slow
+	call := r.fetch
+	if call == nil {
+		call = &fetchCall{done: make(chan struct{})}
+		r.fetch = call
+		r.lock.Unlock()
+
+		call.crt, call.err = r.fetchToken(ctx)
+
+		r.lock.Lock()
+		r.fetch = nil
+		if call.err == nil {
+			r.crt = call.crt
+			r.expires = r.now().Add(r.ttl)
+		}
+		close(call.done)
+	}
+	r.lock.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if call.err != nil {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		if r.crt != nil {
+			log.WithError(call.err).Warn("failed to refresh cluster token from rancher, using cached token")
+			return r.crt, nil
+		}
+		return nil, call.err
+	}
+
+	return call.crt, nil
+}
+
+// fetchToken returns a registration token of the cluster, creating one if
+// there isn't any. Rancher generates the token of a clusterregistrationtoken
+// after it's created, so one without a token is waited for instead of
+// creating another.
+func (r *Rancher) fetchToken(ctx context.Context) (*rancher.ClusterRegistrationTokenData, error) {
+	tokens, err := r.c.ListClusterRegistrationTokens(ctx, r.clusterID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cluster registration tokens")
+	}
+
+	var pending *rancher.ClusterRegistrationTokenData
+	for i := range tokens {
+		if tokens[i].ClusterID != r.clusterID {
+			continue
+		}
+
+		if tokens[i].Token != "" {
+			return &tokens[i], nil
+		}
+
+		if pending == nil {
+			pending = &tokens[i]
+		}
+	}
+
+	if pending == nil {
+		log.Infof("cluster '%s' has no registration token, creating one", r.clusterID)
+		pending, err = r.c.CreateClusterRegistrationToken(ctx, r.clusterID)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create cluster registration token")
+		}
+
+		if pending.Token != "" {
+			return pending, nil
+		}
+	}
+
+	return r.waitForToken(ctx, pending.ID)
+}
+
+// waitForToken polls a clusterregistrationtoken until Rancher generated
+// it's token
+func (r *Rancher) waitForToken(ctx context.Context, id string) (*rancher.ClusterRegistrationTokenData, error) {
+	for i := 0; i < readyPolls; i++ {
+		select {
+		case <-time.After(r.pollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		crt, err := r.c.GetClusterRegistrationToken(ctx, id)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get cluster registration token")
+		}
+
+		if crt.Token != "" {
+			return crt, nil
+		}
+	}
+
+	return nil, errors.Wrapf(ErrNoToken, "cluster registration token '%s' isn't ready yet", id)
+}
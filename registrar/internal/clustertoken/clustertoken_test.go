@@ -0,0 +1,212 @@
+package clustertoken
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	"github.com/pkg/errors"
+)
+
+// fakeRancher is an in-memory rancher API. Tokens it creates are only
+// generated after they've been polled readyAfter times.
+type fakeRancher struct {
+	lock       sync.Mutex
+	tokens     []rancher.ClusterRegistrationTokenData
+	err        error
+	readyAfter int
+	gets       int
+	polls      int
+	creates    int
+}
+
+func (f *fakeRancher) ListClusterRegistrationTokens(ctx context.Context, clusterID string) ([]rancher.ClusterRegistrationTokenData, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.gets++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var tokens []rancher.ClusterRegistrationTokenData
+	for _, t := range f.tokens {
+		if t.ClusterID == clusterID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+func (f *fakeRancher) GetClusterRegistrationToken(ctx context.Context, id string) (*rancher.ClusterRegistrationTokenData, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.polls++
+	for i := range f.tokens {
+		if f.tokens[i].ID != id {
+			continue
+		}
+
+		if f.polls >= f.readyAfter && f.tokens[i].Token == "" {
+			f.tokens[i].Token = "generated-" + id
+		}
+		t := f.tokens[i]
+		return &t, nil
+	}
+	return nil, &rancher.Error{StatusCode: 404}
+}
+
+func (f *fakeRancher) CreateClusterRegistrationToken(ctx context.Context, clusterID string) (*rancher.ClusterRegistrationTokenData, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.creates++
+	t := rancher.ClusterRegistrationTokenData{
+		ID:        fmt.Sprintf("%s:crt-%d", clusterID, f.creates),
+		ClusterID: clusterID,
+	}
+	f.tokens = append(f.tokens, t)
+	return &t, nil
+}
+
+// newTestRancher returns a source for the token of c-abcde that doesn't
+// wait between polls
+func newTestRancher(f *fakeRancher) *Rancher {
+	r := NewRancher(f, "c-abcde", time.Minute)
+	r.pollInterval = time.Millisecond
+	return r
+}
+
+func TestRancherCachesToken(t *testing.T) {
+	f := &fakeRancher{tokens: []rancher.ClusterRegistrationTokenData{
+		{ClusterID: "other", Token: "other-token"},
+		{ClusterID: "c-abcde", Token: "token"},
+	}}
+
+	now := time.Now()
+	r := NewRancher(f, "c-abcde", time.Minute)
+	r.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		token, err := r.Token(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get token: %v", err)
+		}
+
+		if token != "token" {
+			t.Errorf("expected the token of the cluster, got %q", token)
+		}
+	}
+
+	if f.gets != 1 {
+		t.Errorf("expected the token to be cached, rancher was called %d times", f.gets)
+	}
+
+	// once the ttl expires it's fetched again, but the cached token is
+	// used when rancher fails
+	now = now.Add(2 * time.Minute)
+	f.err = errors.New("unavailable")
+	if token, err := r.Token(context.Background()); err != nil || token != "token" {
+		t.Errorf("expected the cached token when rancher fails, got %q, %v", token, err)
+	}
+
+	if f.gets != 2 {
+		t.Errorf("expected the token to be refreshed, rancher was called %d times", f.gets)
+	}
+}
+
+func TestRancherCreatesToken(t *testing.T) {
+	f := &fakeRancher{readyAfter: 2}
+	r := newTestRancher(f)
+
+	token, err := r.Token(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+
+	if token != "generated-c-abcde:crt-1" || f.creates != 1 {
+		t.Errorf("expected a token to be created, got %q after %d creates", token, f.creates)
+	}
+}
+
+func TestRancherWaitsForToken(t *testing.T) {
+	// rancher hasn't generated the token of the clusterregistrationtoken
+	// yet, so it's polled instead of creating another
+	f := &fakeRancher{
+		tokens:     []rancher.ClusterRegistrationTokenData{{ID: "c-abcde:crt-0", ClusterID: "c-abcde"}},
+		readyAfter: readyPolls + 1,
+	}
+	r := newTestRancher(f)
+
+	if _, err := r.Token(context.Background()); !errors.Is(err, ErrNoToken) {
+		t.Errorf("expected the token to not be ready, got %v", err)
+	}
+
+	token, err := r.Token(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get token: %v", err)
+	}
+
+	if token != "generated-c-abcde:crt-0" || f.creates != 0 {
+		t.Errorf("expected the existing token to be used, got %q after %d creates", token, f.creates)
+	}
+}
+
+func TestRancherFetchesOnce(t *testing.T) {
+	f := &fakeRancher{readyAfter: 3}
+	r := newTestRancher(f)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.Token(context.Background()); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("failed to get token: %v", err)
+	}
+
+	if f.gets != 1 || f.creates != 1 {
+		t.Errorf("expected the token to be fetched once, got %d lists and %d creates", f.gets, f.creates)
+	}
+}
+
+func TestWithFallback(t *testing.T) {
+	failing := NewRancher(&fakeRancher{err: errors.New("unavailable")}, "c-abcde", time.Minute)
+
+	tests := []struct {
+		name    string
+		source  Source
+		want    string
+		wantErr bool
+	}{
+		{"primary", WithFallback(Static("primary"), Static("secondary")), "primary", false},
+		{"fallback", WithFallback(failing, Static("secondary")), "secondary", false},
+		{"empty fallback", WithFallback(failing, Static("")), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.source.Token(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
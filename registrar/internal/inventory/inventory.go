@@ -0,0 +1,247 @@
+// Package inventory collects hardware facts about the machine we're
+// running on from procfs and sysfs.
+package inventory
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Inventory is the hardware of a machine
+type Inventory struct {
+	// Architecture is the GOARCH of the machine
+	Architecture string
+
+	// Variant is the ARM variant, e.g. v7, if the machine is ARM based
+	Variant string
+
+	// Model is the board model, if the machine has a device tree
+	Model string
+
+	// Serial is the serial number of the board, e.g. of a Raspberry Pi
+	Serial string
+
+	// MemoryBytes is the total amount of memory
+	MemoryBytes uint64
+
+	// CPUs is the number of CPUs
+	CPUs int
+
+	// Disks are the block devices of the machine
+	Disks []Disk
+
+	// Interfaces are the physical network interfaces of the machine
+	Interfaces []NetworkInterface
+}
+
+// Disk is a block device
+type Disk struct {
+	Name      string
+	Model     string
+	SizeBytes uint64
+}
+
+// NetworkInterface is a physical network interface
+type NetworkInterface struct {
+	Name       string
+	MACAddress string
+}
+
+// ignoredDiskPrefixes are block devices that aren't disks
+var ignoredDiskPrefixes = []string{"loop", "ram", "zram", "dm-", "md", "nbd"}
+
+// Collect collects the inventory of this machine, reading procfs and sysfs
+// from under root
+func Collect(root string) (*Inventory, error) {
+	return collect(root, runtime.GOARCH)
+}
+
+func collect(root, arch string) (*Inventory, error) {
+	inv := &Inventory{Architecture: arch}
+
+	cpuinfo, err := parseCPUInfo(filepath.Join(root, "proc/cpuinfo"))
+	if err != nil {
+		return nil, err
+	}
+	inv.Serial = cpuinfo.serial
+	inv.CPUs = cpuinfo.processors
+	if inv.CPUs == 0 {
+		inv.CPUs = runtime.NumCPU()
+	}
+
+	if strings.HasPrefix(arch, "arm") && cpuinfo.architecture != "" {
+		inv.Variant = "v" + cpuinfo.architecture
+	}
+
+	// not every machine has a device tree
+	if b, err := ioutil.ReadFile(filepath.Join(root, "proc/device-tree/model")); err == nil {
+		inv.Model = strings.TrimSpace(string(bytes.TrimRight(b, "\x00")))
+	}
+
+	if inv.MemoryBytes, err = memTotal(filepath.Join(root, "proc/meminfo")); err != nil {
+		return nil, err
+	}
+
+	if inv.Disks, err = disks(filepath.Join(root, "sys/block")); err != nil {
+		return nil, err
+	}
+
+	if inv.Interfaces, err = interfaces(filepath.Join(root, "sys/class/net")); err != nil {
+		return nil, err
+	}
+
+	return inv, nil
+}
+
+type cpuInfo struct {
+	processors   int
+	architecture string
+	serial       string
+}
+
+// parseCPUInfo parses the fields we care about out of /proc/cpuinfo
+func parseCPUInfo(path string) (*cpuInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open cpuinfo")
+	}
+	defer f.Close()
+
+	info := &cpuInfo{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		switch key {
+		case "processor":
+			info.processors++
+		case "CPU architecture":
+			info.architecture = value
+		case "Serial":
+			info.serial = value
+		}
+	}
+
+	return info, errors.Wrap(scanner.Err(), "failed to read cpuinfo")
+}
+
+// memTotal returns the total memory, in bytes, from /proc/meminfo
+func memTotal(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read meminfo")
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to parse MemTotal")
+		}
+
+		return kb * 1024, nil
+	}
+
+	return 0, errors.New("MemTotal not found in meminfo")
+}
+
+// disks returns the block devices in /sys/block that are disks
+func disks(dir string) ([]Disk, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to list block devices")
+	}
+
+	disks := make([]Disk, 0)
+	for _, e := range entries {
+		if hasPrefix(e.Name(), ignoredDiskPrefixes) {
+			continue
+		}
+
+		// size is always in 512 byte sectors
+		sectors, err := readUint(filepath.Join(dir, e.Name(), "size"))
+		if err != nil || sectors == 0 {
+			continue
+		}
+
+		d := Disk{Name: e.Name(), SizeBytes: sectors * 512}
+		if b, err := ioutil.ReadFile(filepath.Join(dir, e.Name(), "device/model")); err == nil {
+			d.Model = strings.TrimSpace(string(b))
+		}
+
+		disks = append(disks, d)
+	}
+
+	sort.Slice(disks, func(i, j int) bool { return disks[i].Name < disks[j].Name })
+	return disks, nil
+}
+
+// interfaces returns the network interfaces in /sys/class/net that are
+// backed by a device
+func interfaces(dir string) ([]NetworkInterface, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to list network interfaces")
+	}
+
+	ifaces := make([]NetworkInterface, 0)
+	for _, e := range entries {
+		// virtual interfaces (bridges, veths, wireguard, ...) have no device
+		if _, err := os.Stat(filepath.Join(dir, e.Name(), "device")); err != nil {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(dir, e.Name(), "address"))
+		if err != nil {
+			continue
+		}
+
+		ifaces = append(ifaces, NetworkInterface{
+			Name:       e.Name(),
+			MACAddress: strings.TrimSpace(string(b)),
+		})
+	}
+
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+	return ifaces, nil
+}
+
+func readUint(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func hasPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,63 @@
+package inventory
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCollectRaspberryPi(t *testing.T) {
+	inv, err := collect("testdata/rpi4", "arm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Inventory{
+		Architecture: "arm",
+		Variant:      "v7",
+		Model:        "Raspberry Pi 4 Model B Rev 1.1",
+		Serial:       "10000000a1b2c3d4",
+		MemoryBytes:  3999784 * 1024,
+		CPUs:         4,
+		Disks: []Disk{
+			{Name: "mmcblk0", SizeBytes: 62333952 * 512},
+			{Name: "sda", Model: "Portable SSD", SizeBytes: 1953525168 * 512},
+		},
+		Interfaces: []NetworkInterface{
+			{Name: "eth0", MACAddress: "dc:a6:32:01:02:03"},
+			{Name: "wlan0", MACAddress: "dc:a6:32:01:02:04"},
+		},
+	}
+
+	if !reflect.DeepEqual(inv, want) {
+		t.Errorf("expected inventory:\n%+v\ngot:\n%+v", want, inv)
+	}
+}
+
+func TestCollectAMD64(t *testing.T) {
+	inv, err := collect("testdata/amd64", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Inventory{
+		Architecture: "amd64",
+		MemoryBytes:  16303444 * 1024,
+		CPUs:         2,
+		Disks: []Disk{
+			{Name: "nvme0n1", SizeBytes: 1000215216 * 512},
+		},
+		Interfaces: []NetworkInterface{
+			{Name: "enp3s0", MACAddress: "00:1f:c6:9b:aa:bb"},
+		},
+	}
+
+	if !reflect.DeepEqual(inv, want) {
+		t.Errorf("expected inventory:\n%+v\ngot:\n%+v", want, inv)
+	}
+}
+
+func TestCollectMissingProc(t *testing.T) {
+	if _, err := collect("testdata/does-not-exist", "amd64"); err == nil {
+		t.Errorf("expected an error when procfs is missing")
+	}
+}
@@ -0,0 +1,71 @@
+package ipam
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ Store = &ConfigMapStore{}
+)
+
+// ConfigMapStore stores an Allocation in a ConfigMap, using it's
+// resourceVersion to detect concurrent writers.
+type ConfigMapStore struct {
+	k         kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapStore returns a Store backed by the ConfigMap name in namespace
+func NewConfigMapStore(k kubernetes.Interface, namespace, name string) *ConfigMapStore {
+	return &ConfigMapStore{k, namespace, name}
+}
+
+// Get returns the allocation stored in the ConfigMap
+func (s *ConfigMapStore) Get(ctx context.Context) (*Allocation, error) {
+	cm, err := s.k.CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return &Allocation{Leases: make(map[string]string)}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	leases := make(map[string]string, len(cm.Data))
+	for ip, owner := range cm.Data {
+		leases[ip] = owner
+	}
+
+	return &Allocation{Version: cm.ResourceVersion, Leases: leases}, nil
+}
+
+// Update writes the allocation to the ConfigMap, creating it if
+// it doesn't exist yet
+func (s *ConfigMapStore) Update(ctx context.Context, a *Allocation) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            s.name,
+			Namespace:       s.namespace,
+			ResourceVersion: a.Version,
+		},
+		Data: a.Leases,
+	}
+
+	var err error
+	if a.Version == "" {
+		_, err = s.k.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	} else {
+		_, err = s.k.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	}
+
+	if kerrors.IsConflict(err) || kerrors.IsAlreadyExists(err) {
+		return ErrConflict
+	}
+	return errors.Wrap(err, "failed to write configmap")
+}
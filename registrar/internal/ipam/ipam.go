@@ -0,0 +1,248 @@
+// Package ipam implements IP address management for the tunnel network.
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrConflict is returned by a Store when an allocation was modified
+	// since it was read
+	ErrConflict = errors.New("allocation was modified concurrently")
+
+	// ErrExhausted is returned when there are no free addresses left
+	ErrExhausted = errors.New("no free addresses")
+
+	// ErrInvalidAddress is returned when reserving an address that's outside
+	// of the network or reserved, e.g. after the network changed
+	ErrInvalidAddress = errors.New("address can't be leased")
+)
+
+// maxRetries is the maximum number of times an operation is retried
+// when it conflicts with another writer
+const maxRetries = 10
+
+// Allocation is the set of all leases in a network
+type Allocation struct {
+	// Version is an opaque value used by a Store to detect concurrent
+	// modifications
+	Version string
+
+	// Leases maps an address to the ID of the device that owns it
+	Leases map[string]string
+}
+
+// Store persists an Allocation. Implementations must be safe to share
+// between multiple processes, e.g. by using optimistic concurrency.
+type Store interface {
+	// Get returns the current allocation, or an empty one if none exists
+	Get(ctx context.Context) (*Allocation, error)
+
+	// Update persists an allocation returned by Get. ErrConflict is returned
+	// if the allocation has changed since it was read.
+	Update(ctx context.Context, a *Allocation) error
+}
+
+// ConflictError is returned when an address is leased to another device
+type ConflictError struct {
+	IP    string
+	Owner string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("address %s is already leased to '%s'", e.IP, e.Owner)
+}
+
+// IPAM allocates addresses from a network
+type IPAM struct {
+	network *net.IPNet
+	hub     net.IP
+	store   Store
+
+	// mu serializes operations in this process, the store handles
+	// everyone else
+	mu sync.Mutex
+}
+
+// New creates a new IPAM for the network described by cidr. The first
+// usable address of the network is reserved for the hub.
+func New(cidr string, store Store) (*IPAM, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse cidr")
+	}
+
+	if network.IP.To4() == nil {
+		return nil, fmt.Errorf("only IPv4 networks are supported")
+	}
+
+	ones, bits := network.Mask.Size()
+	if bits-ones < 2 {
+		return nil, fmt.Errorf("network %s is too small", network)
+	}
+
+	return &IPAM{
+		network: network,
+		hub:     nextIP(network.IP.To4()),
+		store:   store,
+	}, nil
+}
+
+// Network returns the network addresses are allocated from
+func (i *IPAM) Network() *net.IPNet {
+	return i.network
+}
+
+// Hub returns the reserved address of the hub
+func (i *IPAM) Hub() net.IP {
+	return i.hub
+}
+
+// reserved returns true if an address can never be leased
+func (i *IPAM) reserved(ip net.IP) bool {
+	return ip.Equal(i.network.IP) || ip.Equal(i.hub) || ip.Equal(broadcast(i.network))
+}
+
+// update runs fn against the current allocation and persists the result,
+// retrying when another writer got there first.
+func (i *IPAM) update(ctx context.Context, fn func(a *Allocation) error) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for attempt := 0; attempt < maxRetries; attempt++ {
+		a, err := i.store.Get(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get allocation")
+		}
+		if a.Leases == nil {
+			a.Leases = make(map[string]string)
+		}
+
+		if err := fn(a); err != nil {
+			return err
+		}
+
+		err = i.store.Update(ctx, a)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, ErrConflict) {
+			return errors.Wrap(err, "failed to save allocation")
+		}
+	}
+
+	return ErrConflict
+}
+
+// Allocate leases an address to owner. If owner already has a lease, the
+// same address is returned.
+func (i *IPAM) Allocate(ctx context.Context, owner string) (net.IP, error) {
+	var leased net.IP
+	err := i.update(ctx, func(a *Allocation) error {
+		leased = nil
+		for ip, o := range a.Leases {
+			if o == owner {
+				leased = net.ParseIP(ip).To4()
+				return nil
+			}
+		}
+
+		for ip := nextIP(i.network.IP.To4()); i.network.Contains(ip); ip = nextIP(ip) {
+			if i.reserved(ip) {
+				continue
+			}
+
+			if _, ok := a.Leases[ip.String()]; !ok {
+				a.Leases[ip.String()] = owner
+				leased = ip
+				return nil
+			}
+		}
+
+		return ErrExhausted
+	})
+	return leased, err
+}
+
+// Reserve leases a specific address to owner. A *ConflictError is
+// returned if it is leased to someone else, and ErrInvalidAddress if it
+// can't be leased at all.
+func (i *IPAM) Reserve(ctx context.Context, owner string, ip net.IP) error {
+	ip = ip.To4()
+	if ip == nil || !i.network.Contains(ip) {
+		return errors.Wrapf(ErrInvalidAddress, "address %s is not in %s", ip, i.network)
+	}
+
+	if i.reserved(ip) {
+		return errors.Wrapf(ErrInvalidAddress, "address %s is reserved", ip)
+	}
+
+	return i.update(ctx, func(a *Allocation) error {
+		if o, ok := a.Leases[ip.String()]; ok && o != owner {
+			return &ConflictError{IP: ip.String(), Owner: o}
+		}
+
+		// drop any other lease owner had
+		for leasedIP, o := range a.Leases {
+			if o == owner {
+				delete(a.Leases, leasedIP)
+			}
+		}
+
+		a.Leases[ip.String()] = owner
+		return nil
+	})
+}
+
+// Release releases all addresses leased to owner
+func (i *IPAM) Release(ctx context.Context, owner string) error {
+	return i.update(ctx, func(a *Allocation) error {
+		for ip, o := range a.Leases {
+			if o == owner {
+				delete(a.Leases, ip)
+			}
+		}
+		return nil
+	})
+}
+
+// Leases returns a copy of all of the current leases, keyed by address
+func (i *IPAM) Leases(ctx context.Context) (map[string]string, error) {
+	a, err := i.store.Get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get allocation")
+	}
+
+	leases := make(map[string]string, len(a.Leases))
+	for ip, o := range a.Leases {
+		leases[ip] = o
+	}
+	return leases, nil
+}
+
+// nextIP returns the address after ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// broadcast returns the broadcast address of a network
+func broadcast(n *net.IPNet) net.IP {
+	ip := n.IP.To4()
+	b := make(net.IP, len(ip))
+	for i := range ip {
+		b[i] = ip[i] | ^n.Mask[i]
+	}
+	return b
+}
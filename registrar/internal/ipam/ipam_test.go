@@ -0,0 +1,214 @@
+package ipam
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// memoryStore is an in-memory Store that detects concurrent writers
+type memoryStore struct {
+	mu      sync.Mutex
+	version int
+	leases  map[string]string
+}
+
+func (s *memoryStore) Get(ctx context.Context) (*Allocation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leases := make(map[string]string)
+	for ip, o := range s.leases {
+		leases[ip] = o
+	}
+	return &Allocation{Version: strconv.Itoa(s.version), Leases: leases}, nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, a *Allocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a.Version != strconv.Itoa(s.version) {
+		return ErrConflict
+	}
+	s.version++
+	s.leases = a.Leases
+	return nil
+}
+
+func TestAllocate(t *testing.T) {
+	ctx := context.Background()
+	i, err := New("10.10.0.0/24", &memoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := i.Hub().String(); got != "10.10.0.1" {
+		t.Errorf("expected hub to be 10.10.0.1, got %s", got)
+	}
+
+	ip, err := i.Allocate(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "10.10.0.2" {
+		t.Errorf("expected first lease to be 10.10.0.2, got %s", ip)
+	}
+
+	again, err := i.Allocate(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !again.Equal(ip) {
+		t.Errorf("expected re-allocation to return %s, got %s", ip, again)
+	}
+
+	other, err := i.Allocate(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Equal(ip) {
+		t.Errorf("expected different devices to get different addresses")
+	}
+
+	if err := i.Release(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	reused, err := i.Allocate(ctx, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reused.Equal(ip) {
+		t.Errorf("expected released address %s to be reused, got %s", ip, reused)
+	}
+}
+
+func TestAllocateExhausted(t *testing.T) {
+	ctx := context.Background()
+
+	// .0 is the network, .1 the hub, .3 the broadcast address
+	i, err := New("10.10.0.0/30", &memoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := i.Allocate(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := i.Allocate(ctx, "b"); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected ErrExhausted, got %v", err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	ctx := context.Background()
+	i, err := New("10.10.0.0/24", &memoryStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := i.Reserve(ctx, "a", net.ParseIP("10.10.0.10")); err != nil {
+		t.Fatal(err)
+	}
+
+	var cerr *ConflictError
+	if err := i.Reserve(ctx, "b", net.ParseIP("10.10.0.10")); !errors.As(err, &cerr) {
+		t.Errorf("expected a ConflictError, got %v", err)
+	} else if cerr.Owner != "a" {
+		t.Errorf("expected conflict with 'a', got '%s'", cerr.Owner)
+	}
+
+	if err := i.Reserve(ctx, "b", i.Hub()); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("expected reserving the hub address to fail")
+	}
+
+	if err := i.Reserve(ctx, "b", net.ParseIP("10.11.0.10")); !errors.Is(err, ErrInvalidAddress) {
+		t.Errorf("expected reserving an address outside of the network to fail")
+	}
+
+	ip, err := i.Allocate(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "10.10.0.10" {
+		t.Errorf("expected reserved address to be returned, got %s", ip)
+	}
+}
+
+func TestAllocateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+
+	// two IPAMs sharing a store act like two registrard replicas
+	replicas := make([]*IPAM, 2)
+	for r := range replicas {
+		var err error
+		replicas[r], err = New("10.10.0.0/24", store)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	ips := make([]net.IP, 20)
+	errs := make([]error, len(ips))
+	for n := range ips {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			ips[n], errs[n] = replicas[n%2].Allocate(ctx, strconv.Itoa(n))
+		}(n)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for n, ip := range ips {
+		if errs[n] != nil {
+			// retries are bounded, so heavy contention may fail, but never
+			// hand out a duplicate
+			continue
+		}
+		if seen[ip.String()] {
+			t.Errorf("address %s was allocated twice", ip)
+		}
+		seen[ip.String()] = true
+	}
+}
+
+func TestConfigMapStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewConfigMapStore(fake.NewSimpleClientset(), "registrar", "registrard-ipam")
+
+	a, err := s.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Leases) != 0 {
+		t.Errorf("expected no leases, got %v", a.Leases)
+	}
+
+	a.Leases["10.10.0.2"] = "a"
+	if err := s.Update(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second create should be detected as a conflict
+	if err := s.Update(ctx, &Allocation{Leases: map[string]string{}}); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict, got %v", err)
+	}
+
+	a, err = s.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Leases["10.10.0.2"] != "a" {
+		t.Errorf("expected lease to be persisted, got %v", a.Leases)
+	}
+}
@@ -0,0 +1,79 @@
+// Package jointoken implements the format of join tokens.
+//
+// A join token is made up of the name of the JoinToken object it belongs
+// to and a secret, separated by a '.', e.g. `abcdef.0123456789abcdef`. Only
+// a hash of the secret is ever stored.
+package jointoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// nameLen is the length, in bytes, of a generated token name
+	nameLen = 3
+
+	// secretLen is the length, in bytes, of a generated secret
+	secretLen = 16
+)
+
+// Token is a join token
+type Token struct {
+	// Name is the name of the JoinToken object this token belongs to
+	Name string
+
+	// Secret is the secret part of the token
+	Secret string
+}
+
+// Generate creates a new random token
+func Generate() (*Token, error) {
+	b := make([]byte, nameLen+secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "failed to read random bytes")
+	}
+
+	return &Token{
+		Name:   hex.EncodeToString(b[:nameLen]),
+		Secret: hex.EncodeToString(b[nameLen:]),
+	}, nil
+}
+
+// Parse parses a token in the form of `<name>.<secret>`
+func Parse(s string) (*Token, error) {
+	spl := strings.SplitN(s, ".", 2)
+	if len(spl) != 2 || spl[0] == "" || spl[1] == "" {
+		return nil, fmt.Errorf("invalid token format")
+	}
+
+	return &Token{Name: spl[0], Secret: spl[1]}, nil
+}
+
+// String returns the token in the form of `<name>.<secret>`
+func (t *Token) String() string {
+	return t.Name + "." + t.Secret
+}
+
+// Hash returns the hex encoded SHA-256 hash of the secret of a token, this
+// is what should be stored.
+func (t *Token) Hash() string {
+	return HashSecret(t.Secret)
+}
+
+// HashSecret returns the hex encoded SHA-256 hash of a secret
+func HashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Verify returns true if secret matches the hash in constant time
+func Verify(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(hash)) == 1
+}
@@ -0,0 +1,35 @@
+package jointoken
+
+import "testing"
+
+func TestGenerateParse(t *testing.T) {
+	tok, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(tok.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *parsed != *tok {
+		t.Errorf("expected %+v, got %+v", tok, parsed)
+	}
+
+	if !Verify(parsed.Secret, tok.Hash()) {
+		t.Errorf("expected secret to match it's hash")
+	}
+
+	if Verify("not-the-secret", tok.Hash()) {
+		t.Errorf("expected a different secret to not match")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "abcdef", "abcdef.", ".secret"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// drainTimeout is how long we wait for pods to be evicted from a node
+	drainTimeout = 5 * time.Minute
+
+	// drainInterval is how often we check if pods have left a node
+	drainInterval = 5 * time.Second
+)
+
+// CordonNode marks a node as unschedulable
+func CordonNode(ctx context.Context, k kubernetes.Interface, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		n, err := k.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to get node")
+		}
+
+		if n.Spec.Unschedulable {
+			return nil
+		}
+
+		n.Spec.Unschedulable = true
+		_, err = k.CoreV1().Nodes().Update(ctx, n, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// DrainNode evicts all pods from a node, except for pods managed by a
+// DaemonSet and mirror pods, and waits for them to be gone
+func DrainNode(ctx context.Context, k kubernetes.Interface, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, drainTimeout)
+	defer cancel()
+
+	return wait.PollImmediateUntil(drainInterval, func() (bool, error) {
+		pods, err := drainablePods(ctx, k, name)
+		if err != nil {
+			return false, err
+		}
+
+		for i := range pods {
+			p := &pods[i]
+			err := k.CoreV1().Pods(p.Namespace).Evict(ctx, &policyv1beta1.Eviction{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      p.Name,
+					Namespace: p.Namespace,
+				},
+			})
+			if kerrors.IsTooManyRequests(err) {
+				// blocked by a PodDisruptionBudget, try again later
+				log.Warnf("eviction of pod '%s/%s' is blocked, retrying", p.Namespace, p.Name)
+			} else if err != nil && !kerrors.IsNotFound(err) {
+				return false, errors.Wrapf(err, "failed to evict pod '%s/%s'", p.Namespace, p.Name)
+			}
+		}
+
+		return len(pods) == 0, nil
+	}, ctx.Done())
+}
+
+// drainablePods returns the pods on a node that should be evicted
+func drainablePods(ctx context.Context, k kubernetes.Interface, name string) ([]corev1.Pod, error) {
+	podList, err := k.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", name).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list pods")
+	}
+
+	pods := make([]corev1.Pod, 0)
+	for _, p := range podList.Items {
+		if _, ok := p.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if ref := metav1.GetControllerOf(&p); ref != nil && ref.Kind == "DaemonSet" {
+			continue
+		}
+
+		pods = append(pods, p)
+	}
+
+	return pods, nil
+}
+
+// RemoveNode cordons, drains and deletes a node
+func RemoveNode(ctx context.Context, k kubernetes.Interface, name string) error {
+	log.Infof("cordoning node '%s'", name)
+	if err := CordonNode(ctx, k, name); err != nil {
+		return errors.Wrap(err, "failed to cordon node")
+	}
+
+	log.Infof("draining node '%s'", name)
+	if err := DrainNode(ctx, k, name); err != nil {
+		return errors.Wrap(err, "failed to drain node")
+	}
+
+	log.Infof("deleting node '%s'", name)
+	err := k.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete node")
+	}
+
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package pki implements the certificate authority registrard issues
+// device certificates from, and the device side of requesting them.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// CertificateValidity is how long a device certificate is valid for
+	CertificateValidity = 30 * 24 * time.Hour
+
+	// RenewBefore is how long before expiry a device certificate
+	// should be renewed
+	RenewBefore = 10 * 24 * time.Hour
+
+	// caValidity is how long the CA is valid for
+	caValidity = 10 * 365 * 24 * time.Hour
+
+	// CACommonName is the common name of the CA registrard creates
+	CACommonName = "registrard"
+)
+
+// ErrDifferentCA is returned when importing a CA where another one is
+// already stored
+var ErrDifferentCA = errors.New("a different ca is already stored")
+
+// CA is a certificate authority
+type CA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// NewCA creates a new self-signed CA
+func NewCA(commonName string) (*CA, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ca certificate")
+	}
+
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return LoadCA(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM)
+}
+
+// LoadCA loads a CA from a PEM encoded certificate and key
+func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ParseKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// CertificatePEM returns the PEM encoded certificate of the CA
+func (ca *CA) CertificatePEM() []byte {
+	return ca.certPEM
+}
+
+// KeyPEM returns the PEM encoded private key of the CA
+func (ca *CA) KeyPEM() ([]byte, error) {
+	return EncodeKey(ca.key)
+}
+
+// Pool returns a certificate pool containing only this CA
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Sign issues a client certificate for the device id using the public key
+// of a PEM encoded CSR. The subject of the CSR is ignored.
+func (ca *CA) Sign(csrPEM []byte, id string) (*x509.Certificate, []byte, error) {
+	b, _ := pem.Decode(csrPEM)
+	if b == nil || b.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, fmt.Errorf("failed to decode certificate request")
+	}
+
+	csr, err := x509.ParseCertificateRequest(b.Bytes)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse certificate request")
+	}
+
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid certificate request signature")
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(CertificateValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to sign certificate")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse signed certificate")
+	}
+
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// GenerateKey generates a new private key
+func GenerateKey() (crypto.Signer, error) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return k, errors.Wrap(err, "failed to generate key")
+}
+
+// EncodeKey PEM encodes a private key
+func EncodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseKey parses a PEM encoded private key
+func ParseKey(keyPEM []byte) (crypto.Signer, error) {
+	b, _ := pem.Decode(keyPEM)
+	if b == nil {
+		return nil, fmt.Errorf("failed to decode key")
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse key")
+	}
+
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", k)
+	}
+
+	return signer, nil
+}
+
+// ParseCertificate parses a PEM encoded certificate
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	b, _ := pem.Decode(certPEM)
+	if b == nil || b.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode certificate")
+	}
+
+	cert, err := x509.ParseCertificate(b.Bytes)
+	return cert, errors.Wrap(err, "failed to parse certificate")
+}
+
+// CreateCSR creates a PEM encoded certificate request for a device
+func CreateCSR(key crypto.Signer, id string) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: id},
+	}, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create certificate request")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// NeedsRenewal returns true if a PEM encoded certificate is invalid,
+// or is close to expiring
+func NeedsRenewal(certPEM []byte, now time.Time) bool {
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return true
+	}
+
+	return now.Add(RenewBefore).After(cert.NotAfter)
+}
+
+// SerialString returns the serial number of a certificate in the format
+// it's stored in on a Device
+func SerialString(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// newSerial returns a random certificate serial number
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial, errors.Wrap(err, "failed to generate serial number")
+}
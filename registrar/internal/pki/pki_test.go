@@ -0,0 +1,82 @@
+package pki
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSign(t *testing.T) {
+	ca, err := NewCA("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the subject of the csr should be ignored
+	csr, err := CreateCSR(key, "someone-else")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, certPEM, err := ca.Sign(csr, "device-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != "device-id" {
+		t.Errorf("expected common name 'device-id', got '%s'", cert.Subject.CommonName)
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     ca.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("expected certificate to be signed by the ca: %v", err)
+	}
+
+	if NeedsRenewal(certPEM, time.Now()) {
+		t.Errorf("expected a new certificate to not need renewal")
+	}
+
+	if !NeedsRenewal(certPEM, time.Now().Add(CertificateValidity-RenewBefore+time.Hour)) {
+		t.Errorf("expected certificate to need renewal close to expiry")
+	}
+}
+
+func TestSignInvalidCSR(t *testing.T) {
+	ca, err := NewCA("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ca.Sign([]byte("not a csr"), "device-id"); err == nil {
+		t.Errorf("expected an error for an invalid csr")
+	}
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	ctx := context.Background()
+	k := fake.NewSimpleClientset()
+
+	ca, err := LoadOrCreateCA(ctx, k, "registrar", "registrard-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadOrCreateCA(ctx, k, "registrar", "registrard-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(loaded.CertificatePEM()) != string(ca.CertificatePEM()) {
+		t.Errorf("expected the stored ca to be loaded")
+	}
+}
@@ -0,0 +1,83 @@
+package pki
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LoadOrCreateCA loads the CA stored in the Secret name in namespace,
+// creating a new CA if it doesn't exist. If another process creates the
+// CA at the same time, theirs is used.
+func LoadOrCreateCA(ctx context.Context, k kubernetes.Interface, namespace, name string) (*CA, error) {
+	sec, err := k.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return LoadCA(sec.Data[corev1.TLSCertKey], sec.Data[corev1.TLSPrivateKeyKey])
+	} else if !kerrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to get ca secret")
+	}
+
+	ca, err := NewCA(CACommonName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createCASecret(ctx, k, namespace, name, ca)
+	if kerrors.IsAlreadyExists(err) {
+		return LoadOrCreateCA(ctx, k, namespace, name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ca, nil
+}
+
+// ImportCA stores ca in the Secret name in namespace. If a CA is already
+// stored there, it has to be ca, otherwise ErrDifferentCA is returned.
+func ImportCA(ctx context.Context, k kubernetes.Interface, namespace, name string, ca *CA) error {
+	err := createCASecret(ctx, k, namespace, name, ca)
+	if !kerrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	existing, err := LoadOrCreateCA(ctx, k, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(existing.CertificatePEM(), ca.CertificatePEM()) {
+		return ErrDifferentCA
+	}
+
+	return nil
+}
+
+// createCASecret creates the Secret name in namespace, storing ca in it
+func createCASecret(ctx context.Context, k kubernetes.Interface, namespace, name string, ca *CA) error {
+	keyPEM, err := ca.KeyPEM()
+	if err != nil {
+		return err
+	}
+
+	_, err = k.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       ca.CertificatePEM(),
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	return errors.Wrap(err, "failed to create ca secret")
+}
@@ -0,0 +1,317 @@
+package registrard
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+)
+
+var (
+	errAdminDisabled      = status.Error(codes.Unimplemented, "admin api is disabled, REGISTRARD_ADMIN_TOKEN isn't set")
+	errInvalidAdminToken  = status.Error(codes.Unauthenticated, "invalid admin token")
+	errAdminTokenRequired = status.Error(codes.Unauthenticated, "admin token is required")
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ api.RegistrarAdminServer = &adminServer{}
+)
+
+// adminServer implements the RegistrarAdmin service on top of a Server.
+// It's authenticated with the admin token, not device credentials.
+type adminServer struct {
+	s *Server
+}
+
+// authenticateAdmin fails unless a request carries the admin token
+func (s *Server) authenticateAdmin(ctx context.Context) error {
+	if s.adminTokenHash == "" {
+		return errAdminDisabled
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(api.AdminTokenMetadataKey)
+	if len(tokens) == 0 {
+		return errAdminTokenRequired
+	}
+
+	if len(tokens) != 1 || !jointoken.Verify(tokens[0], s.adminTokenHash) {
+		return errInvalidAdminToken
+	}
+
+	return nil
+}
+
+// adminError converts an error into a grpc status, so clients can tell
+// missing devices apart from other failures
+func adminError(err error) error {
+	if kerrors.IsNotFound(errors.Cause(err)) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return err
+}
+
+// apiDevice converts a device into it's admin api representation
+func apiDevice(d *registrar.Device) *api.Device {
+	ad := &api.Device{
+		Name:           d.Name,
+		Phase:          string(d.Status.Phase),
+		Approval:       string(d.Spec.Approval),
+		Labels:         d.Labels,
+		Decommissioned: d.Status.Decommissioned,
+		CreatedUnix:    d.CreationTimestamp.Unix(),
+	}
+
+	if wg := d.Status.WireGuard; wg != nil {
+		ad.TunnelIp = wg.IPAddress
+		ad.WireguardPublicKey = wg.PublicKey
+	}
+
+	if n := d.Status.Node; n != nil {
+		ad.NodeName = n.Name
+		ad.NodeReady = n.Ready
+	}
+
+	if d.Status.LastSeen != nil {
+		ad.LastSeenUnix = d.Status.LastSeen.Unix()
+	}
+
+	if a := d.Status.Agent; a != nil {
+		ad.AgentVersion = a.AgentVersion
+		ad.K3SVersion = a.K3sVersion
+	}
+
+	if d.Status.Inventory != nil {
+		ad.Inventory = apiInventory(d.Status.Inventory)
+	}
+
+	return ad
+}
+
+// ListDevices returns all devices, sorted by name
+func (a *adminServer) ListDevices(ctx context.Context, r *api.ListDevicesRequest) (*api.ListDevicesResponse, error) {
+	if err := a.s.authenticateAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	devices, err := a.s.cachedDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+
+	resp := &api.ListDevicesResponse{Devices: make([]*api.Device, 0, len(devices))}
+	for i := range devices {
+		resp.Devices = append(resp.Devices, apiDevice(&devices[i]))
+	}
+
+	return resp, nil
+}
+
+// GetDevice returns a device by it's name
+func (a *adminServer) GetDevice(ctx context.Context, r *api.GetDeviceRequest) (*api.Device, error) {
+	if err := a.s.authenticateAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	d, err := a.s.cachedDevice(ctx, r.Name)
+	if err != nil {
+		return nil, adminError(errors.Wrapf(err, "failed to get device '%s'", r.Name))
+	}
+
+	return apiDevice(d), nil
+}
+
+// ApproveDevice approves, or rejects, a device. An approved device is given
+// access to the cluster the next time it registers.
+func (a *adminServer) ApproveDevice(ctx context.Context, r *api.ApproveDeviceRequest) (*api.Device, error) {
+	if err := a.s.authenticateAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	approval := registrar.DeviceApproved
+	if r.Reject {
+		approval = registrar.DeviceRejected
+	}
+
+	var d *registrar.Device
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		d, err = a.s.store.Devices(namespace).Get(ctx, r.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if d.Spec.Approval != approval {
+			d.Spec.Approval = approval
+			d, err = a.s.store.Devices(namespace).Update(ctx, d)
+			if err != nil {
+				return err
+			}
+		}
+
+		// a rejected device's phase changes right away
+		return a.s.reconcileDevice(ctx, d)
+	})
+	if err != nil {
+		return nil, adminError(errors.Wrapf(err, "failed to update device '%s'", r.Name))
+	}
+
+	a.s.recorder.Eventf(d, corev1.EventTypeNormal, string(approval), "%s by an operator", approval)
+	return apiDevice(d), nil
+}
+
+// RevokeDevice decommissions a device
+func (a *adminServer) RevokeDevice(ctx context.Context, r *api.RevokeDeviceRequest) (*api.Device, error) {
+	if err := a.s.authenticateAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	d, err := a.s.store.Devices(namespace).Get(ctx, r.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, adminError(errors.Wrapf(err, "failed to get device '%s'", r.Name))
+	}
+
+	if !d.Status.Decommissioned {
+		if err := a.s.decommission(ctx, d, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return apiDevice(d), nil
+}
+
+// CreateJoinToken creates a join token devices can register with
+func (a *adminServer) CreateJoinToken(ctx context.Context, r *api.CreateJoinTokenRequest) (*api.CreateJoinTokenResponse, error) {
+	if err := a.s.authenticateAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if r.TtlSeconds < 0 || r.MaxUses < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl and max uses can't be negative")
+	}
+
+	tok, err := jointoken.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	spec := registrar.JoinTokenSpec{
+		TokenHash: tok.Hash(),
+		MaxUses:   int(r.MaxUses),
+		Profile:   r.Profile,
+		Labels:    r.Labels,
+	}
+
+	resp := &api.CreateJoinTokenResponse{Name: tok.Name, Token: tok.String()}
+	if r.TtlSeconds > 0 {
+		expires := metav1.NewTime(time.Now().Add(time.Duration(r.TtlSeconds) * time.Second))
+		spec.Expires = &expires
+		resp.ExpiresUnix = expires.Unix()
+	}
+
+	_, err = a.s.store.JoinTokens(namespace).Create(ctx, &registrar.JoinToken{
+		ObjectMeta: metav1.ObjectMeta{Name: tok.Name, Namespace: namespace},
+		Spec:       spec,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create join token")
+	}
+
+	return resp, nil
+}
+
+// WatchDevices streams changes to devices, starting with every existing
+// device as added
+func (a *adminServer) WatchDevices(r *api.WatchDevicesRequest, stream api.RegistrarAdmin_WatchDevicesServer) error {
+	ctx := stream.Context()
+	if err := a.s.authenticateAdmin(ctx); err != nil {
+		return err
+	}
+
+	l, err := a.s.store.Devices(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list devices")
+	}
+
+	for i := range l.Items {
+		if err := stream.Send(&api.DeviceEvent{Type: api.DeviceEvent_ADDED, Device: apiDevice(&l.Items[i])}); err != nil {
+			return err
+		}
+	}
+
+	// watches are closed by the server every so often, so they're resumed
+	// from the last version that was sent
+	rv := l.ResourceVersion
+	for {
+		w, err := a.s.store.Devices(namespace).Watch(ctx, metav1.ListOptions{ResourceVersion: rv})
+		if err != nil {
+			return errors.Wrap(err, "failed to watch devices")
+		}
+
+		rv, err = sendDeviceEvents(ctx, w, stream, rv)
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// sendDeviceEvents sends the events of a watch until it's closed, returning
+// the last resource version that was sent
+func sendDeviceEvents(ctx context.Context, w watch.Interface, stream api.RegistrarAdmin_WatchDevicesServer, rv string) (string, error) {
+	defer w.Stop()
+
+	for {
+		var e watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return rv, nil
+		case e, ok = <-w.ResultChan():
+			if !ok {
+				return rv, nil
+			}
+		}
+
+		var t api.DeviceEvent_Type
+		switch e.Type {
+		case watch.Added:
+			t = api.DeviceEvent_ADDED
+		case watch.Modified:
+			t = api.DeviceEvent_MODIFIED
+		case watch.Deleted:
+			t = api.DeviceEvent_DELETED
+		case watch.Error:
+			// the client has to list again, e.g. when rv expired
+			return rv, status.Error(codes.Aborted, kerrors.FromObject(e.Object).Error())
+		default:
+			continue
+		}
+
+		d, ok := e.Object.(*registrar.Device)
+		if !ok {
+			continue
+		}
+
+		if err := stream.Send(&api.DeviceEvent{Type: t, Device: apiDevice(d)}); err != nil {
+			return rv, err
+		}
+		rv = d.ResourceVersion
+	}
+}
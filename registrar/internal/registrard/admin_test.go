@@ -0,0 +1,170 @@
+package registrard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testAdminToken = "admin-token"
+
+// adminCreds authenticates as an admin with token
+func adminCreds(token string) grpc.CallOption {
+	return grpc.PerRPCCredentials(&api.AdminCredentials{Token: token, AllowInsecure: true})
+}
+
+func TestAdminAuth(t *testing.T) {
+	h := newTestHarness(t)
+	defer h.Close()
+
+	tests := []struct {
+		name      string
+		tokenHash string
+		opts      []grpc.CallOption
+		want      codes.Code
+	}{
+		{"disabled", "", []grpc.CallOption{adminCreds(testAdminToken)}, codes.Unimplemented},
+		{"no token", jointoken.HashSecret(testAdminToken), nil, codes.Unauthenticated},
+		{"wrong token", jointoken.HashSecret(testAdminToken), []grpc.CallOption{adminCreds("wrong")}, codes.Unauthenticated},
+		{"valid token", jointoken.HashSecret(testAdminToken), []grpc.CallOption{adminCreds(testAdminToken)}, codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.server.adminTokenHash = tt.tokenHash
+
+			_, err := h.admin.ListDevices(context.Background(), &api.ListDevicesRequest{}, tt.opts...)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("expected %s, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAdminApproveDevice(t *testing.T) {
+	h := newTestHarness(t, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"},
+		Status:     registrar.DeviceStatus{Phase: registrar.DevicePhasePending},
+	})
+	defer h.Close()
+	h.server.requireApproval = true
+	h.server.adminTokenHash = jointoken.HashSecret(testAdminToken)
+
+	ctx := context.Background()
+	l, err := h.admin.ListDevices(ctx, &api.ListDevicesRequest{}, adminCreds(testAdminToken))
+	if err != nil {
+		t.Fatalf("failed to list devices: %v", err)
+	}
+
+	if len(l.Devices) != 1 || l.Devices[0].Name != "device-id" || l.Devices[0].Phase != string(registrar.DevicePhasePending) {
+		t.Fatalf("expected the pending device, got %v", l.Devices)
+	}
+
+	d, err := h.admin.ApproveDevice(ctx, &api.ApproveDeviceRequest{Name: "device-id"}, adminCreds(testAdminToken))
+	if err != nil {
+		t.Fatalf("failed to approve device: %v", err)
+	}
+
+	if d.Approval != string(registrar.DeviceApproved) || h.device(t, "device-id").Spec.Approval != registrar.DeviceApproved {
+		t.Errorf("expected the device to be approved, got %v", d)
+	}
+
+	d, err = h.admin.ApproveDevice(ctx, &api.ApproveDeviceRequest{Name: "device-id", Reject: true}, adminCreds(testAdminToken))
+	if err != nil {
+		t.Fatalf("failed to reject device: %v", err)
+	}
+
+	if d.Approval != string(registrar.DeviceRejected) || d.Phase != string(registrar.DevicePhaseRejected) {
+		t.Errorf("expected the device to be rejected, got %v", d)
+	}
+
+	_, err = h.admin.GetDevice(ctx, &api.GetDeviceRequest{Name: "unknown"}, adminCreds(testAdminToken))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected getting an unknown device to fail with not found, got %v", err)
+	}
+}
+
+func TestAdminCreateJoinTokenAndRevokeDevice(t *testing.T) {
+	h := newTestHarness(t)
+	defer h.Close()
+	h.server.adminTokenHash = jointoken.HashSecret(testAdminToken)
+
+	ctx := context.Background()
+	jt, err := h.admin.CreateJoinToken(ctx, &api.CreateJoinTokenRequest{TtlSeconds: 60, MaxUses: 1}, adminCreds(testAdminToken))
+	if err != nil {
+		t.Fatalf("failed to create join token: %v", err)
+	}
+
+	if jt.ExpiresUnix == 0 {
+		t.Error("expected the join token to expire")
+	}
+
+	resp, err := h.register(ctx, &api.RegisterRequest{AuthToken: jt.Token, WireguardPublicKey: publicKey(t)}, nil)
+	if err != nil {
+		t.Fatalf("failed to register with the created join token: %v", err)
+	}
+
+	d, err := h.admin.RevokeDevice(ctx, &api.RevokeDeviceRequest{Name: resp.Id}, adminCreds(testAdminToken))
+	if err != nil {
+		t.Fatalf("failed to revoke device: %v", err)
+	}
+
+	if !d.Decommissioned || d.TunnelIp != "" {
+		t.Errorf("expected the device to be decommissioned, got %v", d)
+	}
+
+	if peers, _ := h.hub.Peers(ctx); len(peers) != 0 {
+		t.Errorf("expected the peer of the device to be removed, got %v", peers)
+	}
+
+	creds := &api.DeviceCredentials{ID: resp.Id, Secret: resp.DeviceSecret}
+	if _, err := h.register(ctx, &api.RegisterRequest{Id: resp.Id}, creds); err == nil {
+		t.Error("expected the credentials of the device to be revoked")
+	}
+}
+
+func TestAdminWatchDevices(t *testing.T) {
+	h := newTestHarness(t, &registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"}})
+	defer h.Close()
+	h.server.adminTokenHash = jointoken.HashSecret(testAdminToken)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := h.admin.WatchDevices(ctx, &api.WatchDevicesRequest{}, adminCreds(testAdminToken))
+	if err != nil {
+		t.Fatalf("failed to watch devices: %v", err)
+	}
+
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+
+	if e.Type != api.DeviceEvent_ADDED || e.Device.Name != "device-id" {
+		t.Fatalf("expected the existing device to be added, got %v", e)
+	}
+
+	d := h.device(t, "device-id")
+	d.Labels = map[string]string{"site": "home"}
+	if _, err := h.store.Devices(namespace).Update(ctx, d); err != nil {
+		t.Fatalf("failed to update device: %v", err)
+	}
+
+	e, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive event: %v", err)
+	}
+
+	if e.Type != api.DeviceEvent_MODIFIED || e.Device.Labels["site"] != "home" {
+		t.Errorf("expected the device to be modified, got %v", e)
+	}
+}
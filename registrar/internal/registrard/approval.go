@@ -0,0 +1,65 @@
+package registrard
+
+import (
+	"context"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	errDeviceRejected = status.Error(codes.PermissionDenied, "device has been rejected by an operator")
+	errDevicePending  = status.Error(codes.FailedPrecondition, "device is pending approval by an operator")
+)
+
+// approvalState is the decision of an operator on a device
+type approvalState int
+
+const (
+	approvalPending approvalState = iota
+	approvalGranted
+	approvalRejected
+)
+
+// admitted returns true for the phases of devices that have been given
+// access to the cluster
+func admitted(phase registrar.DevicePhase) bool {
+	switch phase {
+	case registrar.DevicePhaseProvisioning, registrar.DevicePhaseActive, registrar.DevicePhaseUnreachable:
+		return true
+	}
+
+	return false
+}
+
+// approval returns the decision of an operator on a device. Devices that were
+// given access to the cluster before approval was required keep it.
+func (s *Server) approval(d *registrar.Device) approvalState {
+	switch {
+	case d.Spec.Approval == registrar.DeviceRejected:
+		return approvalRejected
+	case !s.requireApproval, d.Spec.Approval == registrar.DeviceApproved, admitted(d.Status.Phase):
+		return approvalGranted
+	default:
+		return approvalPending
+	}
+}
+
+// checkApproval updates the status of a device from the decision of an
+// operator, returning true if it's still pending approval and an error
+// if it was rejected
+func (s *Server) checkApproval(ctx context.Context, d *registrar.Device) (bool, error) {
+	if err := s.reconcileDevice(ctx, d); err != nil {
+		return false, err
+	}
+
+	switch s.approval(d) {
+	case approvalRejected:
+		return false, errDeviceRejected
+	case approvalPending:
+		return true, nil
+	}
+
+	return false, nil
+}
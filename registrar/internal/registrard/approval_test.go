@@ -0,0 +1,65 @@
+package registrard
+
+import (
+	"testing"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+)
+
+func TestApproval(t *testing.T) {
+	tests := []struct {
+		name            string
+		requireApproval bool
+		spec            registrar.DeviceSpec
+		status          registrar.DeviceStatus
+		want            approvalState
+	}{
+		{
+			name: "approval not required",
+			want: approvalGranted,
+		},
+		{
+			name:            "new device",
+			requireApproval: true,
+			want:            approvalPending,
+		},
+		{
+			name:            "approved device",
+			requireApproval: true,
+			spec:            registrar.DeviceSpec{Approval: registrar.DeviceApproved},
+			want:            approvalGranted,
+		},
+		{
+			name:            "rejected device",
+			requireApproval: true,
+			spec:            registrar.DeviceSpec{Approval: registrar.DeviceRejected},
+			want:            approvalRejected,
+		},
+		{
+			name: "rejected device without approval required",
+			spec: registrar.DeviceSpec{Approval: registrar.DeviceRejected},
+			want: approvalRejected,
+		},
+		{
+			name:            "device active before approval was required",
+			requireApproval: true,
+			status:          registrar.DeviceStatus{Phase: registrar.DevicePhaseUnreachable},
+			want:            approvalGranted,
+		},
+		{
+			name:            "decommissioned device registering again",
+			requireApproval: true,
+			status:          registrar.DeviceStatus{Phase: registrar.DevicePhaseDecommissioned},
+			want:            approvalPending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{requireApproval: tt.requireApproval}
+			if got := s.approval(&registrar.Device{Spec: tt.spec, Status: tt.status}); got != tt.want {
+				t.Errorf("expected approval %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/clustertoken"
+	"github.com/pkg/errors"
+)
+
+// join modes, set with JOIN_MODE
+const (
+	// joinModeK3s has devices run the k3s agent
+	joinModeK3s = "k3s"
+
+	// joinModeKubeadm has devices join with kubeadm, using bootstrap tokens
+	joinModeKubeadm = "kubeadm"
+
+	// joinModeRancher has devices join a Rancher custom cluster by running
+	// rancher-agent
+	joinModeRancher = "rancher"
+)
+
+// ClusterBackend is how devices join the cluster
+type ClusterBackend interface {
+	// Join sets how a device joins the cluster on it's configuration
+	Join(ctx context.Context, d *registrar.Device, conf *api.DeviceConfig) error
+}
+
+// newClusterBackend returns the cluster backend for JOIN_MODE, k3s by
+// default
+func (s *Server) newClusterBackend() (ClusterBackend, error) {
+	host := os.Getenv("CLUSTER_HOST")
+
+	switch mode := os.Getenv("JOIN_MODE"); mode {
+	case "", joinModeK3s:
+		tokens, _, err := newTokenSource(s.r)
+		if err != nil {
+			return nil, err
+		}
+
+		version := os.Getenv("K3S_VERSION")
+		if version == "" {
+			version = defaultK3sVersion
+		}
+
+		return &k3sBackend{host: host, tokens: tokens, version: version}, nil
+	case joinModeKubeadm:
+		if s.k == nil {
+			return nil, fmt.Errorf("JOIN_MODE=%s requires a cluster", joinModeKubeadm)
+		}
+
+		ttl := defaultBootstrapTokenTTL
+		if v := os.Getenv("BOOTSTRAP_TOKEN_TTL"); v != "" {
+			var err error
+			ttl, err = time.ParseDuration(v)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse BOOTSTRAP_TOKEN_TTL")
+			}
+		}
+
+		return newKubeadmBackend(s.k, s.store, s.updateStatus, host, ttl), nil
+	case joinModeRancher:
+		tokens, registrations, err := newTokenSource(s.r)
+		if err != nil {
+			return nil, err
+		}
+
+		if registrations == nil {
+			return nil, fmt.Errorf("JOIN_MODE=%s requires RANCHER_CLUSTER_ID", joinModeRancher)
+		}
+
+		return &rancherBackend{
+			tokens:        tokens,
+			registrations: registrations,
+			host:          os.Getenv("RANCHER_HOST"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown join mode '%s'", mode)
+	}
+}
+
+// k3sBackend has devices run the k3s agent, joining with the node token of
+// the server
+type k3sBackend struct {
+	host    string
+	tokens  clustertoken.Source
+	version string
+}
+
+// Join sets the k3s server, token and version of a device, unless it's spec
+// overrides them
+func (b *k3sBackend) Join(ctx context.Context, d *registrar.Device, conf *api.DeviceConfig) error {
+	k3s := &api.K3SConfig{
+		ServerUrl: d.Spec.ClusterHost,
+		Token:     d.Spec.ClusterToken,
+		Version:   d.Spec.K3sVersion,
+	}
+
+	if k3s.ServerUrl == "" {
+		k3s.ServerUrl = b.host
+	}
+	if k3s.Token == "" {
+		var err error
+		k3s.Token, err = b.tokens.Token(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get cluster token")
+		}
+	}
+	if k3s.Version == "" {
+		k3s.Version = b.version
+	}
+
+	// agents that don't know about join configurations only read these
+	conf.ClusterHost = k3s.ServerUrl
+	conf.ClusterToken = k3s.Token
+	conf.K3SVersion = k3s.Version
+
+	conf.Join = &api.DeviceConfig_K3S{K3S: k3s}
+	return nil
+}
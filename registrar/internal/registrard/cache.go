@@ -0,0 +1,109 @@
+package registrard
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// cacheResyncInterval is how often the device and node caches are
+	// resynced
+	cacheResyncInterval = 10 * time.Minute
+
+	// nodeDeviceIndex indexes nodes by the device they belong to
+	nodeDeviceIndex = "device"
+)
+
+// nodeDeviceIndexFunc indexes nodes by their deviceLabel
+func nodeDeviceIndexFunc(obj interface{}) ([]string, error) {
+	n, ok := obj.(*corev1.Node)
+	if !ok || n.Labels[deviceLabel] == "" {
+		return nil, nil
+	}
+
+	return []string{n.Labels[deviceLabel]}, nil
+}
+
+// startCaches starts the device and node caches, waiting for them to be
+// synced. They're kept up to date until ctx is canceled. Without a cluster
+// the node cache is always empty.
+func (s *Server) startCaches(ctx context.Context) error {
+	factory := v1alpha1.NewSharedInformerFactory(s.store, namespace, cacheResyncInterval)
+	s.devices = factory.Devices().Lister()
+
+	if s.k == nil {
+		s.nodes = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{nodeDeviceIndex: nodeDeviceIndexFunc})
+
+		factory.Start(ctx.Done())
+		for typ, ok := range factory.WaitForCacheSync(ctx.Done()) {
+			if !ok {
+				return errors.Errorf("failed to sync %s cache", typ)
+			}
+		}
+		return nil
+	}
+
+	kubeFactory := informers.NewSharedInformerFactory(s.k, cacheResyncInterval)
+	nodeInformer := kubeFactory.Core().V1().Nodes().Informer()
+	if err := nodeInformer.AddIndexers(cache.Indexers{nodeDeviceIndex: nodeDeviceIndexFunc}); err != nil {
+		return errors.Wrap(err, "failed to index nodes")
+	}
+	s.nodes = nodeInformer.GetIndexer()
+	s.watchNodes(ctx, nodeInformer)
+	s.watchDevices(factory.Devices().Informer())
+
+	factory.Start(ctx.Done())
+	kubeFactory.Start(ctx.Done())
+
+	for typ, ok := range factory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return errors.Errorf("failed to sync %s cache", typ)
+		}
+	}
+
+	for typ, ok := range kubeFactory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return errors.Errorf("failed to sync %s cache", typ)
+		}
+	}
+
+	return nil
+}
+
+// cachedDevice returns a copy of a device from the cache, falling back to
+// the API server if it isn't cached yet
+func (s *Server) cachedDevice(ctx context.Context, name string) (*registrar.Device, error) {
+	d, err := s.devices.Devices(namespace).Get(name)
+	if err == nil {
+		return d.DeepCopy(), nil
+	} else if !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	return s.store.Devices(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+// cachedDevices returns a copy of every device in the cache
+func (s *Server) cachedDevices() ([]registrar.Device, error) {
+	cached, err := s.devices.Devices(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list devices")
+	}
+
+	devices := make([]registrar.Device, len(cached))
+	for i, d := range cached {
+		d.DeepCopyInto(&devices[i])
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,42 @@
+package registrard
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestCachedDeviceIsCopied(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, v1alpha1.DeviceIndexers())
+	cached := &registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"}}
+	if err := indexer.Add(cached); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{devices: v1alpha1.NewDeviceLister(indexer)}
+	d, err := s.cachedDevice(context.Background(), "device-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Status.Registered = true
+	if cached.Status.Registered {
+		t.Error("expected the cached device to be copied")
+	}
+}
+
+func TestNodeDeviceIndexFunc(t *testing.T) {
+	n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{deviceLabel: "device-id"}}}
+	if got, _ := nodeDeviceIndexFunc(n); len(got) != 1 || got[0] != "device-id" {
+		t.Errorf("unexpected index values %v", got)
+	}
+
+	if got, _ := nodeDeviceIndexFunc(&corev1.Node{}); len(got) != 0 {
+		t.Errorf("expected unlabelled nodes to not be indexed, got %v", got)
+	}
+}
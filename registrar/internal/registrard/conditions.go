@@ -0,0 +1,242 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// tunnelTimeout is how old the last WireGuard handshake of a device can
+	// be before we consider it's tunnel down. Handshakes happen every two
+	// minutes on an active tunnel.
+	tunnelTimeout = 5 * time.Minute
+
+	// unreachableAfter is how long a device can go without reporting it's
+	// status before we consider it unreachable
+	unreachableAfter = 5 * time.Minute
+)
+
+// setCondition sets a condition of a device, only changing it's transition
+// time if it's status changed
+func setCondition(d *registrar.Device, c registrar.DeviceCondition, now time.Time) {
+	c.ObservedGeneration = d.Generation
+	c.LastTransitionTime = metav1.NewTime(now)
+
+	for i := range d.Status.Conditions {
+		existing := &d.Status.Conditions[i]
+		if existing.Type != c.Type {
+			continue
+		}
+
+		if existing.Status == c.Status {
+			c.LastTransitionTime = existing.LastTransitionTime
+		}
+		*existing = c
+		return
+	}
+
+	d.Status.Conditions = append(d.Status.Conditions, c)
+}
+
+// getCondition returns a condition of a device, or nil if it isn't set
+func getCondition(d *registrar.Device, t registrar.DeviceConditionType) *registrar.DeviceCondition {
+	for i := range d.Status.Conditions {
+		if d.Status.Conditions[i].Type == t {
+			return &d.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// isConditionTrue returns true if a condition of a device is set and true
+func isConditionTrue(d *registrar.Device, t registrar.DeviceConditionType) bool {
+	c := getCondition(d, t)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// nodeReady returns true if a node is ready
+func nodeReady(n *corev1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// reconcileStatus computes the conditions and phase of a device at now. node
+// is the node the device joined the cluster as, or nil if it hasn't.
+func (s *Server) reconcileStatus(d *registrar.Device, node *corev1.Node, now time.Time) {
+	approval := s.approval(d)
+	cond := func(t registrar.DeviceConditionType, status corev1.ConditionStatus, reason, message string) {
+		setCondition(d, registrar.DeviceCondition{Type: t, Status: status, Reason: reason, Message: message}, now)
+	}
+
+	switch {
+	case d.Status.Decommissioned:
+		cond(registrar.DeviceRegistered, corev1.ConditionFalse, "Decommissioned", "device has been decommissioned")
+	case approval == approvalRejected:
+		cond(registrar.DeviceRegistered, corev1.ConditionFalse, "Rejected", "device has been rejected by an operator")
+	case approval == approvalPending:
+		cond(registrar.DeviceRegistered, corev1.ConditionFalse, "PendingApproval", "device is waiting to be approved by an operator")
+	default:
+		cond(registrar.DeviceRegistered, corev1.ConditionTrue, "Registered", "")
+	}
+
+	var handshake *metav1.Time
+	if d.Status.Agent != nil {
+		handshake = d.Status.Agent.WireGuardLastHandshake
+	}
+
+	switch {
+	case d.Status.WireGuard == nil:
+		cond(registrar.DeviceTunnelUp, corev1.ConditionFalse, "NoPeer", "device has no wireguard peer")
+	case d.Status.LastSeen == nil:
+		cond(registrar.DeviceTunnelUp, corev1.ConditionUnknown, "NotReported", "device hasn't reported it's status yet")
+	case now.Sub(d.Status.LastSeen.Time) > unreachableAfter:
+		cond(registrar.DeviceTunnelUp, corev1.ConditionUnknown, "NotReported",
+			fmt.Sprintf("device hasn't reported it's status since %s", d.Status.LastSeen.UTC().Format(time.RFC3339)))
+	case handshake == nil:
+		cond(registrar.DeviceTunnelUp, corev1.ConditionFalse, "NoHandshake", "device hasn't completed a handshake with the hub")
+	case now.Sub(handshake.Time) > tunnelTimeout:
+		cond(registrar.DeviceTunnelUp, corev1.ConditionFalse, "HandshakeExpired",
+			fmt.Sprintf("last handshake with the hub was at %s", handshake.UTC().Format(time.RFC3339)))
+	default:
+		cond(registrar.DeviceTunnelUp, corev1.ConditionTrue, "HandshakeCompleted", "")
+	}
+
+	if node == nil {
+		cond(registrar.DeviceNodeJoined, corev1.ConditionFalse, "NodeNotFound",
+			fmt.Sprintf("no node has the label %s=%s", deviceLabel, d.Name))
+		d.Status.Node = nil
+	} else {
+		cond(registrar.DeviceNodeJoined, corev1.ConditionTrue, "NodeFound",
+			fmt.Sprintf("device joined the cluster as node '%s'", node.Name))
+		d.Status.Node = &registrar.NodeStatus{Name: node.Name, Ready: nodeReady(node)}
+	}
+
+	registered := isConditionTrue(d, registrar.DeviceRegistered)
+	tunnelUp := isConditionTrue(d, registrar.DeviceTunnelUp)
+	switch {
+	case !registered:
+		c := getCondition(d, registrar.DeviceRegistered)
+		cond(registrar.DeviceReady, corev1.ConditionFalse, c.Reason, c.Message)
+	case !tunnelUp:
+		cond(registrar.DeviceReady, corev1.ConditionFalse, "TunnelDown", "device's tunnel is not up")
+	case node == nil:
+		cond(registrar.DeviceReady, corev1.ConditionFalse, "NodeNotJoined", "device hasn't joined the cluster")
+	case !nodeReady(node):
+		cond(registrar.DeviceReady, corev1.ConditionFalse, "NodeNotReady", fmt.Sprintf("node '%s' is not ready", node.Name))
+	default:
+		cond(registrar.DeviceReady, corev1.ConditionTrue, "Ready", "")
+	}
+
+	lastSeenExpired := d.Status.LastSeen != nil && now.Sub(d.Status.LastSeen.Time) > unreachableAfter
+	wasActive := d.Status.Phase == registrar.DevicePhaseActive || d.Status.Phase == registrar.DevicePhaseUnreachable
+
+	switch {
+	case d.Status.Decommissioned:
+		d.Status.Phase = registrar.DevicePhaseDecommissioned
+	case approval == approvalRejected:
+		d.Status.Phase = registrar.DevicePhaseRejected
+	case approval == approvalPending:
+		d.Status.Phase = registrar.DevicePhasePending
+	case isConditionTrue(d, registrar.DeviceReady):
+		d.Status.Phase = registrar.DevicePhaseActive
+	case lastSeenExpired, wasActive && !tunnelUp:
+		d.Status.Phase = registrar.DevicePhaseUnreachable
+	default:
+		d.Status.Phase = registrar.DevicePhaseProvisioning
+	}
+
+	d.Status.Registered = registered
+	d.Status.ObservedGeneration = d.Generation
+}
+
+// findDeviceNode returns the node a device joined the cluster as, or nil
+// if it hasn't
+func (s *Server) findDeviceNode(ctx context.Context, d *registrar.Device) (*corev1.Node, error) {
+	objs, err := s.nodes.ByIndex(nodeDeviceIndex, d.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+
+	nodes := make([]*corev1.Node, 0, len(objs))
+	for _, obj := range objs {
+		nodes = append(nodes, obj.(*corev1.Node))
+	}
+
+	return pickDeviceNode(nodes), nil
+}
+
+// pickDeviceNode returns the node a device is most likely running as out of
+// the nodes labelled with it. A re-imaged device can leave it's old node
+// behind, so ready nodes are preferred, then the newest one.
+func pickDeviceNode(nodes []*corev1.Node) *corev1.Node {
+	var node *corev1.Node
+	for _, n := range nodes {
+		switch {
+		case node == nil:
+			node = n
+		case nodeReady(n) != nodeReady(node):
+			if nodeReady(n) {
+				node = n
+			}
+		case node.CreationTimestamp.Before(&n.CreationTimestamp):
+			node = n
+		}
+	}
+
+	return node
+}
+
+// updateStatus recomputes the conditions and phase of a device and saves
+// it's status
+func (s *Server) updateStatus(ctx context.Context, d *registrar.Device) error {
+	node, err := s.findDeviceNode(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	s.reconcileStatus(d, node, time.Now())
+	updated, err := s.store.Devices(namespace).UpdateStatus(ctx, d)
+	if err != nil {
+		// not wrapped, so conflicts can be retried
+		return err
+	}
+	*d = *updated
+
+	return nil
+}
+
+// reconcileDevice recomputes the conditions and phase of a device, saving
+// it's status only if they changed
+func (s *Server) reconcileDevice(ctx context.Context, d *registrar.Device) error {
+	node, err := s.findDeviceNode(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	status := d.Status.DeepCopy()
+	s.reconcileStatus(d, node, time.Now())
+	if equality.Semantic.DeepEqual(status, &d.Status) {
+		return nil
+	}
+
+	updated, err := s.store.Devices(namespace).UpdateStatus(ctx, d)
+	if err != nil {
+		return err
+	}
+	*d = *updated
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package registrard
+
+import (
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func readyNode(ready corev1.ConditionStatus) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "pi-1"},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+		},
+	}
+}
+
+func reportingDevice(now time.Time, handshakeAge time.Duration) *registrar.Device {
+	lastSeen := metav1.NewTime(now.Add(-time.Minute))
+	handshake := metav1.NewTime(now.Add(-handshakeAge))
+	return &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id", Generation: 2},
+		Status: registrar.DeviceStatus{
+			CredentialHash: "hash",
+			WireGuard:      &registrar.WireGuardStatus{PublicKey: "key", IPAddress: "10.10.0.2"},
+			LastSeen:       &lastSeen,
+			Agent:          &registrar.AgentStatus{WireGuardLastHandshake: &handshake},
+		},
+	}
+}
+
+func TestReconcileStatusReady(t *testing.T) {
+	now := time.Now()
+	s := &Server{}
+	d := reportingDevice(now, time.Minute)
+
+	s.reconcileStatus(d, readyNode(corev1.ConditionTrue), now)
+
+	if d.Status.Phase != registrar.DevicePhaseActive {
+		t.Errorf("expected phase Active, got %s", d.Status.Phase)
+	}
+
+	for _, c := range []registrar.DeviceConditionType{
+		registrar.DeviceRegistered, registrar.DeviceTunnelUp, registrar.DeviceNodeJoined, registrar.DeviceReady,
+	} {
+		if !isConditionTrue(d, c) {
+			t.Errorf("expected condition %s to be true, got %+v", c, getCondition(d, c))
+		}
+	}
+
+	if d.Status.Node == nil || d.Status.Node.Name != "pi-1" || !d.Status.Node.Ready {
+		t.Errorf("unexpected node status %+v", d.Status.Node)
+	}
+
+	if d.Status.ObservedGeneration != 2 || getCondition(d, registrar.DeviceReady).ObservedGeneration != 2 {
+		t.Errorf("expected observed generation to be set")
+	}
+}
+
+func TestReconcileStatusProvisioning(t *testing.T) {
+	now := time.Now()
+	s := &Server{}
+	d := reportingDevice(now, time.Minute)
+
+	s.reconcileStatus(d, nil, now)
+
+	if d.Status.Phase != registrar.DevicePhaseProvisioning {
+		t.Errorf("expected phase Provisioning, got %s", d.Status.Phase)
+	}
+
+	if d.Status.Node != nil {
+		t.Errorf("expected no node status, got %+v", d.Status.Node)
+	}
+
+	if c := getCondition(d, registrar.DeviceReady); c.Status != corev1.ConditionFalse || c.Reason != "NodeNotJoined" {
+		t.Errorf("unexpected ready condition %+v", c)
+	}
+}
+
+func TestReconcileStatusUnreachable(t *testing.T) {
+	now := time.Now()
+	s := &Server{}
+	d := reportingDevice(now, time.Minute)
+	node := readyNode(corev1.ConditionTrue)
+
+	s.reconcileStatus(d, node, now)
+	ready := getCondition(d, registrar.DeviceReady).LastTransitionTime
+
+	// the device stops reporting
+	later := now.Add(10 * time.Minute)
+	s.reconcileStatus(d, node, later)
+
+	if d.Status.Phase != registrar.DevicePhaseUnreachable {
+		t.Errorf("expected phase Unreachable, got %s", d.Status.Phase)
+	}
+
+	c := getCondition(d, registrar.DeviceReady)
+	if c.Status != corev1.ConditionFalse || c.LastTransitionTime.Equal(&ready) {
+		t.Errorf("expected ready condition to transition, got %+v", c)
+	}
+
+	if c := getCondition(d, registrar.DeviceRegistered); !c.LastTransitionTime.Time.Equal(now) {
+		t.Errorf("expected registered condition to keep it's transition time, got %+v", c)
+	}
+}
+
+func TestReconcileStatusPending(t *testing.T) {
+	now := time.Now()
+	s := &Server{requireApproval: true}
+	d := &registrar.Device{}
+
+	s.reconcileStatus(d, nil, now)
+
+	if d.Status.Phase != registrar.DevicePhasePending || d.Status.Registered {
+		t.Errorf("expected an unregistered Pending device, got %+v", d.Status)
+	}
+
+	if c := getCondition(d, registrar.DeviceReady); c.Reason != "PendingApproval" {
+		t.Errorf("unexpected ready condition %+v", c)
+	}
+}
@@ -0,0 +1,197 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/clustertoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// defaultK3sVersion is the version of k3s devices run, unless their spec
+// or K3S_VERSION says otherwise
+const defaultK3sVersion = "v1.18.8+k3s1"
+
+var errDeviceDecommissioned = fmt.Errorf("device has been decommissioned")
+
+// newTokenSource returns the source of the token devices join the cluster
+// with. When RANCHER_CLUSTER_ID is set the registration token of that
+// cluster is used, falling back to CLUSTER_TOKEN if Rancher fails, and
+// it's registrations are returned too. r is nil when RANCHER_HOST isn't
+// set.
+func newTokenSource(r *rancher.Client) (clustertoken.Source, *clustertoken.Rancher, error) {
+	static := clustertoken.Static(os.Getenv("CLUSTER_TOKEN"))
+
+	clusterID := os.Getenv("RANCHER_CLUSTER_ID")
+	if clusterID == "" {
+		return static, nil, nil
+	}
+
+	if r == nil {
+		return nil, nil, fmt.Errorf("RANCHER_CLUSTER_ID requires RANCHER_HOST and RANCHER_TOKEN")
+	}
+
+	ttl := clustertoken.DefaultTTL
+	if v := os.Getenv("CLUSTER_TOKEN_TTL"); v != "" {
+		var err error
+		ttl, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse CLUSTER_TOKEN_TTL")
+		}
+	}
+
+	log.Infof("using the registration token of rancher cluster '%s' as cluster token", clusterID)
+	registrations := clustertoken.NewRancher(r, clusterID, ttl)
+	return clustertoken.WithFallback(registrations, static), registrations, nil
+}
+
+// deviceConfig returns the desired configuration of a device, with how it
+// joins the cluster set by our cluster backend
+func (s *Server) deviceConfig(ctx context.Context, d *registrar.Device) (*api.DeviceConfig, error) {
+	conf := &api.DeviceConfig{
+		Labels: make(map[string]string),
+	}
+
+	for k, v := range d.Spec.NodeLabels {
+		conf.Labels[k] = v
+	}
+
+	// lets us find the node a device joined the cluster as
+	conf.Labels[deviceLabel] = d.Name
+
+	if err := s.cluster.Join(ctx, d, conf); err != nil {
+		return nil, err
+	}
+
+	for _, t := range d.Spec.NodeTaints {
+		conf.Taints = append(conf.Taints, &api.Taint{
+			Key:    t.Key,
+			Value:  t.Value,
+			Effect: string(t.Effect),
+		})
+	}
+	sort.Slice(conf.Taints, func(i, j int) bool {
+		return conf.Taints[i].Key < conf.Taints[j].Key
+	})
+
+	return conf, nil
+}
+
+// WatchConfig sends the desired configuration of a device every time it changes
+func (s *Server) WatchConfig(r *api.WatchConfigRequest, stream api.Registrar_WatchConfigServer) error {
+	ctx := stream.Context()
+	d, err := s.requireDevice(ctx)
+	if err != nil {
+		return err
+	}
+
+	var last *api.DeviceConfig
+	send := func(d *registrar.Device) error {
+		if d.Status.Decommissioned {
+			return errDeviceDecommissioned
+		}
+
+		switch s.approval(d) {
+		case approvalRejected:
+			return errDeviceRejected
+		case approvalPending:
+			return errDevicePending
+		}
+
+		conf, err := s.deviceConfig(ctx, d)
+		if err != nil {
+			return err
+		}
+
+		if last != nil && proto.Equal(last, conf) {
+			return nil
+		}
+		last = conf
+
+		log.Infof("sending configuration to device '%s'", d.Name)
+		return stream.Send(conf)
+	}
+
+	if err := send(d); err != nil {
+		return err
+	}
+
+	name := d.Name
+	resourceVersion := d.ResourceVersion
+	for {
+		w, err := s.store.Devices(namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to watch device")
+		}
+
+		resourceVersion, err = s.watchConfig(w, resourceVersion, send)
+		w.Stop()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		// the watch expired, so make sure we didn't miss anything
+		if resourceVersion == "" {
+			d, err := s.store.Devices(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return errors.Wrap(err, "failed to get device")
+			}
+
+			if err := send(d); err != nil {
+				return err
+			}
+			resourceVersion = d.ResourceVersion
+		}
+	}
+}
+
+// watchConfig sends the configuration of a device every time it's modified
+// until the watch ends, returning the last resource version it saw. If
+// the watch expired, an empty resource version is returned.
+func (s *Server) watchConfig(w watch.Interface, resourceVersion string, send func(*registrar.Device) error) (string, error) {
+	for e := range w.ResultChan() {
+		switch e.Type {
+		case watch.Added, watch.Modified:
+			d, ok := e.Object.(*registrar.Device)
+			if !ok {
+				continue
+			}
+
+			if err := send(d); err != nil {
+				return "", err
+			}
+			resourceVersion = d.ResourceVersion
+		case watch.Deleted:
+			return "", errDeviceDecommissioned
+		case watch.Error:
+			if status, ok := e.Object.(*metav1.Status); ok && status.Code == http.StatusGone {
+				return "", nil
+			}
+			return "", errors.Wrap(kerrors.FromObject(e.Object), "failed to watch device")
+		}
+	}
+
+	return resourceVersion, nil
+}
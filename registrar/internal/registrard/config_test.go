@@ -0,0 +1,60 @@
+package registrard
+
+import (
+	"context"
+	"testing"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/clustertoken"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDeviceConfig(t *testing.T) {
+	s := &Server{cluster: &k3sBackend{
+		host:    "https://cluster:6443",
+		tokens:  clustertoken.Static("default-token"),
+		version: defaultK3sVersion,
+	}}
+
+	conf, err := s.deviceConfig(context.Background(), &registrar.Device{})
+	if err != nil {
+		t.Fatalf("failed to get device config: %v", err)
+	}
+
+	if conf.ClusterHost != "https://cluster:6443" || conf.ClusterToken != "default-token" || conf.K3SVersion != defaultK3sVersion {
+		t.Errorf("expected defaults to be used, got %+v", conf)
+	}
+
+	if k3s := conf.GetK3S(); k3s == nil || k3s.ServerUrl != conf.ClusterHost || k3s.Token != conf.ClusterToken || k3s.Version != conf.K3SVersion {
+		t.Errorf("expected a k3s join config, got %v", conf.Join)
+	}
+
+	conf, err = s.deviceConfig(context.Background(), &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id"},
+		Spec: registrar.DeviceSpec{
+			ClusterToken: "device-token",
+			K3sVersion:   "v1.19.2+k3s1",
+			NodeLabels:   map[string]string{"zone": "garage"},
+			NodeTaints: []corev1.Taint{
+				{Key: "dedicated", Value: "pi", Effect: corev1.TaintEffectNoSchedule},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to get device config: %v", err)
+	}
+
+	if conf.ClusterHost != "https://cluster:6443" {
+		t.Errorf("expected default cluster host, got %q", conf.ClusterHost)
+	}
+	if conf.ClusterToken != "device-token" || conf.K3SVersion != "v1.19.2+k3s1" {
+		t.Errorf("expected spec to override defaults, got %+v", conf)
+	}
+	if conf.Labels["zone"] != "garage" || conf.Labels[deviceLabel] != "device-id" {
+		t.Errorf("expected node labels, got %v", conf.Labels)
+	}
+	if len(conf.Taints) != 1 || conf.Taints[0].Effect != "NoSchedule" {
+		t.Errorf("unexpected taints %v", conf.Taints)
+	}
+}
@@ -0,0 +1,72 @@
+package registrard
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// statusResyncInterval is how often the conditions and phase of every
+	// device are recomputed, which is how devices become Unreachable
+	statusResyncInterval = 30 * time.Second
+
+	// peerSyncInterval is how often the peers of the hub are synced with
+	// the devices
+	peerSyncInterval = 30 * time.Second
+)
+
+// RunControllers runs the background controllers of registrard until ctx
+// is canceled. Every replica runs it's own hub, so keeps it's peers in sync,
+// but the other controllers only run on the elected leader. Standalone,
+// there's only one replica, so it's always the leader.
+func (s *Server) RunControllers(ctx context.Context) {
+	go wait.UntilWithContext(ctx, s.syncPeers, peerSyncInterval)
+
+	if s.k == nil {
+		s.runLeaderControllers(ctx)
+		return
+	}
+
+	if err := s.runAsLeader(ctx, s.runLeaderControllers); err != nil {
+		log.WithError(err).Error("failed to run leader election")
+	}
+}
+
+// runLeaderControllers runs the controllers that only the leader runs,
+// until ctx is canceled
+func (s *Server) runLeaderControllers(ctx context.Context) {
+	if s.nodeQueue != nil {
+		go s.runNodeController(ctx)
+	}
+
+	if s.gcTTL > 0 {
+		go wait.UntilWithContext(ctx, s.collectGarbage, gcInterval)
+	}
+
+	wait.UntilWithContext(ctx, s.resyncStatus, statusResyncInterval)
+}
+
+// resyncStatus recomputes the conditions and phase of every device
+func (s *Server) resyncStatus(ctx context.Context) {
+	devices, err := s.cachedDevices()
+	if err != nil {
+		log.WithError(err).Warn("failed to list devices")
+		return
+	}
+
+	for i := range devices {
+		d := &devices[i]
+		phase := d.Status.Phase
+		if err := s.reconcileDevice(ctx, d); err != nil {
+			log.WithError(err).Warnf("failed to update status of device '%s'", d.Name)
+			continue
+		}
+
+		if d.Status.Phase != phase {
+			log.Infof("device '%s' is now %s", d.Name, d.Status.Phase)
+		}
+	}
+}
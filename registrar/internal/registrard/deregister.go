@@ -0,0 +1,119 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+var errDeviceCredentialsRequired = fmt.Errorf("device credentials are required")
+
+// requireDevice returns the device that made a request, failing if the
+// request wasn't authenticated as a device. When TLS is enabled devices have
+// to authenticate with their client certificate.
+func (s *Server) requireDevice(ctx context.Context) (*registrar.Device, error) {
+	if s.requireCertificates && peerCertificate(ctx) == nil {
+		return nil, errClientCertificateRequired
+	}
+
+	d, err := s.authenticateDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if d == nil {
+		return nil, errDeviceCredentialsRequired
+	}
+
+	if s.approval(d) == approvalRejected {
+		return nil, errDeviceRejected
+	}
+
+	return d, nil
+}
+
+// Deregister decommissions a device. The node it joined the cluster as is
+// cordoned, and drained and deleted by the node controller in the
+// background. It's tunnel address and peer are released, and it's
+// credentials are revoked. It has to use a join token to register again.
+func (s *Server) Deregister(ctx context.Context, r *api.DeregisterRequest) (*api.DeregisterResponse, error) {
+	d, err := s.requireDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.decommission(ctx, d, r.NodeName); err != nil {
+		return nil, err
+	}
+
+	return &api.DeregisterResponse{}, nil
+}
+
+// decommission cordons the node of a device, releases it's tunnel address
+// and peer, and revokes it's credentials. The node controller drains and
+// deletes the nodes of decommissioned devices. nodeName overrides the node
+// the device reported it joined the cluster as.
+func (s *Server) decommission(ctx context.Context, d *registrar.Device, nodeName string) error {
+	log.Infof("decommissioning device '%s'", d.Name)
+
+	if nodeName == "" && d.Status.Node != nil {
+		nodeName = d.Status.Node.Name
+	}
+
+	if nodeName != "" && s.k == nil {
+		log.Warnf("running standalone, not removing node '%s'", nodeName)
+	} else if nodeName != "" {
+		n, err := s.getDeviceNode(ctx, d, nodeName)
+		if err != nil {
+			return err
+		}
+
+		if n == nil {
+			log.Warnf("node '%s' isn't known to belong to device '%s', not removing it", nodeName, d.Name)
+		} else if err := s.cordonDeviceNode(ctx, n, d); err != nil {
+			return err
+		}
+	}
+
+	if err := s.releasePeer(ctx, d); err != nil {
+		return err
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := s.store.Devices(namespace).Get(ctx, d.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		decommissionDevice(latest)
+		if err := s.updateStatus(ctx, latest); err != nil {
+			return err
+		}
+
+		*d = *latest
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to mark device as decommissioned")
+	}
+
+	log.Infof("device '%s' was decommissioned", d.Name)
+	return nil
+}
+
+// decommissionDevice revokes the credentials of a device and marks it
+// as decommissioned. It only changes the status, the caller removes it's
+// node, peer and tunnel address.
+func decommissionDevice(d *registrar.Device) {
+	d.Status.Decommissioned = true
+	d.Status.CredentialHash = ""
+	d.Status.WireGuard = nil
+	revokeCertificate(d, time.Now())
+}
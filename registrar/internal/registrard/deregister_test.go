@@ -0,0 +1,115 @@
+package registrard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// testNode returns a node with labels and annotations
+func testNode(name string, labels, annotations map[string]string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels, Annotations: annotations}}
+}
+
+// waitForNodeRemoval runs the node controller for a device until a node was
+// deleted
+func (h *testHarness) waitForNodeRemoval(t *testing.T, id, name string) {
+	ctx := context.Background()
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		if err := h.server.reconcileDeviceNode(ctx, id); err != nil {
+			return false, err
+		}
+
+		_, err := h.k.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		return kerrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Fatalf("expected node '%s' to be deleted: %v", name, err)
+	}
+}
+
+// registerDevice registers device-id with token, returning it's
+// credentials
+func (h *testHarness) registerDevice(t *testing.T, token string) grpc.CallOption {
+	resp, err := h.register(context.Background(), &api.RegisterRequest{Id: "device-id", AuthToken: token, WireguardPublicKey: publicKey(t)}, nil)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	return grpc.PerRPCCredentials(&api.DeviceCredentials{ID: resp.Id, Secret: resp.DeviceSecret, AllowInsecure: true})
+}
+
+func TestDeregisterNodeOwnership(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+	h := newTestHarness(t, jt,
+		testNode("server", nil, nil),
+		testNode("other-pi", map[string]string{deviceLabel: "other-device"}, nil),
+		testNode("pi", map[string]string{deviceLabel: "device-id"}, nil),
+	)
+	defer h.Close()
+
+	ctx := context.Background()
+	creds := h.registerDevice(t, token)
+
+	if _, err := h.client.Deregister(ctx, &api.DeregisterRequest{NodeName: "other-pi"}, creds); err == nil {
+		t.Error("expected deregistering with a node of another device to fail")
+	}
+
+	if d := h.device(t, "device-id"); d.Status.Decommissioned {
+		t.Error("expected the device to not be decommissioned")
+	}
+
+	// an unlabeled node could be anything, so it's left alone
+	if _, err := h.client.Deregister(ctx, &api.DeregisterRequest{NodeName: "server"}, creds); err != nil {
+		t.Fatalf("failed to deregister: %v", err)
+	}
+
+	if d := h.device(t, "device-id"); !d.Status.Decommissioned {
+		t.Error("expected the device to be decommissioned")
+	}
+
+	// the node labeled as the device is still removed by the node controller
+	h.waitForNodeRemoval(t, "device-id", "pi")
+
+	for _, name := range []string{"server", "other-pi"} {
+		n, err := h.k.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("expected node '%s' to not be deleted: %v", name, err)
+		} else if n.Spec.Unschedulable {
+			t.Errorf("expected node '%s' to not be cordoned", name)
+		}
+	}
+}
+
+func TestDeregisterUnlabeledNode(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+
+	// joined before agents labeled their node
+	h := newTestHarness(t, jt, testNode("pi", nil, map[string]string{deviceAnnotation: namespace + "/device-id"}))
+	defer h.Close()
+
+	ctx := context.Background()
+	creds := h.registerDevice(t, token)
+
+	if _, err := h.client.Deregister(ctx, &api.DeregisterRequest{NodeName: "pi"}, creds); err != nil {
+		t.Fatalf("failed to deregister: %v", err)
+	}
+
+	n, err := h.k.CoreV1().Nodes().Get(ctx, "pi", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+
+	if !n.Spec.Unschedulable || n.Labels[deviceLabel] != "device-id" {
+		t.Errorf("expected the node to be cordoned and labeled, got %+v", n)
+	}
+
+	h.waitForNodeRemoval(t, "device-id", "pi")
+}
@@ -0,0 +1,196 @@
+package registrard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// deviceSecretLen is the length, in bytes, of a device secret
+const deviceSecretLen = 32
+
+var errInvalidDeviceCredentials = fmt.Errorf("invalid device credentials")
+
+// errClientCertificateRequired is returned when TLS is enabled and a device
+// didn't authenticate with it's client certificate. The device secret is
+// only accepted when registering, to be issued a certificate.
+var errClientCertificateRequired = status.Error(codes.Unauthenticated, "a client certificate is required, register to be issued one")
+
+// generateDeviceSecret returns a new random device secret
+func generateDeviceSecret() (string, error) {
+	b := make([]byte, deviceSecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to read random bytes")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// authenticateDevice returns the device authenticated by the client certificate,
+// or the device credentials, of a request. If a request has neither, nil
+// is returned.
+func (s *Server) authenticateDevice(ctx context.Context) (*registrar.Device, error) {
+	if cert := peerCertificate(ctx); cert != nil {
+		return s.authenticateCertificate(ctx, cert)
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	ids := md.Get(api.DeviceIDMetadataKey)
+	secrets := md.Get(api.DeviceSecretMetadataKey)
+	if len(ids) == 0 && len(secrets) == 0 {
+		return nil, nil
+	}
+
+	if len(ids) != 1 || len(secrets) != 1 || ids[0] == "" {
+		return nil, errInvalidDeviceCredentials
+	}
+
+	d, err := s.cachedDevice(ctx, ids[0])
+	if kerrors.IsNotFound(err) {
+		return nil, errInvalidDeviceCredentials
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get device")
+	}
+
+	if !verifyDeviceSecret(d, secrets[0]) {
+		// the secret could have been issued after the device was cached
+		d, err = s.store.Devices(namespace).Get(ctx, ids[0], metav1.GetOptions{})
+		if err != nil {
+			return nil, errInvalidDeviceCredentials
+		}
+
+		if !verifyDeviceSecret(d, secrets[0]) {
+			return nil, errInvalidDeviceCredentials
+		}
+	}
+
+	return d, nil
+}
+
+// verifyDeviceSecret returns true if secret is the secret of a device. Devices
+// without a credential can only authenticate with a join token.
+func verifyDeviceSecret(d *registrar.Device, secret string) bool {
+	return d.Status.CredentialHash != "" && jointoken.Verify(secret, d.Status.CredentialHash)
+}
+
+// peerCertificate returns the verified client certificate of a request, if
+// there is one
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+// authenticateCertificate returns the device a verified client certificate
+// was issued to, unless it has been revoked
+func (s *Server) authenticateCertificate(ctx context.Context, cert *x509.Certificate) (*registrar.Device, error) {
+	d, err := s.cachedDevice(ctx, cert.Subject.CommonName)
+	if kerrors.IsNotFound(err) {
+		return nil, errInvalidDeviceCredentials
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get device")
+	}
+
+	serial := pki.SerialString(cert)
+	for _, revoked := range d.Status.RevokedCertificates {
+		if revoked.Serial == serial {
+			return nil, fmt.Errorf("certificate %s has been revoked", serial)
+		}
+	}
+
+	return d, nil
+}
+
+// issueCertificate signs a certificate request for a device, revoking
+// any certificate that was issued to it before
+func (s *Server) issueCertificate(ctx context.Context, d *registrar.Device, csr []byte) ([]byte, error) {
+	cert, certPEM, err := s.ca.Sign(csr, d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	revokeCertificate(d, time.Now())
+	d.Status.Certificate = &registrar.CertificateStatus{
+		Serial:   pki.SerialString(cert),
+		NotAfter: metav1.NewTime(cert.NotAfter),
+	}
+
+	if err := s.updateStatus(ctx, d); err != nil {
+		return nil, errors.Wrap(err, "failed to save device certificate")
+	}
+
+	return certPEM, nil
+}
+
+// issueDeviceSecret creates a new secret for a device that was registered before
+// device credentials existed
+func (s *Server) issueDeviceSecret(ctx context.Context, d *registrar.Device) (string, error) {
+	secret, err := generateDeviceSecret()
+	if err != nil {
+		return "", err
+	}
+
+	d.Status.CredentialHash = jointoken.HashSecret(secret)
+	if err := s.updateStatus(ctx, d); err != nil {
+		return "", errors.Wrap(err, "failed to save device credential")
+	}
+
+	return secret, nil
+}
+
+// revokeCertificate revokes the certificate currently issued to a device,
+// and forgets revoked certificates that have expired by now. Certificates
+// revoked before their expiry was recorded are kept for as long as a
+// certificate issued now would be valid.
+func revokeCertificate(d *registrar.Device, now time.Time) {
+	revoked := d.Status.RevokedCertificates[:0]
+	for _, c := range d.Status.RevokedCertificates {
+		if c.NotAfter == nil {
+			notAfter := metav1.NewTime(now.Add(pki.CertificateValidity))
+			c.NotAfter = &notAfter
+		}
+
+		if now.Before(c.NotAfter.Time) {
+			revoked = append(revoked, c)
+		}
+	}
+
+	if d.Status.Certificate != nil {
+		notAfter := d.Status.Certificate.NotAfter
+		revoked = append(revoked, registrar.RevokedCertificate{Serial: d.Status.Certificate.Serial, NotAfter: &notAfter})
+		d.Status.Certificate = nil
+	}
+
+	if len(revoked) == 0 {
+		revoked = nil
+	}
+	d.Status.RevokedCertificates = revoked
+}
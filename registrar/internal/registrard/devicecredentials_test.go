@@ -0,0 +1,88 @@
+package registrard
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRequireClientCertificate(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+	h.server.requireCertificates = true
+
+	ctx := context.Background()
+	pub := publicKey(t)
+	resp, err := h.register(ctx, &api.RegisterRequest{AuthToken: token, WireguardPublicKey: pub}, nil)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	creds := &api.DeviceCredentials{ID: resp.Id, Secret: resp.DeviceSecret, AllowInsecure: true}
+
+	// the secret is still accepted to be issued a certificate
+	if _, err := h.register(ctx, &api.RegisterRequest{WireguardPublicKey: pub}, creds); err != nil {
+		t.Errorf("expected registering with the device secret to succeed: %v", err)
+	}
+
+	_, err = h.client.ReportStatus(ctx, &api.ReportStatusRequest{}, grpc.PerRPCCredentials(creds))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected reporting status with the device secret to fail, got %v", err)
+	}
+
+	_, err = h.client.Deregister(ctx, &api.DeregisterRequest{}, grpc.PerRPCCredentials(creds))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected deregistering with the device secret to fail, got %v", err)
+	}
+
+	if d := h.device(t, resp.Id); d.Status.LastSeen != nil || d.Status.Decommissioned {
+		t.Errorf("expected the device to be left alone, got %+v", d.Status)
+	}
+}
+
+func TestRevokeCertificate(t *testing.T) {
+	now := time.Now()
+	expired := metav1.NewTime(now.Add(-time.Hour))
+	valid := metav1.NewTime(now.Add(time.Hour))
+
+	var d registrar.Device
+	raw := `{"certificate":{"serial":"04","notAfter":"` + now.Add(24*time.Hour).UTC().Format(time.RFC3339) + `"},` +
+		`"revokedCertificates":["01",{"serial":"02","notAfter":"` + expired.UTC().Format(time.RFC3339) + `"},` +
+		`{"serial":"03","notAfter":"` + valid.UTC().Format(time.RFC3339) + `"}]}`
+	if err := json.Unmarshal([]byte(raw), &d.Status); err != nil {
+		t.Fatalf("failed to read device status: %v", err)
+	}
+
+	revokeCertificate(&d, now)
+
+	var serials []string
+	for _, c := range d.Status.RevokedCertificates {
+		if c.NotAfter == nil {
+			t.Errorf("expected certificate %s to have an expiry", c.Serial)
+		}
+		serials = append(serials, c.Serial)
+	}
+
+	// 01 was revoked before expiries were recorded, so it's kept for as
+	// long as a new certificate is valid
+	if len(serials) != 3 || serials[0] != "01" || serials[1] != "03" || serials[2] != "04" {
+		t.Errorf("expected the expired certificate to be removed, got %v", serials)
+	}
+
+	if legacy := d.Status.RevokedCertificates[0].NotAfter.Time; !legacy.Equal(now.Add(pki.CertificateValidity)) {
+		t.Errorf("expected a legacy certificate to expire with a new certificate, got %v", legacy)
+	}
+
+	if d.Status.Certificate != nil {
+		t.Error("expected the current certificate to be revoked")
+	}
+}
@@ -0,0 +1,231 @@
+package registrard
+
+import (
+	"context"
+	"sort"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// deviceFinalizer keeps a deleted device around until it's Rancher node,
+// Kubernetes nodes, tunnel address and peer have been removed
+const deviceFinalizer = "registrar.jaredallard.me/cleanup"
+
+// hasFinalizer returns true if a device has our finalizer
+func hasFinalizer(d *registrar.Device) bool {
+	for _, f := range d.Finalizers {
+		if f == deviceFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// watchDevices queues devices that don't have our finalizer yet, are being
+// deleted or were decommissioned with a node left, so the leader can add or
+// finalize it, or remove the node
+func (s *Server) watchDevices(informer cache.SharedIndexInformer) {
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: s.enqueueDevice,
+		UpdateFunc: func(_, newObj interface{}) {
+			s.enqueueDevice(newObj)
+		},
+	})
+}
+
+// enqueueDevice queues a device if it needs it's finalizer added or run, or
+// it's node removed
+func (s *Server) enqueueDevice(obj interface{}) {
+	d, ok := obj.(*registrar.Device)
+	if !ok {
+		return
+	}
+
+	if d.DeletionTimestamp != nil || !hasFinalizer(d) || (d.Status.Decommissioned && d.Status.Node != nil) {
+		s.nodeQueue.Add(d.Name)
+	}
+}
+
+// addFinalizer adds our finalizer to a device, if it doesn't have it yet
+func (s *Server) addFinalizer(ctx context.Context, d *registrar.Device) error {
+	if hasFinalizer(d) {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := s.store.Devices(namespace).Get(ctx, d.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if !hasFinalizer(latest) {
+			latest.Finalizers = append(latest.Finalizers, deviceFinalizer)
+			latest, err = s.store.Devices(namespace).Update(ctx, latest)
+			if err != nil {
+				return err
+			}
+		}
+
+		*d = *latest
+		return nil
+	})
+	return errors.Wrap(err, "failed to add finalizer")
+}
+
+// finalizeDevice cleans up after a deleted device. It's Rancher node and
+// Kubernetes nodes are deleted, and it's tunnel address and peer are
+// released. Our finalizer is only removed once all of that succeeded, so
+// it's retried until then.
+func (s *Server) finalizeDevice(ctx context.Context, d *registrar.Device) error {
+	if !hasFinalizer(d) {
+		return nil
+	}
+
+	log.Infof("cleaning up after deleted device '%s'", d.Name)
+
+	nodes, err := s.deviceNodeNames(d)
+	if err != nil {
+		return err
+	}
+
+	if s.r != nil {
+		if err := s.removeRancherNodes(ctx, d, nodes); err != nil {
+			return err
+		}
+	}
+
+	// the device is gone, so there's nothing to drain it's pods to
+	for _, name := range nodes {
+		err := s.k.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{})
+		if kerrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to delete node '%s'", name)
+		}
+
+		log.Infof("deleted node '%s' of deleted device '%s'", name, d.Name)
+		s.recorder.Eventf(d, corev1.EventTypeNormal, "NodeDeleted", "Deleted node %s", name)
+	}
+
+	if err := s.releasePeer(ctx, d); err != nil {
+		return err
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := s.store.Devices(namespace).Get(ctx, d.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		finalizers := latest.Finalizers[:0]
+		for _, f := range latest.Finalizers {
+			if f != deviceFinalizer {
+				finalizers = append(finalizers, f)
+			}
+		}
+		latest.Finalizers = finalizers
+
+		_, err = s.store.Devices(namespace).Update(ctx, latest)
+		return err
+	})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to remove finalizer")
+	}
+
+	log.Infof("device '%s' was cleaned up", d.Name)
+	return nil
+}
+
+// deviceNodeNames returns the names of the Kubernetes nodes of a device: the
+// nodes labelled with it and the node in it's status, unless that belongs
+// to another device
+func (s *Server) deviceNodeNames(d *registrar.Device) ([]string, error) {
+	objs, err := s.nodes.ByIndex(nodeDeviceIndex, d.Name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+
+	names := make(map[string]bool, len(objs)+1)
+	for _, obj := range objs {
+		names[obj.(*corev1.Node).Name] = true
+	}
+
+	if d.Status.Node != nil && d.Status.Node.Name != "" {
+		obj, exists, err := s.nodes.GetByKey(d.Status.Node.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get node")
+		}
+
+		if !exists {
+			// it may still be known to rancher
+			names[d.Status.Node.Name] = true
+		} else if owner := obj.(*corev1.Node).Labels[deviceLabel]; owner == "" || owner == d.Name {
+			names[d.Status.Node.Name] = true
+		}
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// isRancherNodeOf returns true if a Rancher node belongs to a device, by
+// it's device label or by being one of hostnames. Nodes labeled as another
+// device's never match.
+func isRancherNodeOf(n *rancher.Node, id string, hostnames []string) bool {
+	if owner, ok := n.Labels[deviceLabel]; ok {
+		return owner == id
+	}
+
+	for _, h := range hostnames {
+		if h == n.Hostname || h == n.NodeName || h == n.RequestedHostname {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeRancherNodes deletes the Rancher nodes of a deleted device, matched
+// by it's device label or the names of it's Kubernetes nodes. Hostnames are
+// only matched within RANCHER_CLUSTER_ID, devices often share hostnames
+// like raspberrypi, so without it only the device label is matched across
+// every cluster.
+func (s *Server) removeRancherNodes(ctx context.Context, d *registrar.Device, hostnames []string) error {
+	nodes, err := s.r.ListNodes(ctx, s.rancherClusterID)
+	if err != nil {
+		return errors.Wrap(err, "failed to list rancher nodes")
+	}
+
+	if s.rancherClusterID == "" {
+		hostnames = nil
+	}
+
+	for i := range nodes {
+		n := &nodes[i]
+		if !isRancherNodeOf(n, d.Name, hostnames) {
+			continue
+		}
+
+		if err := s.r.DeleteNode(ctx, n.ID); err != nil && !rancher.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete rancher node '%s'", n.ID)
+		}
+
+		log.Infof("deleted rancher node '%s' of deleted device '%s'", n.ID, d.Name)
+		s.recorder.Eventf(d, corev1.EventTypeNormal, "RancherNodeDeleted", "Deleted rancher node %s", n.ID)
+	}
+
+	return nil
+}
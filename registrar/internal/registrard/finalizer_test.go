@@ -0,0 +1,168 @@
+package registrard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// fakeRancherNodes is a Rancher server that lists and deletes nodes
+type fakeRancherNodes struct {
+	lock  sync.Mutex
+	nodes []rancher.Node
+}
+
+func (f *fakeRancherNodes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v3/nodes":
+		var nodes []rancher.Node
+		for _, n := range f.nodes {
+			if id := r.URL.Query().Get("clusterId"); id == "" || n.ClusterID == id {
+				nodes = append(nodes, n)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"type": "collection", "data": nodes})
+	case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v3/nodes/"):
+		for i, n := range f.nodes {
+			if r.URL.Path == "/v3/nodes/"+n.ID {
+				f.nodes = append(f.nodes[:i], f.nodes[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestIsRancherNodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		node rancher.Node
+		want bool
+	}{
+		{"device label", rancher.Node{Labels: map[string]string{deviceLabel: "device-id"}}, true},
+		{"hostname", rancher.Node{Hostname: "pi-1"}, true},
+		{"node name", rancher.Node{NodeName: "pi-1"}, true},
+		{"requested hostname", rancher.Node{RequestedHostname: "pi-1"}, true},
+		{"other device", rancher.Node{Hostname: "pi-2", Labels: map[string]string{deviceLabel: "other"}}, false},
+		{"other device with the same hostname", rancher.Node{Hostname: "pi-1", Labels: map[string]string{deviceLabel: "other"}}, false},
+		{"no hostname", rancher.Node{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRancherNodeOf(&tt.node, "device-id", []string{"pi-1"}); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAddFinalizer(t *testing.T) {
+	h := newTestHarness(t, &registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"}})
+	defer h.Close()
+
+	if err := h.server.reconcileDeviceNode(context.Background(), "device-id"); err != nil {
+		t.Fatalf("failed to reconcile device: %v", err)
+	}
+
+	if d := h.device(t, "device-id"); !hasFinalizer(d) {
+		t.Errorf("expected device to get the finalizer, got %v", d.Finalizers)
+	}
+}
+
+func TestFinalizeDevice(t *testing.T) {
+	now := metav1.Now()
+	node := readyNode(corev1.ConditionTrue)
+	node.Labels = map[string]string{deviceLabel: "device-id"}
+	otherNode := readyNode(corev1.ConditionTrue)
+	otherNode.Name = "pi-2"
+
+	h := newTestHarness(t, node, otherNode, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         namespace,
+			Name:              "device-id",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{"other", deviceFinalizer},
+		},
+	})
+	defer h.Close()
+
+	f := &fakeRancherNodes{nodes: []rancher.Node{
+		{ID: "c-abcde:m-1", ClusterID: "c-abcde", Hostname: "pi-1"},
+		{ID: "c-abcde:m-2", ClusterID: "c-abcde", Hostname: "pi-2"},
+		{ID: "c-fghij:m-1", ClusterID: "c-fghij", Hostname: "pi-1"},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	r, err := rancher.NewClient(srv.URL, "rancher-key")
+	if err != nil {
+		t.Fatalf("failed to create rancher client: %v", err)
+	}
+	h.server.r = r
+	h.server.rancherClusterID = "c-abcde"
+
+	ctx := context.Background()
+	if err := h.server.reconcileDeviceNode(ctx, "device-id"); err != nil {
+		t.Fatalf("failed to finalize device: %v", err)
+	}
+
+	if len(f.nodes) != 2 || f.nodes[0].ID != "c-abcde:m-2" || f.nodes[1].ID != "c-fghij:m-1" {
+		t.Errorf("expected only the rancher node of the device to be deleted, got %v", f.nodes)
+	}
+
+	if _, err := h.k.CoreV1().Nodes().Get(ctx, "pi-1", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected node of the device to be deleted, got %v", err)
+	}
+
+	if _, err := h.k.CoreV1().Nodes().Get(ctx, "pi-2", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected other node to be left alone, got %v", err)
+	}
+
+	if d := h.device(t, "device-id"); len(d.Finalizers) != 1 || d.Finalizers[0] != "other" {
+		t.Errorf("expected only our finalizer to be removed, got %v", d.Finalizers)
+	}
+}
+
+func TestRemoveRancherNodesAllClusters(t *testing.T) {
+	f := &fakeRancherNodes{nodes: []rancher.Node{
+		{ID: "c-abcde:m-1", ClusterID: "c-abcde", Hostname: "raspberrypi", Labels: map[string]string{deviceLabel: "device-id"}},
+		{ID: "c-fghij:m-1", ClusterID: "c-fghij", Hostname: "raspberrypi"},
+	}}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	r, err := rancher.NewClient(srv.URL, "rancher-key")
+	if err != nil {
+		t.Fatalf("failed to create rancher client: %v", err)
+	}
+
+	// without RANCHER_CLUSTER_ID every cluster is listed, so a node with the
+	// same hostname in another cluster mustn't be deleted
+	s := &Server{r: r, recorder: record.NewFakeRecorder(10)}
+	d := &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: "device-id"}}
+	if err := s.removeRancherNodes(context.Background(), d, []string{"raspberrypi"}); err != nil {
+		t.Fatalf("failed to remove rancher nodes: %v", err)
+	}
+
+	if len(f.nodes) != 1 || f.nodes[0].ID != "c-fghij:m-1" {
+		t.Errorf("expected only the labeled rancher node to be deleted, got %v", f.nodes)
+	}
+}
@@ -0,0 +1,192 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// gcInterval is how often devices are checked for garbage
+const gcInterval = 10 * time.Minute
+
+// gcAction is what the garbage collector does with a device
+type gcAction int
+
+const (
+	// gcNone leaves a device alone
+	gcNone gcAction = iota
+
+	// gcDelete deletes a device that never finished registering
+	gcDelete
+
+	// gcMarkStale releases the tunnel address and peer of a device that
+	// hasn't been seen in a while, and marks it as stale
+	gcMarkStale
+)
+
+// collectAction returns what the garbage collector should do with a device
+func (s *Server) collectAction(d *registrar.Device, now time.Time) gcAction {
+	if d.Status.Decommissioned || d.Status.Stale {
+		return gcNone
+	}
+
+	// pending and rejected devices are left to an operator. Deleting a
+	// rejected device would allow it to register again.
+	if s.approval(d) != approvalGranted {
+		return gcNone
+	}
+
+	if d.Status.LastSeen == nil {
+		if now.Sub(d.CreationTimestamp.Time) <= s.gcTTL {
+			return gcNone
+		}
+
+		// devices are issued a credential when they're created, and a peer
+		// once they finish registering. Devices registered before either
+		// existed aren't half-registered.
+		if d.Status.CredentialHash != "" && d.Status.WireGuard == nil {
+			return gcDelete
+		}
+
+		// registered, but never reported it's status. Garbage collection is
+		// only enabled once every agent reports it's status, but it's still
+		// only marked as stale, so it can register again.
+		return gcMarkStale
+	}
+
+	if now.Sub(d.Status.LastSeen.Time) > s.gcTTL {
+		return gcMarkStale
+	}
+
+	return gcNone
+}
+
+// collectGarbage deletes devices that never finished registering, marks
+// devices that haven't been seen in longer than the TTL as stale, and
+// releases addresses leased to devices that no longer exist
+func (s *Server) collectGarbage(ctx context.Context) {
+	// leases are read before devices, so we never release the address of
+	// a device that was created after we listed them
+	leases, err := s.ipam.Leases(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to list ip leases")
+		return
+	}
+
+	devices, err := s.cachedDevices()
+	if err != nil {
+		log.WithError(err).Warn("failed to list devices")
+		return
+	}
+
+	now := time.Now()
+	exists := make(map[string]bool, len(devices))
+	for i := range devices {
+		d := &devices[i]
+		exists[d.Name] = true
+
+		if err := s.collectDevice(ctx, d, s.collectAction(d, now)); err != nil {
+			log.WithError(err).Warnf("failed to collect device '%s'", d.Name)
+			s.recorder.Eventf(d, corev1.EventTypeWarning, "GarbageCollectionFailed", "Failed to collect device: %v", err)
+		}
+	}
+
+	for ip, owner := range leases {
+		if exists[owner] {
+			continue
+		}
+
+		// the device could have been created after it was cached
+		if _, err := s.store.Devices(namespace).Get(ctx, owner, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+			continue
+		}
+
+		if s.gcDryRun {
+			log.Infof("dry run: would release address %s of deleted device '%s'", ip, owner)
+			continue
+		}
+
+		log.Infof("releasing address %s of deleted device '%s'", ip, owner)
+		if err := s.ipam.Release(ctx, owner); err != nil {
+			log.WithError(err).Warnf("failed to release address %s", ip)
+		}
+	}
+}
+
+// collectDevice takes a garbage collection action on a device, only
+// reporting what it would do in dry run mode
+func (s *Server) collectDevice(ctx context.Context, d *registrar.Device, action gcAction) error {
+	var reason, message string
+	switch action {
+	case gcNone:
+		return nil
+	case gcDelete:
+		reason = "GarbageCollected"
+		message = fmt.Sprintf("Device didn't finish registering within %s, deleting it", s.gcTTL)
+	case gcMarkStale:
+		reason = "MarkedStale"
+		message = fmt.Sprintf("Device wasn't seen for %s, releasing it's tunnel address and peer", s.gcTTL)
+	}
+
+	if s.gcDryRun {
+		log.Infof("dry run: device '%s': %s", d.Name, message)
+		s.recorder.Event(d, corev1.EventTypeNormal, "DryRun"+reason, "Dry run: "+message)
+		return nil
+	}
+
+	log.Infof("device '%s': %s", d.Name, message)
+	if err := s.releasePeer(ctx, d); err != nil {
+		return err
+	}
+
+	switch action {
+	case gcDelete:
+		err := s.store.Devices(namespace).Delete(ctx, d.Name, metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to delete device")
+		}
+	case gcMarkStale:
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			d, err := s.store.Devices(namespace).Get(ctx, d.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			d.Status.Stale = true
+			d.Status.WireGuard = nil
+			return s.updateStatus(ctx, d)
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to mark device as stale")
+		}
+	}
+
+	s.recorder.Event(d, corev1.EventTypeNormal, reason, message)
+	return nil
+}
+
+// releasePeer removes the WireGuard peer of a device and releases it's
+// tunnel address
+func (s *Server) releasePeer(ctx context.Context, d *registrar.Device) error {
+	if wg := d.Status.WireGuard; wg != nil && wg.PublicKey != "" {
+		pub, err := wireguard.ParseKey(wg.PublicKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse device public key")
+		}
+
+		if err := s.wg.RemovePeer(ctx, pub); err != nil {
+			return errors.Wrap(err, "failed to remove wireguard peer")
+		}
+	}
+
+	return errors.Wrap(s.ipam.Release(ctx, d.Name), "failed to release tunnel address")
+}
@@ -0,0 +1,122 @@
+package registrard
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCollectAction(t *testing.T) {
+	now := time.Now()
+	old := metav1.NewTime(now.Add(-48 * time.Hour))
+	recent := metav1.NewTime(now.Add(-time.Hour))
+
+	tests := []struct {
+		name            string
+		requireApproval bool
+		created         metav1.Time
+		spec            registrar.DeviceSpec
+		status          registrar.DeviceStatus
+		want            gcAction
+	}{
+		{
+			name:    "registering device",
+			created: recent,
+			want:    gcNone,
+		},
+		{
+			name:    "device stuck registering",
+			created: old,
+			status:  registrar.DeviceStatus{CredentialHash: "hash"},
+			want:    gcDelete,
+		},
+		{
+			name:    "device never seen",
+			created: old,
+			status:  registrar.DeviceStatus{CredentialHash: "hash", WireGuard: &registrar.WireGuardStatus{IPAddress: "10.10.0.2"}},
+			want:    gcMarkStale,
+		},
+		{
+			name:    "device registered before device credentials",
+			created: old,
+			status:  registrar.DeviceStatus{Registered: true},
+			want:    gcMarkStale,
+		},
+		{
+			name:    "device seen recently",
+			created: old,
+			status:  registrar.DeviceStatus{LastSeen: &recent},
+			want:    gcNone,
+		},
+		{
+			name:    "device not seen in a while",
+			created: old,
+			status:  registrar.DeviceStatus{LastSeen: &old},
+			want:    gcMarkStale,
+		},
+		{
+			name:    "stale device",
+			created: old,
+			status:  registrar.DeviceStatus{LastSeen: &old, Stale: true},
+			want:    gcNone,
+		},
+		{
+			name:    "decommissioned device",
+			created: old,
+			status:  registrar.DeviceStatus{Decommissioned: true},
+			want:    gcNone,
+		},
+		{
+			name:            "device pending approval",
+			requireApproval: true,
+			created:         old,
+			want:            gcNone,
+		},
+		{
+			name:    "rejected device",
+			created: old,
+			spec:    registrar.DeviceSpec{Approval: registrar.DeviceRejected},
+			want:    gcNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{requireApproval: tt.requireApproval, gcTTL: 24 * time.Hour}
+			d := &registrar.Device{
+				ObjectMeta: metav1.ObjectMeta{Name: "device-id", CreationTimestamp: tt.created},
+				Spec:       tt.spec,
+				Status:     tt.status,
+			}
+
+			if got := s.collectAction(d, now); got != tt.want {
+				t.Errorf("expected action %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCollectDeviceDryRun(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	s := &Server{recorder: recorder, gcTTL: time.Hour, gcDryRun: true}
+
+	// nothing but the recorder is set, so this fails if anything is collected
+	d := &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: "device-id"}}
+	if err := s.collectDevice(context.Background(), d, gcDelete); err != nil {
+		t.Fatalf("collectDevice() failed: %v", err)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, "DryRunGarbageCollected") {
+			t.Errorf("unexpected event %q", e)
+		}
+	default:
+		t.Error("expected an event to be recorded")
+	}
+}
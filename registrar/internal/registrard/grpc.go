@@ -2,9 +2,11 @@ package registrard
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/jaredallard-home/worker-nodes/registrar/api"
 	"github.com/sirupsen/logrus"
@@ -14,41 +16,98 @@ import (
 )
 
 type GRPCService struct {
-	lis *net.Listener
-	srv *grpc.Server
+	// lock guards lis, srv, server and closed, which Close reads while Run
+	// may still be starting
+	lock   sync.Mutex
+	lis    *net.Listener
+	srv    *grpc.Server
+	server *Server
+	closed bool
+
+	// listen and newServer replace listening on a TCP port and NewServer
+	// when set, which allows tests to run the service in memory
+	listen    func() (net.Listener, error)
+	newServer func(ctx context.Context) (*Server, error)
+
+	// disableControllers doesn't run the background controllers
+	disableControllers bool
+
+	// ready is closed once the service is about to serve, when set
+	ready chan struct{}
 }
 
 func (s *GRPCService) Run(ctx context.Context, log logrus.FieldLogger) error { //nolint:funlen
 	listAddr := ":" + strconv.Itoa(8000)
-	l, err := net.Listen("tcp", listAddr)
+	listen := func() (net.Listener, error) {
+		return net.Listen("tcp", listAddr)
+	}
+	if s.listen != nil {
+		listen = s.listen
+	}
+
+	l, err := listen()
 	if err != nil {
 		return err
 	}
+	s.lock.Lock()
 	s.lis = &l
+	s.lock.Unlock()
+
+	newServer := NewServer
+	if s.newServer != nil {
+		newServer = s.newServer
+	}
 
-	server, err := NewServer(ctx)
+	server, err := newServer(ctx)
 	if err != nil {
 		return err
 	}
+	s.lock.Lock()
+	s.server = server
+	s.lock.Unlock()
+
+	if !s.disableControllers {
+		go server.RunControllers(ctx)
+	}
 
 	serverOpts := make([]grpc.ServerOption, 0)
 	if os.Getenv("REGISTRARD_ENABLE_TLS") != "" {
 		pem := os.Getenv("REGISTRARD_PEM_FILEPATH")
 		key := os.Getenv("REGISTRARD_KEY_FILEPATH")
-		creds, err := credentials.NewServerTLSFromFile(pem, key)
+		cert, err := tls.LoadX509KeyPair(pem, key)
 		if err != nil {
 			log.WithError(err).Fatalf("failed to setup tls")
 		}
 
-		serverOpts = append(serverOpts, grpc.Creds(creds))
+		// devices present a client certificate issued by our CA once they've
+		// registered, but registering can't require one
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    server.ca.Pool(),
+		})))
+	}
+
+	srv := grpc.NewServer(serverOpts...)
+	api.RegisterRegistrarServer(srv, server)
+	api.RegisterRegistrarAdminServer(srv, &adminServer{server})
+
+	s.lock.Lock()
+	if s.closed {
+		// closed while starting, Close didn't see srv to stop it
+		s.lock.Unlock()
+		return nil
 	}
+	s.srv = srv
+	s.lock.Unlock()
 
-	s.srv = grpc.NewServer(serverOpts...)
-	api.RegisterRegistrarServer(s.srv, server)
+	if s.ready != nil {
+		close(s.ready)
+	}
 
 	// Note: .Serve() blocks
-	log.Info("Serving GRPC Service on " + listAddr)
-	if err := s.srv.Serve(l); err != nil {
+	log.Info("Serving GRPC Service on " + l.Addr().String())
+	if err := srv.Serve(l); err != nil {
 		log.Errorf("unexpected grpc Serve error: %v", err)
 		return err
 	}
@@ -57,11 +116,21 @@ func (s *GRPCService) Run(ctx context.Context, log logrus.FieldLogger) error { /
 }
 
 func (s *GRPCService) Close() error {
-	if s.srv != nil {
-		s.srv.GracefulStop()
+	s.lock.Lock()
+	s.closed = true
+	srv, server, lis := s.srv, s.server, s.lis
+	s.lock.Unlock()
+
+	if srv != nil {
+		srv.GracefulStop()
+	}
+	if server != nil {
+		if err := server.Close(); err != nil {
+			log.WithError(err).Warn("failed to close storage")
+		}
 	}
-	if s.lis != nil {
-		return (*s.lis).Close()
+	if lis != nil {
+		return (*lis).Close()
 	}
 	log.Infof("grpc service shutdown")
 	return nil
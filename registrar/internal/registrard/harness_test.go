@@ -0,0 +1,231 @@
+package registrard
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1/fake"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/storage"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fakeHub is an in-memory WireGuard hub
+type fakeHub struct {
+	lock  sync.Mutex
+	key   wireguard.Key
+	peers map[wireguard.Key]wireguard.Peer
+}
+
+func newFakeHub(t *testing.T) *fakeHub {
+	key, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate hub key: %v", err)
+	}
+
+	return &fakeHub{key: key, peers: make(map[wireguard.Key]wireguard.Peer)}
+}
+
+func (h *fakeHub) PublicKey(ctx context.Context) (wireguard.Key, error) {
+	return h.key.PublicKey(), nil
+}
+
+func (h *fakeHub) AddPeer(ctx context.Context, p *wireguard.Peer) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.peers[p.PublicKey] = *p
+	return nil
+}
+
+func (h *fakeHub) RemovePeer(ctx context.Context, pub wireguard.Key) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.peers, pub)
+	return nil
+}
+
+func (h *fakeHub) Peers(ctx context.Context) (map[wireguard.Key]wireguard.Peer, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	peers := make(map[wireguard.Key]wireguard.Peer, len(h.peers))
+	for k, p := range h.peers {
+		peers[k] = p
+	}
+	return peers, nil
+}
+
+// testHarness runs GRPCService in memory, with a fake clientset and hub
+type testHarness struct {
+	k      *fake.Clientset
+	store  v1alpha1.RegistrarV1Alpha1Interface
+	hub    *fakeHub
+	server *Server
+	client api.RegistrarClient
+	admin  api.RegistrarAdminClient
+
+	close func()
+}
+
+// newTestHarness starts GRPCService with a fake clientset containing objects.
+// The background controllers aren't run, so tests control when they do.
+func newTestHarness(t *testing.T, objects ...runtime.Object) *testHarness {
+	k := fake.NewSimpleClientset(objects...)
+	h := &testHarness{k: k}
+	h.start(t, k, storage.NewKubernetes(k, namespace))
+	return h
+}
+
+// newStandaloneHarness starts GRPCService without a cluster, storing it's
+// state in a temporary file backend containing tokens
+func newStandaloneHarness(t *testing.T, tokens ...*registrar.JoinToken) *testHarness {
+	dir, err := ioutil.TempDir("", "registrard")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	f, err := storage.OpenFile(filepath.Join(dir, "registrard.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to open file backend: %v", err)
+	}
+
+	for _, jt := range tokens {
+		if _, err := f.Registrar().JoinTokens(namespace).Create(context.Background(), jt, metav1.CreateOptions{}); err != nil {
+			f.Close()
+			os.RemoveAll(dir)
+			t.Fatalf("failed to create join token: %v", err)
+		}
+	}
+
+	h := &testHarness{}
+	h.start(t, nil, f)
+
+	closeService := h.close
+	h.close = func() {
+		closeService()
+		os.RemoveAll(dir)
+	}
+	return h
+}
+
+// start starts GRPCService with backend, managing nodes with k
+func (h *testHarness) start(t *testing.T, k kubernetes.Interface, backend storage.Backend) {
+	logrus.SetLevel(logrus.WarnLevel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.store = backend.Registrar()
+	h.hub = newFakeHub(t)
+
+	lis := bufconn.Listen(1024 * 1024)
+	svc := &GRPCService{
+		listen: func() (net.Listener, error) {
+			return lis, nil
+		},
+		newServer: func(ctx context.Context) (*Server, error) {
+			s, err := newServer(ctx, k, backend, h.hub)
+			h.server = s
+			return s, err
+		},
+		disableControllers: true,
+		ready:              make(chan struct{}),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- svc.Run(ctx, logrus.StandardLogger())
+	}()
+
+	// wait for the service to serve, so closing it can't race with Run
+	select {
+	case <-svc.ready:
+	case err := <-errs:
+		cancel()
+		t.Fatalf("failed to start grpc service: %v", err)
+	}
+
+	conn, err := grpc.DialContext(ctx, "bufconn",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to dial grpc service: %v", err)
+	}
+	h.client = api.NewRegistrarClient(conn)
+	h.admin = api.NewRegistrarAdminClient(conn)
+
+	h.close = func() {
+		conn.Close()
+		cancel()
+		svc.Close()
+	}
+}
+
+// Close stops the service
+func (h *testHarness) Close() {
+	h.close()
+}
+
+// register registers as a device, authenticating with creds if they're
+// not nil
+func (h *testHarness) register(ctx context.Context, r *api.RegisterRequest, creds *api.DeviceCredentials) (*api.RegisterResponse, error) {
+	var opts []grpc.CallOption
+	if creds != nil {
+		creds.AllowInsecure = true
+		opts = append(opts, grpc.PerRPCCredentials(creds))
+	}
+
+	return h.client.Register(ctx, r, opts...)
+}
+
+// device returns a stored device
+func (h *testHarness) device(t *testing.T, name string) *registrar.Device {
+	d, err := h.store.Devices(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get device '%s': %v", name, err)
+	}
+	return d
+}
+
+// joinToken returns the JoinToken object of a new join token, and the
+// token devices use
+func joinToken(t *testing.T, spec registrar.JoinTokenSpec) (*registrar.JoinToken, string) {
+	tok, err := jointoken.Generate()
+	if err != nil {
+		t.Fatalf("failed to generate join token: %v", err)
+	}
+
+	spec.TokenHash = tok.Hash()
+	return &registrar.JoinToken{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: tok.Name},
+		Spec:       spec,
+	}, tok.String()
+}
+
+// publicKey returns a new WireGuard public key
+func publicKey(t *testing.T) string {
+	key, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return key.PublicKey().String()
+}
@@ -0,0 +1,108 @@
+package registrard
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// inventoryStatus converts the inventory a device sent into it's status
+func inventoryStatus(inv *api.Inventory) *registrar.InventoryStatus {
+	status := &registrar.InventoryStatus{
+		Architecture: inv.Architecture,
+		Variant:      inv.Variant,
+		Model:        inv.Model,
+		Serial:       inv.Serial,
+		MemoryBytes:  int64(inv.MemoryBytes),
+		CPUs:         int(inv.Cpus),
+	}
+
+	for _, d := range inv.Disks {
+		status.Disks = append(status.Disks, registrar.DiskStatus{
+			Name:      d.Name,
+			Model:     d.Model,
+			SizeBytes: int64(d.SizeBytes),
+		})
+	}
+
+	for _, i := range inv.Interfaces {
+		status.Interfaces = append(status.Interfaces, registrar.NetworkInterfaceStatus{
+			Name:       i.Name,
+			MACAddress: i.MacAddress,
+		})
+	}
+
+	return status
+}
+
+// apiInventory converts the inventory status of a device back into the
+// inventory it sent
+func apiInventory(status *registrar.InventoryStatus) *api.Inventory {
+	inv := &api.Inventory{
+		Architecture: status.Architecture,
+		Variant:      status.Variant,
+		Model:        status.Model,
+		Serial:       status.Serial,
+		MemoryBytes:  uint64(status.MemoryBytes),
+		Cpus:         int32(status.CPUs),
+	}
+
+	for _, d := range status.Disks {
+		inv.Disks = append(inv.Disks, &api.Disk{
+			Name:      d.Name,
+			Model:     d.Model,
+			SizeBytes: uint64(d.SizeBytes),
+		})
+	}
+
+	for _, i := range status.Interfaces {
+		inv.Interfaces = append(inv.Interfaces, &api.NetworkInterface{
+			Name:       i.Name,
+			MacAddress: i.MACAddress,
+		})
+	}
+
+	return inv
+}
+
+// saveInventory stores the inventory a device sent, if it changed
+func (s *Server) saveInventory(ctx context.Context, d *registrar.Device, inv *api.Inventory) error {
+	if inv == nil {
+		return nil
+	}
+
+	status := inventoryStatus(inv)
+	if reflect.DeepEqual(d.Status.Inventory, status) {
+		return nil
+	}
+
+	s.warnDuplicateSerial(d, status.Serial)
+
+	d.Status.Inventory = status
+	return errors.Wrap(s.updateStatus(ctx, d), "failed to save device inventory")
+}
+
+// warnDuplicateSerial warns when other devices have the same hardware
+// serial number as a device, which usually means it was re-imaged and
+// registered as a new device
+func (s *Server) warnDuplicateSerial(d *registrar.Device, serial string) {
+	if serial == "" {
+		return
+	}
+
+	devices, err := s.devices.BySerial(serial)
+	if err != nil {
+		log.WithError(err).Warn("failed to find devices by serial")
+		return
+	}
+
+	for _, other := range devices {
+		if other.Name != d.Name {
+			log.Warnf("device '%s' has the same serial %s as device '%s', it was probably re-imaged", d.Name, serial, other.Name)
+		}
+	}
+}
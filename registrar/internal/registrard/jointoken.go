@@ -0,0 +1,132 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// profileLabel is the label a join token's profile is stored in on a device
+	profileLabel = "registrar.jaredallard.me/profile"
+
+	// joinTokenLabel is the label the join token a device used is stored in
+	joinTokenLabel = "registrar.jaredallard.me/join-token"
+)
+
+var (
+	errInvalidAuthToken = fmt.Errorf("invalid auth token")
+	errExpiredAuthToken = fmt.Errorf("auth token has expired")
+	errUsedAuthToken    = fmt.Errorf("auth token has reached it's maximum number of uses")
+)
+
+// authenticateJoinToken validates a join token, returning the JoinToken
+// it belongs to
+func (s *Server) authenticateJoinToken(ctx context.Context, raw string) (*registrar.JoinToken, error) {
+	t, err := jointoken.Parse(raw)
+	if err != nil {
+		return nil, errInvalidAuthToken
+	}
+
+	jt, err := s.store.JoinTokens(namespace).Get(ctx, t.Name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, errInvalidAuthToken
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get join token")
+	}
+
+	if !jointoken.Verify(t.Secret, jt.Spec.TokenHash) {
+		return nil, errInvalidAuthToken
+	}
+
+	if jt.Spec.Expires != nil && time.Now().After(jt.Spec.Expires.Time) {
+		return nil, errExpiredAuthToken
+	}
+
+	return jt, nil
+}
+
+// useJoinToken records that a device joined using a join token, failing
+// if the token has no uses left. Devices are only counted once, used is
+// false if the device was already counted.
+func (s *Server) useJoinToken(ctx context.Context, name, id string) (used bool, err error) {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		jt, err := s.store.JoinTokens(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to get join token")
+		}
+
+		for _, d := range jt.Status.Devices {
+			if d == id {
+				used = false
+				return nil
+			}
+		}
+
+		if jt.Spec.MaxUses != 0 && jt.Status.Uses >= jt.Spec.MaxUses {
+			return errUsedAuthToken
+		}
+
+		now := metav1.Now()
+		jt.Status.Uses++
+		jt.Status.LastUsed = &now
+		jt.Status.Devices = append(jt.Status.Devices, id)
+
+		_, err = s.store.JoinTokens(namespace).Update(ctx, jt)
+		used = err == nil
+		return err
+	})
+	return used, err
+}
+
+// releaseJoinToken gives back the use of a join token recorded by
+// useJoinToken, for when the device it was used for couldn't be created
+func (s *Server) releaseJoinToken(ctx context.Context, name, id string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		jt, err := s.store.JoinTokens(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to get join token")
+		}
+
+		devices := jt.Status.Devices[:0]
+		for _, d := range jt.Status.Devices {
+			if d != id {
+				devices = append(devices, d)
+			}
+		}
+		if len(devices) == len(jt.Status.Devices) {
+			return nil
+		}
+
+		jt.Status.Devices = devices
+		if jt.Status.Uses > 0 {
+			jt.Status.Uses--
+		}
+
+		_, err = s.store.JoinTokens(namespace).Update(ctx, jt)
+		return err
+	})
+}
+
+// joinTokenLabels returns the labels a device joining with a token
+// should have
+func joinTokenLabels(jt *registrar.JoinToken) map[string]string {
+	labels := make(map[string]string)
+	for k, v := range jt.Spec.Labels {
+		labels[k] = v
+	}
+
+	if jt.Spec.Profile != "" {
+		labels[profileLabel] = jt.Spec.Profile
+	}
+	labels[joinTokenLabel] = jt.Name
+
+	return labels
+}
@@ -0,0 +1,328 @@
+package registrard
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// defaultBootstrapTokenTTL is how long the bootstrap tokens devices join
+	// with are valid for, unless BOOTSTRAP_TOKEN_TTL says otherwise
+	defaultBootstrapTokenTTL = time.Hour
+
+	// clusterInfoTTL is how long the cluster-info ConfigMap is cached for
+	clusterInfoTTL = 10 * time.Minute
+
+	// bootstrapTokenGroup is the group nodes joining with a bootstrap token
+	// are in, which kubeadm allows to join the cluster
+	bootstrapTokenGroup = "system:bootstrappers:kubeadm:default-node-token"
+
+	// bootstrapTokenChars are the characters of bootstrap token IDs and
+	// secrets
+	bootstrapTokenChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// clusterInfo is what devices need to know about the cluster to join it,
+// from the cluster-info ConfigMap kubeadm publishes
+type clusterInfo struct {
+	// endpoint is the host:port of the API server
+	endpoint string
+
+	// caCertHashes are the hashes of the CA certificates of the cluster
+	caCertHashes []string
+}
+
+// kubeadmBackend has devices join with kubeadm. Every device gets it's own
+// short lived bootstrap token, which is renewed once half of it's TTL is up.
+// The ID of the token is kept in the status of the device, the secret only
+// in it's bootstrap-token-<id> Secret.
+type kubeadmBackend struct {
+	k    kubernetes.Interface
+	host string
+	ttl  time.Duration
+
+	// store is where devices are stored, updateStatus saves their status
+	store        v1alpha1.RegistrarV1Alpha1Interface
+	updateStatus func(ctx context.Context, d *registrar.Device) error
+
+	// now returns the current time, it's replaced in tests
+	now func() time.Time
+
+	lock        sync.Mutex
+	info        *clusterInfo
+	infoExpires time.Time
+}
+
+// newKubeadmBackend returns a kubeadm backend that creates bootstrap tokens
+// valid for ttl, saving them with updateStatus. host overrides the API
+// server in cluster-info.
+func newKubeadmBackend(k kubernetes.Interface, store v1alpha1.RegistrarV1Alpha1Interface,
+	updateStatus func(context.Context, *registrar.Device) error, host string, ttl time.Duration) *kubeadmBackend {
+	return &kubeadmBackend{k: k, store: store, updateStatus: updateStatus, host: host, ttl: ttl, now: time.Now}
+}
+
+// Join sets the API server, bootstrap token and CA certificate hashes a
+// device joins with. spec.clusterHost of the device overrides the API server.
+func (b *kubeadmBackend) Join(ctx context.Context, d *registrar.Device, conf *api.DeviceConfig) error {
+	info, err := b.clusterInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	token, err := b.bootstrapToken(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	endpoint := info.endpoint
+	if d.Spec.ClusterHost != "" {
+		endpoint = apiServerEndpoint(d.Spec.ClusterHost)
+	} else if b.host != "" {
+		endpoint = apiServerEndpoint(b.host)
+	}
+
+	conf.Join = &api.DeviceConfig_Kubeadm{Kubeadm: &api.KubeadmConfig{
+		ApiServerEndpoint: endpoint,
+		Token:             token,
+		CaCertHashes:      info.caCertHashes,
+	}}
+	return nil
+}
+
+// apiServerEndpoint returns the host:port of an API server, which can be
+// given as a URL
+func apiServerEndpoint(host string) string {
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return host
+}
+
+// clusterInfo returns the cached cluster info, reading it from the
+// cluster-info ConfigMap in kube-public when the cache has expired
+func (b *kubeadmBackend) clusterInfo(ctx context.Context) (*clusterInfo, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := b.now()
+	if b.info != nil && now.Before(b.infoExpires) {
+		return b.info, nil
+	}
+
+	cm, err := b.k.CoreV1().ConfigMaps(metav1.NamespacePublic).Get(ctx, "cluster-info", metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cluster-info, was the cluster created with kubeadm?")
+	}
+
+	info, err := parseClusterInfo([]byte(cm.Data["kubeconfig"]))
+	if err != nil {
+		return nil, err
+	}
+
+	b.info = info
+	b.infoExpires = now.Add(clusterInfoTTL)
+	return info, nil
+}
+
+// parseClusterInfo returns the API server and CA certificate hashes in the
+// kubeconfig of cluster-info
+func parseClusterInfo(kubeconfig []byte) (*clusterInfo, error) {
+	c, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse cluster-info")
+	}
+
+	for _, cluster := range c.Clusters {
+		info := &clusterInfo{endpoint: apiServerEndpoint(cluster.Server)}
+
+		rest := cluster.CertificateAuthorityData
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse ca certificate")
+			}
+
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			info.caCertHashes = append(info.caCertHashes, "sha256:"+hex.EncodeToString(sum[:]))
+		}
+
+		if len(info.caCertHashes) == 0 {
+			return nil, fmt.Errorf("cluster-info has no ca certificate")
+		}
+
+		return info, nil
+	}
+
+	return nil, fmt.Errorf("cluster-info has no cluster")
+}
+
+// generateBootstrapTokenPart returns n random bootstrap token characters,
+// for the ID or secret of a token
+func generateBootstrapTokenPart(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(bootstrapTokenChars)))
+	for i := range b {
+		c, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read random bytes")
+		}
+		b[i] = bootstrapTokenChars[c.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// usable returns true if a bootstrap token has more than half of it's TTL
+// left at now
+func (b *kubeadmBackend) usable(t *registrar.BootstrapTokenStatus, now time.Time) bool {
+	return t != nil && t.Expires.Sub(now) > b.ttl/2
+}
+
+// bootstrapToken returns the bootstrap token of a device. Once half of it's
+// TTL is up it's replaced with a new one, stored in a bootstrap-token-<id>
+// Secret in kube-system, and the Secret of the old one is deleted. Only the
+// ID is kept in the status of the device, the secret is read from the Secret.
+func (b *kubeadmBackend) bootstrapToken(ctx context.Context, d *registrar.Device) (string, error) {
+	now := b.now()
+
+	// missing is the ID of a usable token who's Secret is gone
+	var missing string
+	if t := d.Status.BootstrapToken; b.usable(t, now) {
+		token, err := b.getBootstrapToken(ctx, d, t.ID)
+		if err == nil {
+			return token, nil
+		}
+		if !kerrors.IsNotFound(errors.Cause(err)) {
+			return "", err
+		}
+
+		log.Warnf("bootstrap token '%s' of device '%s' was deleted, replacing it", t.ID, d.Name)
+		missing = t.ID
+	}
+
+	id, err := generateBootstrapTokenPart(6)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := generateBootstrapTokenPart(16)
+	if err != nil {
+		return "", err
+	}
+
+	token := &registrar.BootstrapTokenStatus{ID: id, Expires: metav1.NewTime(now.Add(b.ttl))}
+	if err := b.createBootstrapTokenSecret(ctx, d, token, secret); err != nil {
+		return "", err
+	}
+
+	// renewed returns true if another request replaced the token first
+	renewed := func(t *registrar.BootstrapTokenStatus) bool {
+		return b.usable(t, now) && t.ID != missing
+	}
+
+	// the Secret is created first, so every token in a status has one
+	var previous *registrar.BootstrapTokenStatus
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := b.store.Devices(namespace).Get(ctx, d.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		previous = latest.Status.BootstrapToken
+		if renewed(previous) {
+			return nil
+		}
+
+		latest.Status.BootstrapToken = token
+		return b.updateStatus(ctx, latest)
+	})
+	if err != nil || renewed(previous) {
+		b.deleteBootstrapTokenSecret(ctx, token.ID)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to save bootstrap token")
+		}
+		return b.getBootstrapToken(ctx, d, previous.ID)
+	}
+
+	if previous != nil {
+		b.deleteBootstrapTokenSecret(ctx, previous.ID)
+	}
+
+	log.Infof("created bootstrap token '%s' for device '%s'", id, d.Name)
+	return id + "." + secret, nil
+}
+
+// getBootstrapToken returns the bootstrap token with id, read from it's
+// Secret, which has to belong to the device
+func (b *kubeadmBackend) getBootstrapToken(ctx context.Context, d *registrar.Device, id string) (string, error) {
+	s, err := b.k.CoreV1().Secrets(metav1.NamespaceSystem).Get(ctx, "bootstrap-token-"+id, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get bootstrap token '%s'", id)
+	}
+
+	if s.Type != corev1.SecretTypeBootstrapToken || s.Labels[deviceLabel] != d.Name || string(s.Data["token-id"]) != id {
+		return "", fmt.Errorf("bootstrap token '%s' doesn't belong to device '%s'", id, d.Name)
+	}
+
+	return id + "." + string(s.Data["token-secret"]), nil
+}
+
+// createBootstrapTokenSecret creates the Secret of a device's bootstrap token
+func (b *kubeadmBackend) createBootstrapTokenSecret(ctx context.Context, d *registrar.Device, t *registrar.BootstrapTokenStatus, secret string) error {
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "bootstrap-token-" + t.ID,
+			Namespace: metav1.NamespaceSystem,
+			Labels:    map[string]string{deviceLabel: d.Name},
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		Data: map[string][]byte{
+			"description":                    []byte(fmt.Sprintf("Bootstrap token of device %s, created by registrard", d.Name)),
+			"token-id":                       []byte(t.ID),
+			"token-secret":                   []byte(secret),
+			"expiration":                     []byte(t.Expires.UTC().Format(time.RFC3339)),
+			"usage-bootstrap-authentication": []byte("true"),
+			"usage-bootstrap-signing":        []byte("true"),
+			"auth-extra-groups":              []byte(bootstrapTokenGroup),
+		},
+	}
+
+	_, err := b.k.CoreV1().Secrets(metav1.NamespaceSystem).Create(ctx, s, metav1.CreateOptions{})
+	return errors.Wrap(err, "failed to create bootstrap token")
+}
+
+// deleteBootstrapTokenSecret deletes the Secret of a bootstrap token. It
+// expires anyway, so failing to is only logged.
+func (b *kubeadmBackend) deleteBootstrapTokenSecret(ctx context.Context, id string) {
+	err := b.k.CoreV1().Secrets(metav1.NamespaceSystem).Delete(ctx, "bootstrap-token-"+id, metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		log.WithError(err).Warnf("failed to delete bootstrap token '%s'", id)
+	}
+}
@@ -0,0 +1,197 @@
+package registrard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1/fake"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// clusterInfoConfigMap returns a cluster-info ConfigMap like kubeadm
+// creates, and the hash of it's CA certificate
+func clusterInfoConfigMap(t *testing.T) (*corev1.ConfigMap, string) {
+	ca, err := pki.NewCA("kubernetes")
+	if err != nil {
+		t.Fatalf("failed to create ca: %v", err)
+	}
+
+	c := clientcmdapi.NewConfig()
+	c.Clusters[""] = &clientcmdapi.Cluster{
+		Server:                   "https://10.10.0.1:6443",
+		CertificateAuthorityData: ca.CertificatePEM(),
+	}
+
+	kubeconfig, err := clientcmd.Write(*c)
+	if err != nil {
+		t.Fatalf("failed to write kubeconfig: %v", err)
+	}
+
+	cert, err := pki.ParseCertificate(ca.CertificatePEM())
+	if err != nil {
+		t.Fatalf("failed to parse ca certificate: %v", err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespacePublic, Name: "cluster-info"},
+		Data:       map[string]string{"kubeconfig": string(kubeconfig)},
+	}, "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newTestKubeadmBackend returns a kubeadm backend using a fake clientset
+// with objects, and the device it stores
+func newTestKubeadmBackend(objects ...runtime.Object) (*kubeadmBackend, *fake.Clientset) {
+	k := fake.NewSimpleClientset(objects...)
+	store := k.RegistrarV1Alpha1Client()
+	updateStatus := func(ctx context.Context, d *registrar.Device) error {
+		_, err := store.Devices(namespace).UpdateStatus(ctx, d)
+		return err
+	}
+
+	return newKubeadmBackend(k, store, updateStatus, "", time.Hour), k
+}
+
+func TestKubeadmJoin(t *testing.T) {
+	cm, hash := clusterInfoConfigMap(t)
+	b, k := newTestKubeadmBackend(cm, &registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"}})
+
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	clusterHost := ""
+	join := func() (endpoint, token string) {
+		// like the server, the latest device is joined
+		d, err := k.RegistrarV1Alpha1Client().Devices(namespace).Get(ctx, "device-id", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get device: %v", err)
+		}
+		d.Spec.ClusterHost = clusterHost
+
+		conf := &api.DeviceConfig{}
+		if err := b.Join(ctx, d, conf); err != nil {
+			t.Fatalf("failed to join: %v", err)
+		}
+
+		kc := conf.GetKubeadm()
+		if kc == nil || len(kc.CaCertHashes) != 1 || kc.CaCertHashes[0] != hash {
+			t.Fatalf("expected a kubeadm join config with the ca hash, got %v", conf.Join)
+		}
+		return kc.ApiServerEndpoint, kc.Token
+	}
+
+	endpoint, token := join()
+	if endpoint != "10.10.0.1:6443" {
+		t.Errorf("expected the api server of cluster-info, got %q", endpoint)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || len(parts[0]) != 6 || len(parts[1]) != 16 {
+		t.Fatalf("expected a bootstrap token, got %q", token)
+	}
+
+	secrets := k.CoreV1().Secrets(metav1.NamespaceSystem)
+	s, err := secrets.Get(ctx, "bootstrap-token-"+parts[0], metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected a bootstrap token secret: %v", err)
+	}
+
+	if s.Type != corev1.SecretTypeBootstrapToken || string(s.Data["token-secret"]) != parts[1] || s.Labels[deviceLabel] != "device-id" {
+		t.Errorf("unexpected bootstrap token secret %+v", s)
+	}
+
+	d, err := k.RegistrarV1Alpha1Client().Devices(namespace).Get(ctx, "device-id", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get device: %v", err)
+	}
+
+	if bt := d.Status.BootstrapToken; bt == nil || bt.ID != parts[0] {
+		t.Errorf("expected the token id to be kept in the status, got %+v", bt)
+	}
+
+	// the token is reused, reading it's secret from the Secret
+	k.ClearActions()
+	if _, again := join(); again != token {
+		t.Errorf("expected the token to be reused, got %q", again)
+	}
+
+	for _, a := range k.Actions() {
+		if a.GetResource().Resource == "secrets" && a.GetVerb() != "get" {
+			t.Errorf("expected secrets to only be read, got %s", a.GetVerb())
+		}
+	}
+
+	now = now.Add(45 * time.Minute)
+	_, renewed := join()
+	if renewed == token {
+		t.Error("expected the token to be renewed after half of it's ttl")
+	}
+
+	if _, err := secrets.Get(ctx, "bootstrap-token-"+parts[0], metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the secret of the old token to be deleted, got %v", err)
+	}
+
+	// a token who's Secret was deleted is replaced
+	if err := secrets.Delete(ctx, "bootstrap-token-"+strings.Split(renewed, ".")[0], metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete secret: %v", err)
+	}
+
+	if _, replaced := join(); replaced == renewed {
+		t.Error("expected the token of a deleted secret to be replaced")
+	}
+
+	clusterHost = "https://pi-server:6443"
+	if endpoint, _ := join(); endpoint != "pi-server:6443" {
+		t.Errorf("expected the device to override the api server, got %q", endpoint)
+	}
+}
+
+func TestKubeadmJoinRenewedConcurrently(t *testing.T) {
+	cm, _ := clusterInfoConfigMap(t)
+	renewed := &registrar.BootstrapTokenStatus{ID: "abcdef", Expires: metav1.NewTime(time.Now().Add(time.Hour))}
+	b, k := newTestKubeadmBackend(cm, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"},
+		Status:     registrar.DeviceStatus{BootstrapToken: renewed},
+	}, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceSystem,
+			Name:      "bootstrap-token-abcdef",
+			Labels:    map[string]string{deviceLabel: "device-id"},
+		},
+		Type: corev1.SecretTypeBootstrapToken,
+		Data: map[string][]byte{"token-id": []byte("abcdef"), "token-secret": []byte("0123456789abcdef")},
+	})
+
+	// the device was read before another request renewed it's token
+	d := &registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"}}
+	conf := &api.DeviceConfig{}
+	if err := b.Join(context.Background(), d, conf); err != nil {
+		t.Fatalf("failed to join: %v", err)
+	}
+
+	if token := conf.GetKubeadm().Token; token != "abcdef.0123456789abcdef" {
+		t.Errorf("expected the renewed token, got %q", token)
+	}
+
+	secrets, err := k.CoreV1().Secrets(metav1.NamespaceSystem).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list secrets: %v", err)
+	}
+
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != "bootstrap-token-abcdef" {
+		t.Errorf("expected the secret of the unused token to be deleted, got %d secrets", len(secrets.Items))
+	}
+}
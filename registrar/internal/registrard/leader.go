@@ -0,0 +1,76 @@
+package registrard
+
+import (
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// leaderLease is the name of the lease replicas of registrard elect a
+	// leader with
+	leaderLease = "registrard"
+
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// leaderIdentity returns the identity of this replica in leader elections
+func leaderIdentity() (string, error) {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name, nil
+	}
+
+	return os.Hostname()
+}
+
+// runAsLeader runs fn whenever this replica is the elected leader, until ctx
+// is canceled. The context passed to fn is canceled when leadership is lost.
+func (s *Server) runAsLeader(ctx context.Context, fn func(ctx context.Context)) error {
+	id, err := leaderIdentity()
+	if err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaderLease,
+			Namespace: namespace,
+		},
+		Client:     s.k.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: id},
+	}
+
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            leaderLease,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					log.Info("elected leader, starting controllers")
+					fn(ctx)
+				},
+				OnStoppedLeading: func() {
+					log.Info("no longer the leader, stopped controllers")
+				},
+				OnNewLeader: func(identity string) {
+					if identity != id {
+						log.Infof("'%s' is the leader", identity)
+					}
+				},
+			},
+		})
+	}
+
+	return nil
+}
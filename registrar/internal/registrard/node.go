@@ -0,0 +1,119 @@
+package registrard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/kube"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// deviceLabel is the label on a node that stores the device it belongs to
+const deviceLabel = registrar.DeviceLabel
+
+// getDeviceNode returns a node of a device. Nodes labeled as another
+// device's are refused. Nodes without the label, e.g. ones that joined
+// before agents set it, could be anything, like the server, so they're
+// only returned if they're annotated with the device or in it's status.
+// If the node doesn't exist, or isn't known to be the device's, nil is
+// returned.
+func (s *Server) getDeviceNode(ctx context.Context, d *registrar.Device, name string) (*corev1.Node, error) {
+	n, err := s.k.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get node")
+	}
+
+	if owner := n.Labels[deviceLabel]; owner != "" {
+		if owner != d.Name {
+			return nil, fmt.Errorf("node '%s' doesn't belong to device '%s'", name, d.Name)
+		}
+		return n, nil
+	}
+
+	if n.Annotations[deviceAnnotation] == d.Namespace+"/"+d.Name {
+		return n, nil
+	}
+
+	if d.Status.Node != nil && d.Status.Node.Name == name {
+		return n, nil
+	}
+
+	return nil, nil
+}
+
+// cordonDeviceNode cordons the node of a decommissioned device, labeling
+// it with the device if it isn't already, so the node controller drains
+// and deletes it
+func (s *Server) cordonDeviceNode(ctx context.Context, n *corev1.Node, d *registrar.Device) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{deviceLabel: d.Name},
+		},
+		"spec": map[string]interface{}{
+			"unschedulable": true,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create node patch")
+	}
+
+	_, err = s.k.CoreV1().Nodes().Patch(ctx, n.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return errors.Wrapf(err, "failed to cordon node '%s'", n.Name)
+}
+
+// removeDecommissionedNodes drains and deletes the nodes of a
+// decommissioned device in the background, since draining can take
+// minutes. Nodes that are already being removed are skipped, failures are
+// retried the next time the device is reconciled.
+func (s *Server) removeDecommissionedNodes(ctx context.Context, d *registrar.Device) error {
+	objs, err := s.nodes.ByIndex(nodeDeviceIndex, d.Name)
+	if err != nil {
+		return errors.Wrap(err, "failed to list nodes")
+	}
+
+	for _, obj := range objs {
+		name := obj.(*corev1.Node).Name
+
+		s.removingLock.Lock()
+		removing := s.removing[name]
+		s.removing[name] = true
+		s.removingLock.Unlock()
+
+		if removing {
+			continue
+		}
+
+		go func(d *registrar.Device, name string) {
+			defer func() {
+				s.removingLock.Lock()
+				delete(s.removing, name)
+				s.removingLock.Unlock()
+			}()
+
+			if err := s.removeNode(ctx, name); err != nil {
+				log.WithError(err).Warnf("failed to remove node '%s' of decommissioned device '%s'", name, d.Name)
+				s.recorder.Eventf(d, corev1.EventTypeWarning, "NodeRemovalFailed", "Failed to remove node %s: %v", name, err)
+				return
+			}
+
+			log.Infof("removed node '%s' of decommissioned device '%s'", name, d.Name)
+			s.recorder.Eventf(d, corev1.EventTypeNormal, "NodeDeleted", "Deleted node %s", name)
+		}(d.DeepCopy(), name)
+	}
+
+	return nil
+}
+
+// removeNode cordons, drains and deletes a node
+func (s *Server) removeNode(ctx context.Context, name string) error {
+	return kube.RemoveNode(ctx, s.k, name)
+}
@@ -0,0 +1,195 @@
+package registrard
+
+import (
+	"context"
+	"encoding/json"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// deviceAnnotation is the annotation on a node that points back to the
+	// device it belongs to, as <namespace>/<name>
+	deviceAnnotation = "registrar.jaredallard.me/device-ref"
+
+	// deviceModelAnnotation is the annotation on a node that stores the
+	// board model of the device it belongs to
+	deviceModelAnnotation = "registrar.jaredallard.me/device-model"
+)
+
+// watchNodes queues the devices of nodes that change, so the leader can
+// reconcile them. Nodes are matched to devices by the deviceLabel agents set
+// when they join.
+func (s *Server) watchNodes(ctx context.Context, informer cache.SharedIndexInformer) {
+	s.nodeQueue = workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "nodes")
+	go func() {
+		<-ctx.Done()
+		s.nodeQueue.ShutDown()
+	}()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: s.enqueueNode,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// a node can be relabelled, so both devices need to be updated
+			s.enqueueNode(oldObj)
+			s.enqueueNode(newObj)
+		},
+		DeleteFunc: s.enqueueNode,
+	})
+}
+
+// enqueueNode queues the devices a node belongs to
+func (s *Server) enqueueNode(obj interface{}) {
+	if tomb, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tomb.Obj
+	}
+
+	n, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+
+	if id := n.Labels[deviceLabel]; id != "" {
+		s.nodeQueue.Add(id)
+	}
+
+	// devices that still think they're this node
+	devices, err := s.devices.ByNodeName(n.Name)
+	if err != nil {
+		log.WithError(err).Warnf("failed to find devices of node '%s'", n.Name)
+		return
+	}
+
+	for _, d := range devices {
+		s.nodeQueue.Add(d.Name)
+	}
+}
+
+// runNodeController keeps the node status of devices and the device
+// annotations of nodes up to date until ctx is canceled
+func (s *Server) runNodeController(ctx context.Context) {
+	go func() {
+		for s.processNextNode(ctx) {
+		}
+	}()
+
+	<-ctx.Done()
+}
+
+// processNextNode reconciles the next queued device, returning false when
+// the queue has been shut down or ctx was canceled
+func (s *Server) processNextNode(ctx context.Context) bool {
+	key, shutdown := s.nodeQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.nodeQueue.Done(key)
+
+	if ctx.Err() != nil {
+		// we're no longer the leader, leave it for the next one
+		s.nodeQueue.Add(key)
+		return false
+	}
+
+	id := key.(string)
+	if err := s.reconcileDeviceNode(ctx, id); err != nil {
+		log.WithError(err).Warnf("failed to reconcile node of device '%s'", id)
+		s.nodeQueue.AddRateLimited(key)
+		return true
+	}
+
+	s.nodeQueue.Forget(key)
+	return true
+}
+
+// reconcileDeviceNode records the node a device joined the cluster as in
+// it's status, and annotates the node with the device. Devices get our
+// finalizer, and are cleaned up after once they're deleted. The nodes of
+// decommissioned devices are drained and deleted.
+func (s *Server) reconcileDeviceNode(ctx context.Context, id string) error {
+	d, err := s.cachedDevice(ctx, id)
+	if kerrors.IsNotFound(err) {
+		// a node can claim to be a device that doesn't exist
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to get device")
+	}
+
+	if d.DeletionTimestamp != nil {
+		return s.finalizeDevice(ctx, d)
+	}
+
+	if err := s.addFinalizer(ctx, d); err != nil {
+		return err
+	}
+
+	if d.Status.Decommissioned {
+		if err := s.removeDecommissionedNodes(ctx, d); err != nil {
+			return err
+		}
+		return s.reconcileDevice(ctx, d)
+	}
+
+	node, err := s.findDeviceNode(ctx, d)
+	if err != nil {
+		return err
+	}
+
+	if node != nil {
+		if err := s.annotateNode(ctx, node, d); err != nil {
+			return err
+		}
+	}
+
+	return s.reconcileDevice(ctx, d)
+}
+
+// nodeAnnotations returns the annotations a node of a device should have
+func nodeAnnotations(d *registrar.Device) map[string]string {
+	annotations := map[string]string{
+		deviceAnnotation: d.Namespace + "/" + d.Name,
+	}
+
+	if d.Status.Inventory != nil && d.Status.Inventory.Model != "" {
+		annotations[deviceModelAnnotation] = d.Status.Inventory.Model
+	}
+
+	return annotations
+}
+
+// annotateNode points a node back to the device it belongs to, if it
+// doesn't already
+func (s *Server) annotateNode(ctx context.Context, n *corev1.Node, d *registrar.Device) error {
+	annotations := nodeAnnotations(d)
+
+	changed := false
+	for k, v := range annotations {
+		if n.Annotations[k] != v {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create node patch")
+	}
+
+	_, err = s.k.CoreV1().Nodes().Patch(ctx, n.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return errors.Wrapf(err, "failed to annotate node '%s'", n.Name)
+}
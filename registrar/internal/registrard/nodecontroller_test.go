@@ -0,0 +1,87 @@
+package registrard
+
+import (
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func node(name string, created time.Time, ready corev1.ConditionStatus) *corev1.Node {
+	n := readyNode(ready)
+	n.Name = name
+	n.CreationTimestamp = metav1.NewTime(created)
+	return n
+}
+
+func TestPickDeviceNode(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+
+	tests := []struct {
+		name  string
+		nodes []*corev1.Node
+		want  string
+	}{
+		{
+			name: "no nodes",
+		},
+		{
+			name:  "one node",
+			nodes: []*corev1.Node{node("pi-1", now, corev1.ConditionFalse)},
+			want:  "pi-1",
+		},
+		{
+			name: "prefers ready nodes",
+			nodes: []*corev1.Node{
+				node("pi-1", old, corev1.ConditionTrue),
+				node("pi-2", now, corev1.ConditionFalse),
+			},
+			want: "pi-1",
+		},
+		{
+			name: "prefers newer nodes",
+			nodes: []*corev1.Node{
+				node("pi-1", old, corev1.ConditionFalse),
+				node("pi-2", now, corev1.ConditionFalse),
+			},
+			want: "pi-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pickDeviceNode(tt.nodes)
+			if got == nil {
+				if tt.want != "" {
+					t.Errorf("expected node '%s', got none", tt.want)
+				}
+				return
+			}
+
+			if got.Name != tt.want {
+				t.Errorf("expected node '%s', got '%s'", tt.want, got.Name)
+			}
+		})
+	}
+}
+
+func TestNodeAnnotations(t *testing.T) {
+	d := &registrar.Device{ObjectMeta: metav1.ObjectMeta{Namespace: "registrar", Name: "device-id"}}
+
+	annotations := nodeAnnotations(d)
+	if annotations[deviceAnnotation] != "registrar/device-id" {
+		t.Errorf("unexpected device annotation '%s'", annotations[deviceAnnotation])
+	}
+
+	if _, ok := annotations[deviceModelAnnotation]; ok {
+		t.Error("expected no model annotation without an inventory")
+	}
+
+	d.Status.Inventory = &registrar.InventoryStatus{Model: "Raspberry Pi 4 Model B Rev 1.1"}
+	if got := nodeAnnotations(d)[deviceModelAnnotation]; got != "Raspberry Pi 4 Model B Rev 1.1" {
+		t.Errorf("unexpected model annotation '%s'", got)
+	}
+}
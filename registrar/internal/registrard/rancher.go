@@ -0,0 +1,136 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/clustertoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultRancherRoles are the roles of a node unless it's device says
+// otherwise
+var defaultRancherRoles = []string{"worker"}
+
+// rancherRoles are the roles a node of a Rancher custom cluster can have
+var rancherRoles = map[string]bool{
+	"etcd":         true,
+	"controlplane": true,
+	"worker":       true,
+}
+
+// newRancherClient returns a client for the rancher server at host, making
+// sure it's token is valid so a bad one is caught on startup
+func newRancherClient(ctx context.Context, host, token string) (*rancher.Client, error) {
+	r, err := rancher.NewClient(host, token)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create rancher client")
+	}
+
+	if err := r.Verify(ctx); err != nil {
+		if rancher.IsUnauthorized(err) {
+			return nil, errors.Wrap(err, "RANCHER_TOKEN was rejected by rancher")
+		}
+		return nil, errors.Wrap(err, "failed to verify rancher token")
+	}
+
+	log.WithField("host", host).Info("connected to rancher")
+	return r, nil
+}
+
+// nodeCommand is what's needed from the command Rancher gives nodes to
+// join a custom cluster with
+type nodeCommand struct {
+	image      string
+	server     string
+	caChecksum string
+}
+
+// parseNodeCommand parses the `docker run` command of a cluster registration
+// token, e.g. `sudo docker run -d ... rancher/rancher-agent:v2.4.5 --server
+// https://rancher --token abc --ca-checksum def`
+func parseNodeCommand(cmd string) nodeCommand {
+	var nc nodeCommand
+
+	fields := strings.Fields(cmd)
+	for i, f := range fields {
+		var next string
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+
+		switch {
+		case f == "--server":
+			nc.server = next
+		case f == "--ca-checksum":
+			nc.caChecksum = next
+		case nc.image == "" && strings.Contains(f, "rancher-agent:"):
+			nc.image = f
+		}
+	}
+
+	return nc
+}
+
+// rancherBackend has devices join a Rancher custom cluster by running
+// rancher-agent, using the registration token of the cluster
+type rancherBackend struct {
+	tokens        clustertoken.Source
+	registrations *clustertoken.Rancher
+
+	// host is the Rancher server, used when the node command doesn't
+	// have one
+	host string
+}
+
+// Join sets how a device joins the Rancher custom cluster
+func (b *rancherBackend) Join(ctx context.Context, d *registrar.Device, conf *api.DeviceConfig) error {
+	crt, err := b.registrations.Registration(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get cluster registration token")
+	}
+
+	token := d.Spec.ClusterToken
+	if token == "" {
+		token, err = b.tokens.Token(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get cluster token")
+		}
+	}
+
+	roles := d.Spec.RancherRoles
+	if len(roles) == 0 {
+		roles = defaultRancherRoles
+	}
+
+	for _, r := range roles {
+		if !rancherRoles[r] {
+			return fmt.Errorf("device '%s' has unknown rancher role '%s'", d.Name, r)
+		}
+	}
+
+	nc := parseNodeCommand(crt.NodeCommand)
+	rc := &api.RancherConfig{
+		ServerUrl:  nc.server,
+		Token:      token,
+		CaChecksum: nc.caChecksum,
+		AgentImage: nc.image,
+		Roles:      append([]string(nil), roles...),
+	}
+
+	if rc.ServerUrl == "" {
+		rc.ServerUrl = b.host
+	}
+
+	if rc.AgentImage == "" {
+		return fmt.Errorf("failed to find the rancher-agent image in the node command of '%s'", crt.ID)
+	}
+
+	conf.Join = &api.DeviceConfig_Rancher{Rancher: rc}
+	return nil
+}
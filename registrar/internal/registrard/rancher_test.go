@@ -0,0 +1,128 @@
+package registrard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/clustertoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testNodeCommand = "sudo docker run -d --privileged --restart=unless-stopped --net=host " +
+	"-v /etc/kubernetes:/etc/kubernetes -v /var/run:/var/run rancher/rancher-agent:v2.4.5 " +
+	"--server https://rancher.example.com --token registration-token --ca-checksum abc123"
+
+// newFakeRancher returns a Rancher server that has one cluster registration
+// token for cluster c-abcde
+func newFakeRancher(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer rancher-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodGet || r.URL.Path != "/v3/clusterregistrationtokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resp := rancher.ClusterRegistrationTokenResponse{Type: "collection"}
+		if r.URL.Query().Get("clusterId") == "c-abcde" {
+			resp.Data = append(resp.Data, rancher.ClusterRegistrationTokenData{
+				ID:          "c-abcde:default-token",
+				ClusterID:   "c-abcde",
+				Token:       "registration-token",
+				NodeCommand: testNodeCommand,
+			})
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Errorf("failed to write response: %v", err)
+		}
+	}))
+}
+
+func TestParseNodeCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want nodeCommand
+	}{
+		{"full", testNodeCommand, nodeCommand{
+			image:      "rancher/rancher-agent:v2.4.5",
+			server:     "https://rancher.example.com",
+			caChecksum: "abc123",
+		}},
+		{"trusted certificate", "sudo docker run -d rancher/rancher-agent:v2.4.5 --server https://rancher --token t", nodeCommand{
+			image:  "rancher/rancher-agent:v2.4.5",
+			server: "https://rancher",
+		}},
+		{"empty", "", nodeCommand{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseNodeCommand(tt.cmd); got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRancherDeviceConfig(t *testing.T) {
+	srv := newFakeRancher(t)
+	defer srv.Close()
+
+	rc, err := rancher.NewClient(srv.URL, "rancher-key")
+	if err != nil {
+		t.Fatalf("failed to create rancher client: %v", err)
+	}
+
+	registrations := clustertoken.NewRancher(rc, "c-abcde", time.Minute)
+	s := &Server{cluster: &rancherBackend{
+		tokens:        registrations,
+		registrations: registrations,
+	}}
+
+	conf, err := s.deviceConfig(context.Background(), &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: "device-id"}})
+	if err != nil {
+		t.Fatalf("failed to get device config: %v", err)
+	}
+
+	r := conf.GetRancher()
+	if r == nil || r.ServerUrl != "https://rancher.example.com" || r.Token != "registration-token" ||
+		r.CaChecksum != "abc123" || r.AgentImage != "rancher/rancher-agent:v2.4.5" {
+		t.Fatalf("expected the rancher config of the cluster, got %v", r)
+	}
+
+	if !reflect.DeepEqual(r.Roles, defaultRancherRoles) {
+		t.Errorf("expected the default roles, got %v", r.Roles)
+	}
+
+	conf, err = s.deviceConfig(context.Background(), &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id"},
+		Spec:       registrar.DeviceSpec{RancherRoles: []string{"etcd", "controlplane"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to get device config: %v", err)
+	}
+
+	if !reflect.DeepEqual(conf.GetRancher().Roles, []string{"etcd", "controlplane"}) {
+		t.Errorf("expected the roles of the device, got %v", conf.GetRancher().Roles)
+	}
+
+	_, err = s.deviceConfig(context.Background(), &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id"},
+		Spec:       registrar.DeviceSpec{RancherRoles: []string{"master"}},
+	})
+	if err == nil {
+		t.Error("expected an unknown role to fail")
+	}
+}
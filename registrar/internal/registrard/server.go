@@ -6,22 +6,54 @@ package registrard
 
 import (
 	"context"
-	"crypto/subtle"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jaredallard-home/worker-nodes/registrar/api"
 	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
 	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
 	"github.com/jaredallard-home/worker-nodes/registrar/internal/kube"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/storage"
 	"github.com/jaredallard-home/worker-nodes/registrar/pkg/rancher"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 )
 
+// namespace is the namespace registrar objects are stored in
+const namespace = "registrar"
+
+// defaultTunnelCIDR is the default network devices are assigned addresses from
+const defaultTunnelCIDR = "10.10.0.0/24"
+
+// errRegisterConflict is returned when another request changed the device
+// being registered at the same time, possibly on another replica, or the
+// cached device was out of date
+var errRegisterConflict = status.Error(codes.Aborted, "device was changed by another request at the same time")
+
+// isRegisterConflict returns true if err was caused by another request
+// creating, or updating, a device first
+func isRegisterConflict(err error) bool {
+	cause := errors.Cause(err)
+	return kerrors.IsAlreadyExists(cause) || kerrors.IsConflict(cause)
+}
+
 // Ensure that we implemented the interface compile time
 var (
 	_ api.Service = &Server{}
@@ -29,97 +61,354 @@ var (
 
 // Server is the actual server implementation of the API.
 type Server struct {
-	k            *v1alpha1.RegistrarClientset
-	r            *rancher.Client
-	authToken    []byte
-	authTokenlen int32
+	// k is the kubernetes clientset, it's nil when running standalone
+	// without a cluster
+	k kubernetes.Interface
+
+	// store is where devices and join tokens are stored, backend stores
+	// everything else
+	store   v1alpha1.RegistrarV1Alpha1Interface
+	backend storage.Backend
+
+	r          *rancher.Client
+	ipam       *ipam.IPAM
+	ca         *pki.CA
+	wg         wireguardHub
+	wgEndpoint string
+
+	// cluster is how devices join the cluster, chosen with JOIN_MODE
+	cluster ClusterBackend
+
+	// rancherClusterID is the Rancher cluster the nodes of deleted devices
+	// are removed from, all clusters when it's empty
+	rancherClusterID string
+
+	// requireCertificates denotes if devices have to authenticate with
+	// their client certificate, instead of their secret, for every request
+	// but Register. It's set when TLS is enabled.
+	requireCertificates bool
+
+	// requireApproval denotes if new devices have to be approved by an
+	// operator before they get access to the cluster
+	requireApproval bool
+
+	// devices and nodes are caches of devices and nodes, kept up to date
+	// by informers
+	devices v1alpha1.DeviceLister
+	nodes   cache.Indexer
+
+	// nodeQueue is the queue of devices whose node changed
+	nodeQueue workqueue.RateLimitingInterface
+
+	// removing is the set of nodes of decommissioned devices that are being
+	// drained and deleted, guarded by removingLock
+	removingLock sync.Mutex
+	removing     map[string]bool
+
+	// recorder records events about devices
+	recorder record.EventRecorder
+
+	// gcTTL is how long a device can be stuck registering, or not be seen,
+	// before it's collected. Garbage collection is disabled when it's 0,
+	// which it is unless DEVICE_GC_TTL is set.
+	gcTTL time.Duration
+
+	// gcDryRun only reports what would be collected
+	gcDryRun bool
+
+	// adminTokenHash is the hash of the token the admin api is
+	// authenticated with. The admin api is disabled when it's empty.
+	adminTokenHash string
 }
 
-// NewServer creates a new grpc server interface
+// NewServer creates a new grpc server interface. STORAGE_BACKEND selects
+// where it's state is stored, either as custom resources in the cluster
+// (kubernetes, the default) or in a local file (file) when there's no
+// cluster yet.
 func NewServer(ctx context.Context) (*Server, error) {
-	s := &Server{}
-	c, err := kube.New()
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "kubernetes":
+		c, err := kube.New()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create kube config")
+		}
+
+		k, err := v1alpha1.NewForConfig(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create kubernetes and registrar clientset")
+		}
+
+		return newServer(ctx, k, storage.NewKubernetes(k, namespace), newWireguardDevice())
+	case "file":
+		path := os.Getenv("STORAGE_PATH")
+		if path == "" {
+			path = storage.DefaultFilePath
+		}
+
+		f, err := storage.OpenFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open storage")
+		}
+
+		log.Infof("running standalone, storing state in '%s'", path)
+		s, err := newServer(ctx, nil, f, newWireguardDevice())
+		if err != nil {
+			f.Close()
+		}
+		return s, err
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s'", backend)
+	}
+}
+
+// newServer creates a server that stores it's state in backend, and peers
+// devices with hub. k is used to manage the nodes of devices, it's nil when
+// running standalone.
+func newServer(ctx context.Context, k kubernetes.Interface, backend storage.Backend, hub wireguardHub) (*Server, error) {
+	s := &Server{k: k, store: backend.Registrar(), backend: backend, wg: hub, removing: make(map[string]bool)}
+
+	var err error
+	if host := os.Getenv("RANCHER_HOST"); host != "" {
+		s.r, err = newRancherClient(ctx, host, os.Getenv("RANCHER_TOKEN"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.startCaches(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to start caches")
+	}
+
+	cidr := os.Getenv("WIREGUARD_CIDR")
+	if cidr == "" {
+		cidr = defaultTunnelCIDR
+	}
+
+	s.ipam, err = ipam.New(cidr, backend.IPAMStore())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create ipam")
+	}
+
+	if err := s.syncLeases(ctx, namespace); err != nil {
+		return nil, errors.Wrap(err, "failed to sync ip leases")
+	}
+
+	s.ca, err = backend.LoadOrCreateCA(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create kube config")
+		return nil, errors.Wrap(err, "failed to load ca")
 	}
 
-	s.r = rancher.NewClient(os.Getenv("RANCHER_HOST"), os.Getenv("RANCHER_TOKEN"))
+	s.wgEndpoint = os.Getenv("WIREGUARD_HOST")
 
-	s.k, err = v1alpha1.NewForConfig(c)
+	s.cluster, err = s.newClusterBackend()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create kubernetes and registrar clientset")
+		return nil, err
+	}
+
+	s.rancherClusterID = os.Getenv("RANCHER_CLUSTER_ID")
+
+	s.requireCertificates = os.Getenv("REGISTRARD_ENABLE_TLS") != ""
+	s.requireApproval = os.Getenv("REQUIRE_APPROVAL") == "true"
+
+	// garbage collection releases the peers of devices that don't report
+	// their status, which older agents and STATUS_INTERVAL=0 don't, so it's
+	// only enabled by setting a TTL
+	if ttl := os.Getenv("DEVICE_GC_TTL"); ttl != "" {
+		s.gcTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse DEVICE_GC_TTL")
+		}
+	}
+	s.gcDryRun = os.Getenv("DEVICE_GC_DRY_RUN") == "true"
+
+	if token := os.Getenv("REGISTRARD_ADMIN_TOKEN"); token != "" {
+		s.adminTokenHash = jointoken.HashSecret(token)
+	} else {
+		log.Info("REGISTRARD_ADMIN_TOKEN isn't set, the admin api is disabled")
 	}
 
-	s.authToken = []byte(os.Getenv("REGISTRARD_TOKEN"))
-	s.authTokenlen = int32(len(s.authToken))
+	// without a cluster there's nowhere to record events, so they're
+	// only logged
+	broadcaster := record.NewBroadcaster()
+	if s.k != nil {
+		broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: s.k.CoreV1().Events(namespace)})
+	} else {
+		broadcaster.StartLogging(log.Debugf)
+	}
+	s.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "registrard"})
+
 	return s, err
 }
 
-func (s *Server) createDevice(ctx context.Context, namespace string, r *api.RegisterRequest) error {
+// Close releases the storage backend of the server
+func (s *Server) Close() error {
+	return s.backend.Close()
+}
+
+// createDevice creates a new device, returning it and the secret it should
+// use to authenticate from now on
+func (s *Server) createDevice(ctx context.Context, namespace string, r *api.RegisterRequest, jt *registrar.JoinToken) (*registrar.Device, string, error) {
+	used, err := s.useJoinToken(ctx, jt.Name, r.Id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	secret, err := generateDeviceSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
 	// device doesn't exist, create it
-	_, err := s.k.RegistrarV1Alpha1Client().Devices(namespace).Create(ctx, &registrar.Device{
+	d := &registrar.Device{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: r.Id,
+			Name:   r.Id,
+			Labels: joinTokenLabels(jt),
 		},
 		Spec: registrar.DeviceSpec{},
-		Status: registrar.DeviceStatus{
-			Registered: true,
-		},
-	}, metav1.CreateOptions{})
+	}
+
+	d, err = s.store.Devices(namespace).Create(ctx, d, metav1.CreateOptions{})
 	if err != nil {
-		return errors.Wrap(err, "failed to create device")
+		if used && !s.createdWithJoinToken(ctx, namespace, r.Id, jt.Name) {
+			if err := s.releaseJoinToken(ctx, jt.Name, r.Id); err != nil {
+				log.WithError(err).Warnf("failed to release join token '%s'", jt.Name)
+			}
+		}
+		return nil, "", errors.Wrap(err, "failed to create device")
+	}
+
+	// the status can't be set on create. If another request saw the device
+	// without a credential and issued one first, this conflicts.
+	d.Status.CredentialHash = jointoken.HashSecret(secret)
+	if err := s.updateStatus(ctx, d); err != nil {
+		return nil, "", errors.Wrap(err, "failed to save device credential")
 	}
 
-	return nil
+	return d, secret, nil
+}
+
+// createdWithJoinToken returns true if a device exists and was created
+// with a join token. When creating a device fails it's use of the token is
+// only kept if another request created the device with the same token.
+func (s *Server) createdWithJoinToken(ctx context.Context, namespace, id, token string) bool {
+	d, err := s.store.Devices(namespace).Get(ctx, id, metav1.GetOptions{})
+	return err == nil && d.Labels[joinTokenLabel] == token
 }
 
-// Register registers a new device into the wireguard network.
-// TODO(jaredallard): GC when peer is not added fully
+// Register registers a new device into the wireguard network. New devices
+// authenticate with a join token and are issued a device secret, which they
+// have to use for every request after that. Devices that never finish
+// registering are cleaned up by the garbage collector.
 func (s *Server) Register(ctx context.Context, r *api.RegisterRequest) (*api.RegisterResponse, error) {
-	namespace := "registrar"
-	userTokenByte := []byte(r.AuthToken)
+	resp, err := s.register(ctx, r)
+	if isRegisterConflict(err) {
+		return nil, errRegisterConflict
+	}
+
+	return resp, err
+}
 
-	// we need to check if the auth token is the correct length
-	if subtle.ConstantTimeEq(s.authTokenlen, int32(len(userTokenByte))) == 0 {
-		return nil, fmt.Errorf("invalid auth token")
+// register implements Register. Devices are read from the cache, or before
+// they're changed, so a write conflicts if another request changed the
+// device first.
+func (s *Server) register(ctx context.Context, r *api.RegisterRequest) (*api.RegisterResponse, error) {
+	if r.WireguardPublicKey == "" {
+		return nil, fmt.Errorf("missing wireguard public key")
 	}
 
-	// we need to check if the token is actually valid
-	if subtle.ConstantTimeCompare(s.authToken, userTokenByte) == 0 {
-		return nil, fmt.Errorf("invalid auth token")
+	d, err := s.authenticateDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &api.RegisterResponse{}
+	if d != nil {
+		if r.Id != "" && r.Id != d.Name {
+			return nil, errInvalidDeviceCredentials
+		}
+
+		log.Infof("device '%s' authenticated, returning registration information ...", d.Name)
+	} else {
+		// not a known device, so a join token is required
+		jt, err := s.authenticateJoinToken(ctx, r.AuthToken)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Id == "" {
+			// generate a new UUID for this device
+			r.Id = uuid.New().String()
+		}
+
+		log.Infof("attempting to register device '%s'", r.Id)
+
+		d, err = s.store.Devices(namespace).Get(ctx, r.Id, metav1.GetOptions{})
+		if err == nil {
+			if d.Status.CredentialHash != "" {
+				// prevent anyone with a join token from taking over a device
+				return nil, fmt.Errorf("device '%s' is already registered, device credentials are required", r.Id)
+			}
+
+			if d.Status.Decommissioned {
+				log.Infof("device '%s' was decommissioned, registering it again ...", r.Id)
+				if _, err := s.useJoinToken(ctx, jt.Name, r.Id); err != nil {
+					return nil, err
+				}
+
+				d.Status.Decommissioned = false
+			} else {
+				log.Infof("device '%s' was registered without a device secret, issuing one ...", r.Id)
+			}
+
+			if resp.DeviceSecret, err = s.issueDeviceSecret(ctx, d); err != nil {
+				return nil, errors.Wrap(err, "failed to issue device secret")
+			}
+		} else if kerrors.IsNotFound(err) {
+			log.Infof("device '%s' is new, registering ...", r.Id)
+			if d, resp.DeviceSecret, err = s.createDevice(ctx, namespace, r, jt); err != nil {
+				return nil, errors.Wrap(err, "failed to register device")
+			}
+		} else {
+			// we checked all errors we handle, just return it
+			return nil, err
+		}
 	}
 
-	if r.Id == "" {
-		// generate a new UUID for this device
-		r.Id = uuid.New().String()
+	pending, err := s.checkApproval(ctx, d)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Infof("attempting to register device '%s'", r.Id)
-	resp := &api.RegisterResponse{
-		Id: r.Id,
+	if err := s.saveInventory(ctx, d, r.Inventory); err != nil {
+		return nil, err
 	}
 
-	_, err := s.k.RegistrarV1Alpha1Client().Devices(namespace).Get(ctx, r.Id, metav1.GetOptions{})
-	if err == nil {
-		log.Infof("device '%s' already exists, returning registration information ...", r.Id)
-	} else if kerrors.IsNotFound(err) {
-		log.Infof("device '%s' is new, registering ...", r.Id)
-		if err := s.createDevice(ctx, namespace, r); err != nil {
-			return nil, errors.Wrap(err, "failed to register device")
+	if len(r.Csr) != 0 {
+		resp.Certificate, err = s.issueCertificate(ctx, d, r.Csr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to issue certificate")
 		}
-	} else if err != nil {
-		// we checked all errors we handle, just return it
-		return nil, err
+	}
+	resp.CaCertificate = s.ca.CertificatePEM()
+	resp.Id = d.Name
+
+	if pending {
+		log.Infof("device '%s' is pending approval", d.Name)
+		resp.PendingApproval = true
+		return resp, nil
 	}
 
-	d, err := s.k.RegistrarV1Alpha1Client().Devices(namespace).Get(ctx, r.Id, metav1.GetOptions{})
+	resp.Wireguard, err = s.ensurePeer(ctx, namespace, d, r.WireguardPublicKey)
 	if err != nil {
-		return nil, errors.New("failed to get device")
+		return nil, errors.Wrap(err, "failed to add wireguard peer")
 	}
 
-	resp.Id = string(d.ObjectMeta.UID)
-	resp.ClusterToken = os.Getenv("CLUSTER_TOKEN")
-	resp.ClusterHost = os.Getenv("CLUSTER_HOST")
+	resp.Config, err = s.deviceConfig(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	resp.ClusterToken = resp.Config.ClusterToken
+	resp.ClusterHost = resp.Config.ClusterHost
 
 	return resp, nil
 }
@@ -0,0 +1,366 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1/fake"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestIsRegisterConflict(t *testing.T) {
+	gr := schema.GroupResource{Group: "registrar.jaredallard.me", Resource: "devices"}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"already exists", errors.Wrap(kerrors.NewAlreadyExists(gr, "device-id"), "failed to create device"), true},
+		{"conflict", errors.Wrap(kerrors.NewConflict(gr, "device-id", fmt.Errorf("modified")), "failed to save device credential"), true},
+		{"other error", errors.Wrap(kerrors.NewNotFound(gr, "device-id"), "failed to get device"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRegisterConflict(tt.err); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRegisterNewDevice(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{Profile: "edge"})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+
+	pub := publicKey(t)
+	resp, err := h.register(context.Background(), &api.RegisterRequest{AuthToken: token, WireguardPublicKey: pub}, nil)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+
+	if resp.Id == "" || resp.DeviceSecret == "" {
+		t.Fatalf("expected an id and device secret, got %+v", resp)
+	}
+
+	d := h.device(t, resp.Id)
+	if !verifyDeviceSecret(d, resp.DeviceSecret) {
+		t.Error("expected the device secret to be stored")
+	}
+
+	if d.Labels[joinTokenLabel] != jt.Name || d.Labels[profileLabel] != "edge" {
+		t.Errorf("expected join token labels, got %v", d.Labels)
+	}
+
+	if d.Status.WireGuard == nil || resp.Wireguard.Address != d.Status.WireGuard.IPAddress+"/24" {
+		t.Fatalf("expected the tunnel address to be stored, got %v and %v", resp.Wireguard, d.Status.WireGuard)
+	}
+
+	peers, _ := h.hub.Peers(context.Background())
+	key, _ := wireguard.ParseKey(pub)
+	if p, ok := peers[key]; !ok || len(p.AllowedIPs) != 1 || p.AllowedIPs[0] != d.Status.WireGuard.IPAddress+"/32" {
+		t.Errorf("expected the device to be a peer of the hub, got %v", peers)
+	}
+
+	used, err := h.k.RegistrarV1Alpha1Client().JoinTokens(namespace).Get(context.Background(), jt.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get join token: %v", err)
+	}
+
+	if used.Status.Uses != 1 || len(used.Status.Devices) != 1 || used.Status.Devices[0] != resp.Id {
+		t.Errorf("expected the join token to be used once by the device, got %+v", used.Status)
+	}
+}
+
+func TestRegisterStandalone(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+	h := newStandaloneHarness(t, jt)
+	defer h.Close()
+
+	resp, err := h.register(context.Background(), &api.RegisterRequest{AuthToken: token, WireguardPublicKey: publicKey(t)}, nil)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+
+	d := h.device(t, resp.Id)
+	if !verifyDeviceSecret(d, resp.DeviceSecret) || d.Status.WireGuard == nil {
+		t.Errorf("expected the device to be stored in the file backend, got %+v", d.Status)
+	}
+
+	// registering again uses the device credentials stored in the file
+	_, err = h.register(context.Background(), &api.RegisterRequest{Id: resp.Id, WireguardPublicKey: publicKey(t)},
+		&api.DeviceCredentials{ID: resp.Id, Secret: resp.DeviceSecret})
+	if err != nil {
+		t.Errorf("failed to register again: %v", err)
+	}
+}
+
+func TestRegisterAuthFailure(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+
+	expired := metav1.NewTime(time.Now().Add(-time.Hour))
+	expiredJT, expiredToken := joinToken(t, registrar.JoinTokenSpec{Expires: &expired})
+
+	usedJT, usedToken := joinToken(t, registrar.JoinTokenSpec{MaxUses: 1})
+	usedJT.Status = registrar.JoinTokenStatus{Uses: 1, Devices: []string{"other-device"}}
+
+	registered := &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "registered"},
+		Status:     registrar.DeviceStatus{CredentialHash: jointoken.HashSecret("secret")},
+	}
+
+	h := newTestHarness(t, jt, expiredJT, usedJT, registered)
+	defer h.Close()
+
+	tests := []struct {
+		name    string
+		id      string
+		token   string
+		creds   *api.DeviceCredentials
+		wantErr error
+	}{
+		{"no join token", "", "", nil, errInvalidAuthToken},
+		{"malformed join token", "", "not-a-token", nil, errInvalidAuthToken},
+		{"unknown join token", "", "unknown.secret", nil, errInvalidAuthToken},
+		{"wrong join token secret", "", jt.Name + ".wrong", nil, errInvalidAuthToken},
+		{"expired join token", "", expiredToken, nil, errExpiredAuthToken},
+		{"used join token", "", usedToken, nil, errUsedAuthToken},
+		{"wrong device secret", "", "", &api.DeviceCredentials{ID: "registered", Secret: "wrong"}, errInvalidDeviceCredentials},
+		{"unknown device", "", "", &api.DeviceCredentials{ID: "unknown", Secret: "secret"}, errInvalidDeviceCredentials},
+		{"credentials of another device", "other", "", &api.DeviceCredentials{ID: "registered", Secret: "secret"}, errInvalidDeviceCredentials},
+		{"taking over a registered device", "registered", token, nil, fmt.Errorf("device 'registered' is already registered, device credentials are required")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := h.register(context.Background(), &api.RegisterRequest{
+				Id:                 tt.id,
+				AuthToken:          tt.token,
+				WireguardPublicKey: publicKey(t),
+			}, tt.creds)
+			if err == nil {
+				t.Fatal("expected registering to fail")
+			}
+
+			if got := status.Convert(err).Message(); !strings.Contains(got, tt.wantErr.Error()) {
+				t.Errorf("expected error '%v', got '%v'", tt.wantErr, got)
+			}
+		})
+	}
+
+	if peers, _ := h.hub.Peers(context.Background()); len(peers) != 0 {
+		t.Errorf("expected no peers to be added, got %v", peers)
+	}
+}
+
+func TestRegisterAgain(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{MaxUses: 1})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+
+	ctx := context.Background()
+	pub := publicKey(t)
+	first, err := h.register(ctx, &api.RegisterRequest{AuthToken: token, WireguardPublicKey: pub}, nil)
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	creds := &api.DeviceCredentials{ID: first.Id, Secret: first.DeviceSecret}
+
+	again, err := h.register(ctx, &api.RegisterRequest{WireguardPublicKey: pub}, creds)
+	if err != nil {
+		t.Fatalf("failed to register again: %v", err)
+	}
+
+	if again.Id != first.Id || again.DeviceSecret != "" || again.Wireguard.Address != first.Wireguard.Address {
+		t.Errorf("expected the same registration without a new secret, got %+v", again)
+	}
+
+	if peers, _ := h.hub.Peers(ctx); len(peers) != 1 {
+		t.Errorf("expected one peer, got %v", peers)
+	}
+
+	// a re-imaged device keeps it's address, but not it's old key
+	newPub := publicKey(t)
+	reimaged, err := h.register(ctx, &api.RegisterRequest{WireguardPublicKey: newPub}, creds)
+	if err != nil {
+		t.Fatalf("failed to register with a new key: %v", err)
+	}
+
+	if reimaged.Wireguard.Address != first.Wireguard.Address {
+		t.Errorf("expected address %s, got %s", first.Wireguard.Address, reimaged.Wireguard.Address)
+	}
+
+	peers, _ := h.hub.Peers(ctx)
+	key, _ := wireguard.ParseKey(newPub)
+	if _, ok := peers[key]; !ok || len(peers) != 1 {
+		t.Errorf("expected only the new key to be a peer, got %v", peers)
+	}
+}
+
+func TestRegisterConcurrently(t *testing.T) {
+	const devices = 20
+
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+
+	var wg sync.WaitGroup
+	resps := make([]*api.RegisterResponse, devices)
+	errs := make([]error, devices)
+	for i := 0; i < devices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i], errs[i] = h.register(context.Background(), &api.RegisterRequest{
+				AuthToken:          token,
+				WireguardPublicKey: publicKey(t),
+			}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	addresses := make(map[string]bool)
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("failed to register device %d: %v", i, err)
+		}
+
+		if addresses[resps[i].Wireguard.Address] {
+			t.Errorf("address %s was assigned twice", resps[i].Wireguard.Address)
+		}
+		addresses[resps[i].Wireguard.Address] = true
+	}
+
+	if peers, _ := h.hub.Peers(context.Background()); len(peers) != devices {
+		t.Errorf("expected %d peers, got %d", devices, len(peers))
+	}
+
+	used, err := h.k.RegistrarV1Alpha1Client().JoinTokens(namespace).Get(context.Background(), jt.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get join token: %v", err)
+	}
+
+	if used.Status.Uses != devices {
+		t.Errorf("expected the join token to be used %d times, got %d", devices, used.Status.Uses)
+	}
+}
+
+func TestRegisterSameIDConcurrently(t *testing.T) {
+	const requests = 5
+
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+
+	var wg sync.WaitGroup
+	resps := make([]*api.RegisterResponse, requests)
+	errs := make([]error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resps[i], errs[i] = h.register(context.Background(), &api.RegisterRequest{
+				Id:                 "device-id",
+				AuthToken:          token,
+				WireguardPublicKey: publicKey(t),
+			}, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	var secret string
+	for i, err := range errs {
+		if err != nil {
+			// losing the race either conflicts, or finds the device
+			// registered by the winner
+			if status.Code(err) != codes.Aborted && !strings.Contains(err.Error(), "already registered") {
+				t.Errorf("unexpected error: %v", err)
+			}
+			continue
+		}
+
+		if secret != "" {
+			t.Fatal("expected only one request to be issued a device secret")
+		}
+		secret = resps[i].DeviceSecret
+	}
+
+	if secret == "" {
+		t.Fatal("expected one request to succeed")
+	}
+
+	if d := h.device(t, "device-id"); !verifyDeviceSecret(d, secret) {
+		t.Error("expected the secret of the successful request to be stored")
+	}
+}
+
+func TestRegisterConflict(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+
+	// another replica updates the device first
+	h.k.RegistrarV1Alpha1Client().(*fake.FakeRegistrarV1Alpha1).PrependReactor("update", "devices", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewConflict(action.GetResource().GroupResource(), "device-id", fmt.Errorf("modified"))
+	})
+
+	_, err := h.register(context.Background(), &api.RegisterRequest{
+		Id:                 "device-id",
+		AuthToken:          token,
+		WireguardPublicKey: publicKey(t),
+	}, nil)
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("expected the conflict to abort registering, got %v", err)
+	}
+}
+
+func TestRegisterCreateFailureReleasesJoinToken(t *testing.T) {
+	jt, token := joinToken(t, registrar.JoinTokenSpec{MaxUses: 1})
+	h := newTestHarness(t, jt)
+	defer h.Close()
+
+	failed := false
+	h.k.RegistrarV1Alpha1Client().(*fake.FakeRegistrarV1Alpha1).PrependReactor("create", "devices", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failed {
+			return false, nil, nil
+		}
+		failed = true
+		return true, nil, fmt.Errorf("etcd is down")
+	})
+
+	ctx := context.Background()
+	if _, err := h.register(ctx, &api.RegisterRequest{AuthToken: token, WireguardPublicKey: publicKey(t)}, nil); err == nil {
+		t.Fatal("expected registering to fail")
+	}
+
+	used, err := h.k.RegistrarV1Alpha1Client().JoinTokens(namespace).Get(ctx, jt.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get join token: %v", err)
+	}
+
+	if used.Status.Uses != 0 || len(used.Status.Devices) != 0 {
+		t.Errorf("expected the use of the join token to be released, got %+v", used.Status)
+	}
+
+	if _, err := h.register(ctx, &api.RegisterRequest{AuthToken: token, WireguardPublicKey: publicKey(t)}, nil); err != nil {
+		t.Errorf("expected the join token to still be usable: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package registrard
+
+import (
+	"context"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// errDeviceStale is returned to devices whose tunnel address and peer were
+// released by the garbage collector, they have to register again
+var errDeviceStale = status.Error(codes.FailedPrecondition, "device is stale, it has to register again")
+
+// ReportStatus records the status a device reports, and when it was last seen
+func (s *Server) ReportStatus(ctx context.Context, r *api.ReportStatusRequest) (*api.ReportStatusResponse, error) {
+	d, err := s.requireDevice(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Status.Stale {
+		return nil, errDeviceStale
+	}
+
+	now := time.Now()
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		d, err := s.store.Devices(namespace).Get(ctx, d.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		applyStatusReport(d, r, now)
+		return s.updateStatus(ctx, d)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save device status")
+	}
+
+	return &api.ReportStatusResponse{}, nil
+}
+
+// applyStatusReport updates the status of a device from a report it sent at now
+func applyStatusReport(d *registrar.Device, r *api.ReportStatusRequest, now time.Time) {
+	lastSeen := metav1.NewTime(now)
+	d.Status.LastSeen = &lastSeen
+
+	agent := &registrar.AgentStatus{
+		AgentVersion:  r.AgentVersion,
+		K3sVersion:    r.K3SVersion,
+		UptimeSeconds: r.UptimeSeconds,
+		LastError:     r.LastError,
+	}
+
+	if r.WireguardHandshakeAgeSeconds >= 0 {
+		handshake := metav1.NewTime(now.Add(-time.Duration(r.WireguardHandshakeAgeSeconds) * time.Second))
+		agent.WireGuardLastHandshake = &handshake
+	}
+
+	d.Status.Agent = agent
+}
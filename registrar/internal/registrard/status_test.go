@@ -0,0 +1,40 @@
+package registrard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+)
+
+func TestApplyStatusReport(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	d := &registrar.Device{}
+
+	applyStatusReport(d, &api.ReportStatusRequest{
+		AgentVersion:                 "v1.0.0",
+		K3SVersion:                   "v1.18.8+k3s1",
+		UptimeSeconds:                3600,
+		WireguardHandshakeAgeSeconds: 30,
+	}, now)
+
+	if d.Status.LastSeen == nil || !d.Status.LastSeen.Time.Equal(now) {
+		t.Errorf("expected lastSeen to be %v, got %v", now, d.Status.LastSeen)
+	}
+
+	if d.Status.Agent.K3sVersion != "v1.18.8+k3s1" || d.Status.Agent.UptimeSeconds != 3600 {
+		t.Errorf("unexpected agent status %+v", d.Status.Agent)
+	}
+
+	handshake := d.Status.Agent.WireGuardLastHandshake
+	if handshake == nil || !handshake.Time.Equal(now.Add(-30*time.Second)) {
+		t.Errorf("unexpected wireguard handshake %v", handshake)
+	}
+
+	// no handshake yet
+	applyStatusReport(d, &api.ReportStatusRequest{WireguardHandshakeAgeSeconds: -1}, now)
+	if d.Status.Agent.WireGuardLastHandshake != nil {
+		t.Errorf("expected no wireguard handshake, got %v", d.Status.Agent.WireGuardLastHandshake)
+	}
+}
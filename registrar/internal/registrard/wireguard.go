@@ -0,0 +1,257 @@
+package registrard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// persistentKeepalive is the keepalive interval, in seconds, devices
+// should use for the hub. Most devices are behind a NAT.
+const persistentKeepalive = 25
+
+// wireguardHub is the WireGuard interface devices are peered with
+type wireguardHub interface {
+	PublicKey(ctx context.Context) (wireguard.Key, error)
+	AddPeer(ctx context.Context, p *wireguard.Peer) error
+	RemovePeer(ctx context.Context, pub wireguard.Key) error
+	Peers(ctx context.Context) (map[wireguard.Key]wireguard.Peer, error)
+}
+
+// newWireguardDevice returns the hub WireGuard interface
+func newWireguardDevice() *wireguard.Device {
+	name := os.Getenv("WIREGUARD_INTERFACE")
+	if name == "" {
+		name = wireguard.DefaultInterface
+	}
+
+	return wireguard.NewDevice(name)
+}
+
+// syncLeases makes sure that the address of every device is leased to it,
+// adopting addresses that were assigned before IPAM existed and reporting
+// devices that claim the same address, or an address that's no longer in
+// the network. Those devices are skipped, they get a new address when they
+// register again.
+//
+// The registrard-ipam ConfigMap is the source of truth for leases, since a
+// single object lets replicas allocate with optimistic concurrency, and
+// WireGuardStatus.IPAddress of a device mirrors it's lease.
+func (s *Server) syncLeases(ctx context.Context, namespace string) error {
+	devices, err := s.cachedDevices()
+	if err != nil {
+		return err
+	}
+
+	for i := range devices {
+		d := &devices[i]
+		if d.Status.WireGuard == nil || d.Status.WireGuard.IPAddress == "" {
+			continue
+		}
+
+		err := s.ipam.Reserve(ctx, d.Name, net.ParseIP(d.Status.WireGuard.IPAddress))
+		var cerr *ipam.ConflictError
+		if errors.As(err, &cerr) {
+			log.Errorf("device '%s' claims address %s, but it is leased to '%s'", d.Name, cerr.IP, cerr.Owner)
+		} else if errors.Is(err, ipam.ErrInvalidAddress) {
+			log.WithError(err).Errorf("device '%s' claims an address that can't be leased", d.Name)
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to reserve address of device '%s'", d.Name)
+		}
+	}
+
+	return nil
+}
+
+// leaseIP returns the tunnel address of a device, leasing a new one if
+// it doesn't have one yet
+func (s *Server) leaseIP(ctx context.Context, d *registrar.Device) (net.IP, error) {
+	if d.Status.WireGuard != nil && d.Status.WireGuard.IPAddress != "" {
+		ip := net.ParseIP(d.Status.WireGuard.IPAddress)
+		err := s.ipam.Reserve(ctx, d.Name, ip)
+		if !errors.Is(err, ipam.ErrInvalidAddress) {
+			return ip, err
+		}
+
+		// the network changed since the device registered
+		log.WithError(err).Warnf("device '%s' can't keep it's address, leasing a new one", d.Name)
+	}
+
+	return s.ipam.Allocate(ctx, d.Name)
+}
+
+// ensurePeer ensures that a device has a tunnel address and is a peer of the hub
+func (s *Server) ensurePeer(ctx context.Context, namespace string, d *registrar.Device, publicKey string) (*api.WireguardConfig, error) {
+	pub, err := wireguard.ParseKey(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid wireguard public key")
+	}
+
+	ip, err := s.leaseIP(ctx, d)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to lease tunnel address")
+	}
+
+	if wg := d.Status.WireGuard; wg != nil && wg.PublicKey != "" && wg.PublicKey != pub.String() {
+		// the device was re-imaged, so drop the old key
+		log.Infof("device '%s' changed it's public key, removing old peer", d.Name)
+		if oldPub, err := wireguard.ParseKey(wg.PublicKey); err == nil {
+			if err := s.wg.RemovePeer(ctx, oldPub); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	wgStatus := &registrar.WireGuardStatus{PublicKey: pub.String(), IPAddress: ip.String()}
+	if d.Status.WireGuard == nil || *d.Status.WireGuard != *wgStatus || d.Status.Stale {
+		d.Status.WireGuard = wgStatus
+		d.Status.Stale = false
+		if err := s.updateStatus(ctx, d); err != nil {
+			return nil, errors.Wrap(err, "failed to save device wireguard configuration")
+		}
+	}
+
+	if err := s.wg.AddPeer(ctx, &wireguard.Peer{
+		PublicKey:  pub,
+		AllowedIPs: []string{d.Status.WireGuard.IPAddress + "/32"},
+	}); err != nil {
+		return nil, err
+	}
+
+	hubPub, err := s.wg.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	network := s.ipam.Network()
+	ones, _ := network.Mask.Size()
+
+	return &api.WireguardConfig{
+		Address: fmt.Sprintf("%s/%d", d.Status.WireGuard.IPAddress, ones),
+		Peers: []*api.WireguardPeer{
+			{
+				PublicKey:           hubPub.String(),
+				Endpoint:            s.wgEndpoint,
+				AllowedIps:          []string{network.String()},
+				PersistentKeepalive: persistentKeepalive,
+			},
+		},
+	}, nil
+}
+
+// desiredPeers returns the allowed IP of every device that should be a peer
+// of the hub, keyed by it's public key
+func desiredPeers(devices []registrar.Device) map[wireguard.Key]string {
+	peers := make(map[wireguard.Key]string)
+	for i := range devices {
+		wg := devices[i].Status.WireGuard
+		if wg == nil || wg.PublicKey == "" || wg.IPAddress == "" {
+			continue
+		}
+
+		pub, err := wireguard.ParseKey(wg.PublicKey)
+		if err != nil {
+			log.WithError(err).Warnf("device '%s' has an invalid public key", devices[i].Name)
+			continue
+		}
+
+		peers[pub] = wg.IPAddress + "/32"
+	}
+
+	return peers
+}
+
+// isTunnelPeer returns true if a peer only routes addresses of the tunnel
+// network, so it was added for a device
+func isTunnelPeer(network *net.IPNet, p *wireguard.Peer) bool {
+	if len(p.AllowedIPs) == 0 {
+		return false
+	}
+
+	for _, cidr := range p.AllowedIPs {
+		ip, n, err := net.ParseCIDR(cidr)
+		if err != nil || !network.Contains(ip) {
+			return false
+		}
+
+		if ones, bits := n.Mask.Size(); ones != bits {
+			return false
+		}
+	}
+
+	return true
+}
+
+// syncPeers makes the peers of the hub match the devices. Every replica of
+// registrard runs it's own hub, but devices register with only one of them.
+func (s *Server) syncPeers(ctx context.Context) {
+	// peers are read before devices, so we never remove a peer that was
+	// added after we listed the devices
+	current, err := s.wg.Peers(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to get wireguard peers")
+		return
+	}
+
+	devices, err := s.cachedDevices()
+	if err != nil {
+		log.WithError(err).Warn("failed to list devices")
+		return
+	}
+
+	desired := desiredPeers(devices)
+	for pub, allowedIP := range desired {
+		if p, ok := current[pub]; ok && len(p.AllowedIPs) == 1 && p.AllowedIPs[0] == allowedIP {
+			continue
+		}
+
+		if err := s.wg.AddPeer(ctx, &wireguard.Peer{PublicKey: pub, AllowedIPs: []string{allowedIP}}); err != nil {
+			log.WithError(err).Warnf("failed to add peer for %s", allowedIP)
+		}
+	}
+
+	network := s.ipam.Network()
+	for pub := range current {
+		p := current[pub]
+		if _, ok := desired[pub]; ok || !isTunnelPeer(network, &p) {
+			continue
+		}
+
+		// the device could have been leased the address after it was cached
+		if s.tunnelIPLeased(ctx, p.AllowedIPs[0]) {
+			continue
+		}
+
+		log.Infof("removing peer %s, it doesn't belong to a device", strings.Join(p.AllowedIPs, ","))
+		if err := s.wg.RemovePeer(ctx, pub); err != nil {
+			log.WithError(err).Warn("failed to remove peer")
+		}
+	}
+}
+
+// tunnelIPLeased returns true if a tunnel address, in CIDR notation, is
+// leased to a device. It errs on the side of the address being leased.
+func (s *Server) tunnelIPLeased(ctx context.Context, cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return true
+	}
+
+	leases, err := s.ipam.Leases(ctx)
+	if err != nil {
+		log.WithError(err).Warn("failed to list ip leases")
+		return true
+	}
+
+	_, ok := leases[ip.String()]
+	return ok
+}
@@ -0,0 +1,78 @@
+package registrard
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/api"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/jointoken"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/wireguard"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDesiredPeers(t *testing.T) {
+	priv, err := wireguard.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := priv.PublicKey()
+
+	peers := desiredPeers([]registrar.Device{
+		{Status: registrar.DeviceStatus{WireGuard: &registrar.WireGuardStatus{PublicKey: pub.String(), IPAddress: "10.10.0.2"}}},
+		{Status: registrar.DeviceStatus{WireGuard: &registrar.WireGuardStatus{PublicKey: "invalid", IPAddress: "10.10.0.3"}}},
+		{Status: registrar.DeviceStatus{}},
+	})
+
+	if len(peers) != 1 || peers[pub] != "10.10.0.2/32" {
+		t.Errorf("unexpected peers %v", peers)
+	}
+}
+
+func TestIsTunnelPeer(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.10.0.0/24")
+
+	tests := []struct {
+		name       string
+		allowedIPs []string
+		want       bool
+	}{
+		{"device", []string{"10.10.0.2/32"}, true},
+		{"no allowed ips", nil, false},
+		{"outside of the network", []string{"192.168.1.2/32"}, false},
+		{"routes a network", []string{"10.10.0.0/24"}, false},
+		{"routes more than the network", []string{"10.10.0.2/32", "192.168.1.0/24"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTunnelPeer(network, &wireguard.Peer{AllowedIPs: tt.allowedIPs}); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRegisterOutsideOfNetwork(t *testing.T) {
+	// the device registered before WIREGUARD_CIDR changed
+	h := newTestHarness(t, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "device-id"},
+		Status: registrar.DeviceStatus{
+			CredentialHash: jointoken.HashSecret("secret"),
+			WireGuard:      &registrar.WireGuardStatus{IPAddress: "10.20.0.5"},
+		},
+	})
+	defer h.Close()
+
+	ctx := context.Background()
+	resp, err := h.register(ctx, &api.RegisterRequest{WireguardPublicKey: publicKey(t)}, &api.DeviceCredentials{ID: "device-id", Secret: "secret"})
+	if err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+
+	ip, _, err := net.ParseCIDR(resp.Wireguard.Address)
+	if err != nil || !h.server.ipam.Network().Contains(ip) {
+		t.Errorf("expected a new address in the network, got %q", resp.Wireguard.Address)
+	}
+}
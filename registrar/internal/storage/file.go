@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// DefaultFilePath is where the file backend is stored by default
+	DefaultFilePath = "/var/lib/registrard/registrard.db"
+
+	// watchHistory is how many events are kept for watches that start
+	// from an older resource version
+	watchHistory = 1000
+
+	// watchQueueLength is how many events are buffered for each watch.
+	// Watches that fall further behind are ended, so they can't block
+	// writes.
+	watchQueueLength = 100
+)
+
+var (
+	metaBucket = []byte("meta")
+	ipamBucket = []byte("ipam")
+	caBucket   = []byte("ca")
+
+	allocationKey = []byte("allocation")
+	caCertKey     = []byte("tls.crt")
+	caKeyKey      = []byte("tls.key")
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ Backend    = &File{}
+	_ ipam.Store = &fileIPAMStore{}
+)
+
+// File stores everything in a single bbolt database, so registrard can
+// run before there's a cluster to store it's state in. Only one process
+// can have the file open at a time.
+type File struct {
+	db *bolt.DB
+
+	// lock serializes writes, so events are sent to watches in the order
+	// of their resource versions. It also guards watches.
+	lock    sync.Mutex
+	watches map[*fileWatch]struct{}
+
+	// history holds the most recent events, oldest first. Watches that
+	// start from a resource version before expired are told it's too old.
+	history []watch.Event
+	expired uint64
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// OpenFile opens the file backend at path, creating it if it doesn't exist
+func OpenFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create storage directory")
+	}
+
+	// without a timeout, another registrard holding the file would block
+	// us forever
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open '%s'", path)
+	}
+
+	var rv uint64
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{metaBucket, ipamBucket, caBucket, devices.bucket, joinTokens.bucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+
+		// watches treat resource version 0 as any version, so it's
+		// never used
+		b := tx.Bucket(metaBucket)
+		if b.Sequence() == 0 {
+			if _, err := b.NextSequence(); err != nil {
+				return err
+			}
+		}
+
+		rv = b.Sequence()
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "failed to create buckets")
+	}
+
+	return &File{
+		db:      db,
+		watches: make(map[*fileWatch]struct{}),
+		expired: rv,
+	}, nil
+}
+
+// Registrar returns the client devices and join tokens are stored with
+func (f *File) Registrar() v1alpha1.RegistrarV1Alpha1Interface {
+	return &fileRegistrar{f}
+}
+
+// IPAMStore returns the store tunnel address leases are kept in
+func (f *File) IPAMStore() ipam.Store {
+	return &fileIPAMStore{f.db}
+}
+
+// LoadOrCreateCA loads the CA device certificates are issued by, creating
+// it if it doesn't exist
+func (f *File) LoadOrCreateCA(ctx context.Context) (*pki.CA, error) {
+	var ca *pki.CA
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(caBucket)
+
+		var err error
+		ca, err = loadCA(b)
+		if err != nil || ca != nil {
+			return err
+		}
+
+		ca, err = pki.NewCA(pki.CACommonName)
+		if err != nil {
+			return errors.Wrap(err, "failed to create ca")
+		}
+		return storeCA(b, ca)
+	})
+	return ca, err
+}
+
+// ImportCA stores ca as the CA device certificates are issued by
+func (f *File) ImportCA(ctx context.Context, ca *pki.CA) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(caBucket)
+
+		existing, err := loadCA(b)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			return storeCA(b, ca)
+		}
+
+		if !bytes.Equal(existing.CertificatePEM(), ca.CertificatePEM()) {
+			return pki.ErrDifferentCA
+		}
+		return nil
+	})
+}
+
+// Close stops all watches and closes the file, it's safe to call more
+// than once
+func (f *File) Close() error {
+	f.closeOnce.Do(func() {
+		f.lock.Lock()
+		for w := range f.watches {
+			f.endWatch(w, false)
+		}
+		f.lock.Unlock()
+
+		f.closeErr = errors.Wrap(f.db.Close(), "failed to close storage")
+	})
+	return f.closeErr
+}
+
+// loadCA loads the CA stored in b, returning nil if there isn't one
+func loadCA(b *bolt.Bucket) (*pki.CA, error) {
+	certPEM := b.Get(caCertKey)
+	if certPEM == nil {
+		return nil, nil
+	}
+
+	// values are only valid during the transaction, and the ca keeps
+	// the certificate around
+	ca, err := pki.LoadCA(append([]byte(nil), certPEM...), append([]byte(nil), b.Get(caKeyKey)...))
+	return ca, errors.Wrap(err, "failed to load ca")
+}
+
+// storeCA stores ca in b
+func storeCA(b *bolt.Bucket, ca *pki.CA) error {
+	keyPEM, err := ca.KeyPEM()
+	if err != nil {
+		return errors.Wrap(err, "failed to encode ca key")
+	}
+
+	if err := b.Put(caCertKey, ca.CertificatePEM()); err != nil {
+		return err
+	}
+	return b.Put(caKeyKey, keyPEM)
+}
+
+// record sends e to all watches and keeps it for watches that start from
+// an older resource version. Watches that can't keep up are ended, instead
+// of blocking the write. f.lock must be held.
+func (f *File) record(e watch.Event) {
+	f.history = append(f.history, e)
+	if len(f.history) > watchHistory {
+		f.expired = resourceVersion(f.history[0].Object)
+		f.history = f.history[1:]
+	}
+
+	for w := range f.watches {
+		e, ok := w.filter(e)
+		if !ok {
+			continue
+		}
+
+		select {
+		case w.incoming <- e:
+		default:
+			f.endWatch(w, true)
+		}
+	}
+}
+
+// startWatch starts a watch of the events filter matches, replay is sent
+// first. f.lock must be held.
+func (f *File) startWatch(replay []watch.Event, filter watch.FilterFunc) *fileWatch {
+	w := &fileWatch{
+		f:        f,
+		filter:   filter,
+		incoming: make(chan watch.Event, len(replay)+watchQueueLength),
+		result:   make(chan watch.Event),
+		stop:     make(chan struct{}),
+	}
+
+	for _, e := range replay {
+		if e, ok := filter(e); ok {
+			w.incoming <- e
+		}
+	}
+
+	f.watches[w] = struct{}{}
+	go w.run()
+	return w
+}
+
+// endWatch stops sending events to a watch, it ends once it's sent the
+// events it buffered. Watches that lagged are told to start over with an
+// error. f.lock must be held.
+func (f *File) endWatch(w *fileWatch, lagged bool) {
+	if _, ok := f.watches[w]; !ok {
+		return
+	}
+
+	delete(f.watches, w)
+	w.lagged = lagged
+	close(w.incoming)
+}
+
+// fileWatch is a watch of the file backend
+type fileWatch struct {
+	f      *File
+	filter watch.FilterFunc
+
+	// incoming buffers events until they're received, it's closed once
+	// the watch is ended. lagged is set before that if the watch fell
+	// behind.
+	incoming chan watch.Event
+	lagged   bool
+
+	result   chan watch.Event
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// run sends the buffered events to the receiver of the watch
+func (w *fileWatch) run() {
+	defer close(w.result)
+
+	for e := range w.incoming {
+		select {
+		case w.result <- e:
+		case <-w.stop:
+			return
+		}
+	}
+
+	if !w.lagged {
+		return
+	}
+
+	expired := kerrors.NewResourceExpired("watch fell behind, events were dropped")
+	select {
+	case w.result <- watch.Event{Type: watch.Error, Object: &expired.ErrStatus}:
+	case <-w.stop:
+	}
+}
+
+// ResultChan returns the events of the watch
+func (w *fileWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+// Stop ends the watch
+func (w *fileWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+
+		w.f.lock.Lock()
+		w.f.endWatch(w, false)
+		w.f.lock.Unlock()
+	})
+}
+
+// resourceVersion returns the resource version of obj as a number
+func resourceVersion(obj interface{}) uint64 {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return 0
+	}
+
+	rv, _ := strconv.ParseUint(m.GetResourceVersion(), 10, 64)
+	return rv
+}
+
+// fileIPAMStore stores an Allocation in the ipam bucket
+type fileIPAMStore struct {
+	db *bolt.DB
+}
+
+// fileAllocation is how an Allocation is stored
+type fileAllocation struct {
+	Version uint64            `json:"version"`
+	Leases  map[string]string `json:"leases"`
+}
+
+// Get returns the stored allocation
+func (s *fileIPAMStore) Get(ctx context.Context) (*ipam.Allocation, error) {
+	a := &ipam.Allocation{Leases: make(map[string]string)}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stored, err := getAllocation(tx)
+		if err != nil || stored == nil {
+			return err
+		}
+
+		a.Version = strconv.FormatUint(stored.Version, 10)
+		for ip, owner := range stored.Leases {
+			a.Leases[ip] = owner
+		}
+		return nil
+	})
+	return a, err
+}
+
+// Update stores the allocation, if it hasn't changed since it was read
+func (s *fileIPAMStore) Update(ctx context.Context, a *ipam.Allocation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := getAllocation(tx)
+		if err != nil {
+			return err
+		}
+
+		var version uint64
+		if stored != nil {
+			version = stored.Version
+		}
+
+		if a.Version != "" || version != 0 {
+			if v, err := strconv.ParseUint(a.Version, 10, 64); err != nil || v != version {
+				return ipam.ErrConflict
+			}
+		}
+
+		b, err := json.Marshal(&fileAllocation{Version: version + 1, Leases: a.Leases})
+		if err != nil {
+			return errors.Wrap(err, "failed to encode allocation")
+		}
+		return tx.Bucket(ipamBucket).Put(allocationKey, b)
+	})
+}
+
+// getAllocation returns the stored allocation, or nil if nothing has been
+// allocated yet
+func getAllocation(tx *bolt.Tx) (*fileAllocation, error) {
+	b := tx.Bucket(ipamBucket).Get(allocationKey)
+	if b == nil {
+		return nil, nil
+	}
+
+	var a fileAllocation
+	return &a, errors.Wrap(json.Unmarshal(b, &a), "failed to decode allocation")
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// openTestFile opens a file backend in a new temporary directory, returning
+// it and a function that removes it
+func openTestFile(t *testing.T) (*File, string, func()) {
+	dir, err := ioutil.TempDir("", "registrard")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	path := filepath.Join(dir, "registrard.db")
+	f, err := OpenFile(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to open file backend: %v", err)
+	}
+
+	return f, path, func() {
+		f.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFileDevices(t *testing.T) {
+	ctx := context.Background()
+	f, _, cleanup := openTestFile(t)
+	defer cleanup()
+
+	devices := f.Registrar().Devices("registrar")
+	d, err := devices.Create(ctx, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id", Labels: map[string]string{"role": "worker"}},
+		Status:     registrar.DeviceStatus{CredentialHash: "hash"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+
+	if d.UID == "" || d.ResourceVersion == "" || d.Namespace != "registrar" {
+		t.Errorf("expected the uid, resource version and namespace to be set, got %+v", d.ObjectMeta)
+	}
+
+	if d.Status.CredentialHash != "" {
+		t.Error("expected the status to be ignored on create")
+	}
+
+	if _, err := devices.Create(ctx, d, metav1.CreateOptions{}); !kerrors.IsAlreadyExists(err) {
+		t.Errorf("expected creating the device again to fail with already exists, got %v", err)
+	}
+
+	stale := d.DeepCopy()
+	d.Status.CredentialHash = "hash"
+	d, err = devices.UpdateStatus(ctx, d)
+	if err != nil {
+		t.Fatalf("failed to update device status: %v", err)
+	}
+
+	if d.Status.CredentialHash != "hash" {
+		t.Errorf("expected the status to be updated, got %+v", d.Status)
+	}
+
+	if _, err := devices.Update(ctx, stale); !kerrors.IsConflict(err) {
+		t.Errorf("expected updating a stale device to conflict, got %v", err)
+	}
+
+	d, err = devices.Patch(ctx, "device-id", types.MergePatchType, []byte(`{"metadata":{"labels":{"role":"leader"}},"status":{"credentialHash":""}}`))
+	if err != nil {
+		t.Fatalf("failed to patch device: %v", err)
+	}
+
+	if d.Labels["role"] != "leader" || d.Status.CredentialHash != "hash" {
+		t.Errorf("expected only the labels to be patched, got %v and %+v", d.Labels, d.Status)
+	}
+
+	list, err := devices.List(ctx, metav1.ListOptions{LabelSelector: "role=worker"})
+	if err != nil {
+		t.Fatalf("failed to list devices: %v", err)
+	}
+
+	if len(list.Items) != 0 {
+		t.Errorf("expected no devices to match the selector, got %d", len(list.Items))
+	}
+
+	if err := devices.Delete(ctx, "device-id", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete device: %v", err)
+	}
+
+	if _, err := devices.Get(ctx, "device-id", metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("expected the device to be deleted, got %v", err)
+	}
+}
+
+// nextEvent returns the next event sent to w
+func nextEvent(t *testing.T, w watch.Interface) watch.Event {
+	select {
+	case e := <-w.ResultChan():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("expected an event to be sent")
+	}
+	return watch.Event{}
+}
+
+func TestFileWatch(t *testing.T) {
+	ctx := context.Background()
+	f, _, cleanup := openTestFile(t)
+	defer cleanup()
+
+	devices := f.Registrar().Devices("registrar")
+	list, err := devices.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list devices: %v", err)
+	}
+
+	// events after the list are replayed to watches started later
+	for _, name := range []string{"first", "second"} {
+		if _, err := devices.Create(ctx, &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+	}
+
+	if _, err := f.Registrar().JoinTokens("registrar").Create(ctx, &registrar.JoinToken{ObjectMeta: metav1.ObjectMeta{Name: "token"}}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create join token: %v", err)
+	}
+
+	w, err := devices.Watch(ctx, metav1.ListOptions{ResourceVersion: list.ResourceVersion})
+	if err != nil {
+		t.Fatalf("failed to watch devices: %v", err)
+	}
+	defer w.Stop()
+
+	if err := devices.Delete(ctx, "first", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete device: %v", err)
+	}
+
+	tests := []struct {
+		typ  watch.EventType
+		name string
+	}{
+		{watch.Added, "first"},
+		{watch.Added, "second"},
+		{watch.Deleted, "first"},
+	}
+	for _, tt := range tests {
+		e := nextEvent(t, w)
+		if d, ok := e.Object.(*registrar.Device); !ok || e.Type != tt.typ || d.Name != tt.name {
+			t.Errorf("expected %s %s, got %s %v", tt.typ, tt.name, e.Type, e.Object)
+		}
+	}
+}
+
+func TestFileWatchExpired(t *testing.T) {
+	ctx := context.Background()
+	f, path, cleanup := openTestFile(t)
+	defer cleanup()
+
+	var first *registrar.Device
+	for _, name := range []string{"first", "second"} {
+		d, err := f.Registrar().Devices("registrar").Create(ctx, &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: name}}, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+
+		if first == nil {
+			first = d
+		}
+	}
+
+	// history isn't kept when the file is reopened
+	f.Close()
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatalf("failed to reopen file backend: %v", err)
+	}
+	defer f.Close()
+
+	w, err := f.Registrar().Devices("registrar").Watch(ctx, metav1.ListOptions{ResourceVersion: first.ResourceVersion})
+	if err != nil {
+		t.Fatalf("failed to watch devices: %v", err)
+	}
+	defer w.Stop()
+
+	e := nextEvent(t, w)
+	if s, ok := e.Object.(*metav1.Status); e.Type != watch.Error || !ok || !kerrors.IsResourceExpired(kerrors.FromObject(s)) {
+		t.Errorf("expected the resource version to be expired, got %s %v", e.Type, e.Object)
+	}
+}
+
+func TestFileWatchLagging(t *testing.T) {
+	ctx := context.Background()
+	f, _, cleanup := openTestFile(t)
+	defer cleanup()
+
+	devices := f.Registrar().Devices("registrar")
+	w, err := devices.Watch(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to watch devices: %v", err)
+	}
+	defer w.Stop()
+
+	// nothing receives from the watch, which mustn't block writes
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < watchQueueLength+10; i++ {
+			d := &registrar.Device{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("device-%d", i)}}
+			if _, err := devices.Create(ctx, d, metav1.CreateOptions{}); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("failed to create device: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a lagging watch to not block writes")
+	}
+
+	// the buffered events are still sent, followed by an error
+	e := nextEvent(t, w)
+	sent := 0
+	for ; e.Type == watch.Added; sent++ {
+		e = nextEvent(t, w)
+	}
+
+	if sent < watchQueueLength || sent >= watchQueueLength+10 {
+		t.Errorf("expected the buffered events to be sent, got %d", sent)
+	}
+
+	if s, ok := e.Object.(*metav1.Status); e.Type != watch.Error || !ok || !kerrors.IsResourceExpired(kerrors.FromObject(s)) {
+		t.Errorf("expected the watch to expire, got %s %v", e.Type, e.Object)
+	}
+
+	if _, ok := <-w.ResultChan(); ok {
+		t.Error("expected the watch to be ended")
+	}
+}
+
+func TestFileIPAMStore(t *testing.T) {
+	ctx := context.Background()
+	f, _, cleanup := openTestFile(t)
+	defer cleanup()
+
+	store := f.IPAMStore()
+	a, err := store.Get(ctx)
+	if err != nil {
+		t.Fatalf("failed to get allocation: %v", err)
+	}
+
+	stale := &ipam.Allocation{Version: a.Version, Leases: map[string]string{"10.10.0.3": "other"}}
+
+	a.Leases["10.10.0.2"] = "device-id"
+	if err := store.Update(ctx, a); err != nil {
+		t.Fatalf("failed to update allocation: %v", err)
+	}
+
+	if err := store.Update(ctx, stale); !errors.Is(err, ipam.ErrConflict) {
+		t.Errorf("expected updating a stale allocation to conflict, got %v", err)
+	}
+
+	a, err = store.Get(ctx)
+	if err != nil {
+		t.Fatalf("failed to get allocation: %v", err)
+	}
+
+	if len(a.Leases) != 1 || a.Leases["10.10.0.2"] != "device-id" {
+		t.Errorf("expected only the first update to be stored, got %v", a.Leases)
+	}
+}
+
+func TestFileCA(t *testing.T) {
+	ctx := context.Background()
+	f, path, cleanup := openTestFile(t)
+	defer cleanup()
+
+	ca, err := f.LoadOrCreateCA(ctx)
+	if err != nil {
+		t.Fatalf("failed to create ca: %v", err)
+	}
+
+	f.Close()
+	f, err = OpenFile(path)
+	if err != nil {
+		t.Fatalf("failed to reopen file backend: %v", err)
+	}
+	defer f.Close()
+
+	loaded, err := f.LoadOrCreateCA(ctx)
+	if err != nil {
+		t.Fatalf("failed to load ca: %v", err)
+	}
+
+	if string(loaded.CertificatePEM()) != string(ca.CertificatePEM()) {
+		t.Error("expected the same ca to be loaded after reopening")
+	}
+
+	if err := f.ImportCA(ctx, ca); err != nil {
+		t.Errorf("expected importing the same ca to succeed, got %v", err)
+	}
+
+	other, err := pki.NewCA(pki.CACommonName)
+	if err != nil {
+		t.Fatalf("failed to create ca: %v", err)
+	}
+
+	if err := f.ImportCA(ctx, other); !errors.Is(err, pki.ErrDifferentCA) {
+		t.Errorf("expected importing a different ca to fail, got %v", err)
+	}
+}
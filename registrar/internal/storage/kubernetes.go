@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+)
+
+const (
+	// ipamConfigMap is the ConfigMap tunnel address leases are stored in
+	ipamConfigMap = "registrard-ipam"
+
+	// caSecret is the Secret the CA is stored in
+	caSecret = "registrard-ca"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ Backend = &Kubernetes{}
+)
+
+// Kubernetes stores devices and join tokens as custom resources, leases in
+// a ConfigMap and the CA in a Secret
+type Kubernetes struct {
+	k         v1alpha1.Interface
+	namespace string
+}
+
+// NewKubernetes returns a backend storing everything in namespace
+func NewKubernetes(k v1alpha1.Interface, namespace string) *Kubernetes {
+	return &Kubernetes{k: k, namespace: namespace}
+}
+
+// Registrar returns the client devices and join tokens are stored with
+func (b *Kubernetes) Registrar() v1alpha1.RegistrarV1Alpha1Interface {
+	return b.k.RegistrarV1Alpha1Client()
+}
+
+// IPAMStore returns the store tunnel address leases are kept in
+func (b *Kubernetes) IPAMStore() ipam.Store {
+	return ipam.NewConfigMapStore(b.k, b.namespace, ipamConfigMap)
+}
+
+// LoadOrCreateCA loads the CA device certificates are issued by, creating
+// it if it doesn't exist
+func (b *Kubernetes) LoadOrCreateCA(ctx context.Context) (*pki.CA, error) {
+	return pki.LoadOrCreateCA(ctx, b.k, b.namespace, caSecret)
+}
+
+// ImportCA stores ca as the CA device certificates are issued by
+func (b *Kubernetes) ImportCA(ctx context.Context, ca *pki.CA) error {
+	return pki.ImportCA(ctx, b.k, b.namespace, caSecret, ca)
+}
+
+// Close does nothing, the clientset is owned by the caller
+func (b *Kubernetes) Close() error {
+	return nil
+}
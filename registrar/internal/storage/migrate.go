@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"context"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxLeaseRetries is how many times copying leases is retried when it
+// conflicts with a running registrard
+const maxLeaseRetries = 10
+
+// Migrate copies the state stored in from into to, e.g. to move from the
+// file backend into the cluster it's devices created. Objects that already
+// exist in to are left alone, so it's safe to run more than once. Both
+// backends have to use the same CA, otherwise every device would have to
+// register again.
+func Migrate(ctx context.Context, from, to Backend, namespace string) error {
+	ca, err := from.LoadOrCreateCA(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to load ca")
+	}
+
+	if err := to.ImportCA(ctx, ca); err != nil {
+		return errors.Wrap(err, "failed to import ca")
+	}
+
+	if err := migrateJoinTokens(ctx, from, to, namespace); err != nil {
+		return err
+	}
+
+	if err := migrateDevices(ctx, from, to, namespace); err != nil {
+		return err
+	}
+
+	return migrateLeases(ctx, from.IPAMStore(), to.IPAMStore())
+}
+
+// resetMeta clears the fields of an object that are set by the backend
+// it's stored in
+func resetMeta(m *metav1.ObjectMeta, namespace string) {
+	m.Namespace = namespace
+	m.UID = ""
+	m.ResourceVersion = ""
+	m.CreationTimestamp = metav1.Time{}
+	m.SelfLink = ""
+}
+
+func migrateJoinTokens(ctx context.Context, from, to Backend, namespace string) error {
+	tokens, err := from.Registrar().JoinTokens(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list join tokens")
+	}
+
+	for i := range tokens.Items {
+		t := tokens.Items[i].DeepCopy()
+		resetMeta(&t.ObjectMeta, namespace)
+
+		_, err := to.Registrar().JoinTokens(namespace).Create(ctx, t, metav1.CreateOptions{})
+		if kerrors.IsAlreadyExists(err) {
+			log.WithField("token", t.Name).Info("join token already exists, skipping")
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to create join token '%s'", t.Name)
+		}
+
+		log.WithField("token", t.Name).Info("migrated join token")
+	}
+
+	return nil
+}
+
+func migrateDevices(ctx context.Context, from, to Backend, namespace string) error {
+	devices, err := from.Registrar().Devices(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to list devices")
+	}
+
+	for i := range devices.Items {
+		d := devices.Items[i].DeepCopy()
+		resetMeta(&d.ObjectMeta, namespace)
+
+		created, err := to.Registrar().Devices(namespace).Create(ctx, d, metav1.CreateOptions{})
+		if kerrors.IsAlreadyExists(err) {
+			log.WithField("device", d.Name).Info("device already exists, skipping")
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to create device '%s'", d.Name)
+		}
+
+		// the status is ignored on create
+		created.Status = d.Status
+		if _, err := to.Registrar().Devices(namespace).UpdateStatus(ctx, created); err != nil {
+			return errors.Wrapf(err, "failed to update status of device '%s'", d.Name)
+		}
+
+		log.WithField("device", d.Name).Info("migrated device")
+	}
+
+	return nil
+}
+
+// migrateLeases adds the leases of from to to. Addresses that are leased
+// to a different device in to are kept as they are.
+func migrateLeases(ctx context.Context, from, to ipam.Store) error {
+	src, err := from.Get(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get leases")
+	}
+
+	for attempt := 0; attempt < maxLeaseRetries; attempt++ {
+		dst, err := to.Get(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get leases")
+		}
+
+		if dst.Leases == nil {
+			dst.Leases = make(map[string]string)
+		}
+
+		for ip, owner := range src.Leases {
+			if existing, ok := dst.Leases[ip]; ok && existing != owner {
+				log.WithFields(log.Fields{"ip": ip, "owner": existing}).
+					Warnf("address is already leased, not migrating lease of '%s'", owner)
+				continue
+			}
+			dst.Leases[ip] = owner
+		}
+
+		err = to.Update(ctx, dst)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, ipam.ErrConflict) {
+			return errors.Wrap(err, "failed to save leases")
+		}
+	}
+
+	return ipam.ErrConflict
+}
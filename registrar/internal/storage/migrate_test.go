@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1/fake"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMigrate(t *testing.T) {
+	logrus.SetLevel(logrus.WarnLevel)
+
+	ctx := context.Background()
+	from, _, cleanup := openTestFile(t)
+	defer cleanup()
+
+	ca, err := from.LoadOrCreateCA(ctx)
+	if err != nil {
+		t.Fatalf("failed to create ca: %v", err)
+	}
+
+	d, err := from.Registrar().Devices("registrar").Create(ctx, &registrar.Device{
+		ObjectMeta: metav1.ObjectMeta{Name: "device-id"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("failed to create device: %v", err)
+	}
+
+	d.Status.CredentialHash = "hash"
+	if _, err := from.Registrar().Devices("registrar").UpdateStatus(ctx, d); err != nil {
+		t.Fatalf("failed to update device status: %v", err)
+	}
+
+	if _, err := from.Registrar().JoinTokens("registrar").Create(ctx, &registrar.JoinToken{
+		ObjectMeta: metav1.ObjectMeta{Name: "token"},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create join token: %v", err)
+	}
+
+	if err := from.IPAMStore().Update(ctx, &ipam.Allocation{Leases: map[string]string{
+		"10.10.0.2": "device-id",
+		"10.10.0.3": "other-device",
+	}}); err != nil {
+		t.Fatalf("failed to update allocation: %v", err)
+	}
+
+	to := NewKubernetes(fake.NewSimpleClientset(), "registrar")
+	if err := to.IPAMStore().Update(ctx, &ipam.Allocation{Leases: map[string]string{"10.10.0.3": "device-in-cluster"}}); err != nil {
+		t.Fatalf("failed to update allocation: %v", err)
+	}
+
+	// migrating twice leaves everything as it is
+	for i := 0; i < 2; i++ {
+		if err := Migrate(ctx, from, to, "registrar"); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+	}
+
+	migrated, err := to.Registrar().Devices("registrar").Get(ctx, "device-id", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get migrated device: %v", err)
+	}
+
+	if migrated.Status.CredentialHash != "hash" {
+		t.Errorf("expected the status of the device to be migrated, got %+v", migrated.Status)
+	}
+
+	if _, err := to.Registrar().JoinTokens("registrar").Get(ctx, "token", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected the join token to be migrated, got %v", err)
+	}
+
+	a, err := to.IPAMStore().Get(ctx)
+	if err != nil {
+		t.Fatalf("failed to get allocation: %v", err)
+	}
+
+	if a.Leases["10.10.0.2"] != "device-id" || a.Leases["10.10.0.3"] != "device-in-cluster" {
+		t.Errorf("expected leases to be merged without replacing existing ones, got %v", a.Leases)
+	}
+
+	toCA, err := to.LoadOrCreateCA(ctx)
+	if err != nil {
+		t.Fatalf("failed to load ca: %v", err)
+	}
+
+	if string(toCA.CertificatePEM()) != string(ca.CertificatePEM()) {
+		t.Error("expected the ca to be migrated")
+	}
+}
@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/google/uuid"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// resource describes how objects of a kind are stored by the file backend
+type resource struct {
+	bucket   []byte
+	resource schema.GroupResource
+
+	// newObject returns an empty object of the kind
+	newObject func() runtime.Object
+
+	// newList returns a list of objects of the kind
+	newList func(rv string, objs []runtime.Object) runtime.Object
+
+	// copyStatus copies the status of src into dst, it's nil if the kind
+	// doesn't have a status subresource
+	copyStatus func(dst, src runtime.Object)
+}
+
+var devices = &resource{
+	bucket:   []byte("devices"),
+	resource: registrar.GroupVersion.WithResource("devices").GroupResource(),
+	newObject: func() runtime.Object {
+		return &registrar.Device{}
+	},
+	newList: func(rv string, objs []runtime.Object) runtime.Object {
+		l := &registrar.DeviceList{ListMeta: metav1.ListMeta{ResourceVersion: rv}}
+		for _, obj := range objs {
+			l.Items = append(l.Items, *obj.(*registrar.Device))
+		}
+		return l
+	},
+	copyStatus: func(dst, src runtime.Object) {
+		dst.(*registrar.Device).Status = *src.(*registrar.Device).Status.DeepCopy()
+	},
+}
+
+var joinTokens = &resource{
+	bucket:   []byte("jointokens"),
+	resource: registrar.GroupVersion.WithResource("jointokens").GroupResource(),
+	newObject: func() runtime.Object {
+		return &registrar.JoinToken{}
+	},
+	newList: func(rv string, objs []runtime.Object) runtime.Object {
+		l := &registrar.JoinTokenList{ListMeta: metav1.ListMeta{ResourceVersion: rv}}
+		for _, obj := range objs {
+			l.Items = append(l.Items, *obj.(*registrar.JoinToken))
+		}
+		return l
+	},
+}
+
+// objectKey returns the key an object is stored under
+func objectKey(namespace, name string) []byte {
+	return []byte(namespace + "/" + name)
+}
+
+// getObject reads an object, returning NotFound if it doesn't exist
+func getObject(tx *bolt.Tx, res *resource, namespace, name string) (runtime.Object, error) {
+	b := tx.Bucket(res.bucket).Get(objectKey(namespace, name))
+	if b == nil {
+		return nil, kerrors.NewNotFound(res.resource, name)
+	}
+
+	obj := res.newObject()
+	return obj, errors.Wrap(json.Unmarshal(b, obj), "failed to decode object")
+}
+
+// putObject stores an object
+func putObject(tx *bolt.Tx, res *resource, obj runtime.Object) error {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode object")
+	}
+	return tx.Bucket(res.bucket).Put(objectKey(m.GetNamespace(), m.GetName()), b)
+}
+
+// write runs fn in a transaction with the resource version of the write,
+// sending the event it returns to watches once it's committed
+func (f *File) write(fn func(tx *bolt.Tx, rv string) (watch.Event, error)) (runtime.Object, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var e watch.Event
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		seq, err := tx.Bucket(metaBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+
+		e, err = fn(tx, strconv.FormatUint(seq, 10))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f.record(e)
+	return e.Object.DeepCopyObject(), nil
+}
+
+func (f *File) get(res *resource, namespace, name string) (runtime.Object, error) {
+	var obj runtime.Object
+	err := f.db.View(func(tx *bolt.Tx) error {
+		var err error
+		obj, err = getObject(tx, res, namespace, name)
+		return err
+	})
+	return obj, err
+}
+
+func (f *File) list(res *resource, namespace string, opts metav1.ListOptions) (runtime.Object, error) {
+	label, field, err := selectors(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []runtime.Object
+	var rv uint64
+	err = f.db.View(func(tx *bolt.Tx) error {
+		rv = tx.Bucket(metaBucket).Sequence()
+
+		var prefix []byte
+		if namespace != "" {
+			prefix = objectKey(namespace, "")
+		}
+
+		c := tx.Bucket(res.bucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			obj := res.newObject()
+			if err := json.Unmarshal(v, obj); err != nil {
+				return errors.Wrapf(err, "failed to decode '%s'", k)
+			}
+
+			if matches(obj, label, field) {
+				objs = append(objs, obj)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.newList(strconv.FormatUint(rv, 10), objs), nil
+}
+
+func (f *File) create(res *resource, namespace string, obj runtime.Object) (runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.GetName() == "" {
+		return nil, kerrors.NewBadRequest("name is required")
+	}
+
+	if ns := m.GetNamespace(); ns != "" && ns != namespace {
+		return nil, kerrors.NewBadRequest(fmt.Sprintf("namespace '%s' doesn't match the namespace of the request", ns))
+	}
+	m.SetNamespace(namespace)
+
+	return f.write(func(tx *bolt.Tx, rv string) (watch.Event, error) {
+		if tx.Bucket(res.bucket).Get(objectKey(namespace, m.GetName())) != nil {
+			return watch.Event{}, kerrors.NewAlreadyExists(res.resource, m.GetName())
+		}
+
+		m.SetUID(types.UID(uuid.New().String()))
+		m.SetCreationTimestamp(metav1.Now())
+		m.SetResourceVersion(rv)
+
+		// the status can't be set on create
+		if res.copyStatus != nil {
+			res.copyStatus(obj, res.newObject())
+		}
+
+		return watch.Event{Type: watch.Added, Object: obj}, putObject(tx, res, obj)
+	})
+}
+
+func (f *File) update(res *resource, namespace string, obj runtime.Object, subresource string) (runtime.Object, error) {
+	obj = obj.DeepCopyObject()
+	return f.write(func(tx *bolt.Tx, rv string) (watch.Event, error) {
+		return updateObject(tx, res, namespace, obj, subresource, rv)
+	})
+}
+
+// updateObject stores obj as the new version of an existing object. Only
+// the status of kinds with a status subresource can be updated through it,
+// and never through the object itself.
+func updateObject(tx *bolt.Tx, res *resource, namespace string, obj runtime.Object, subresource, rv string) (watch.Event, error) {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return watch.Event{}, err
+	}
+
+	existing, err := getObject(tx, res, namespace, m.GetName())
+	if err != nil {
+		return watch.Event{}, err
+	}
+
+	em, err := meta.Accessor(existing)
+	if err != nil {
+		return watch.Event{}, err
+	}
+
+	if v := m.GetResourceVersion(); v != "" && v != em.GetResourceVersion() {
+		return watch.Event{}, kerrors.NewConflict(res.resource, m.GetName(),
+			fmt.Errorf("the object has been modified; please apply your changes to the latest version and try again"))
+	}
+
+	switch {
+	case subresource == "status" && res.copyStatus != nil:
+		res.copyStatus(existing, obj)
+		obj, m = existing, em
+	case subresource != "":
+		return watch.Event{}, kerrors.NewNotFound(res.resource, m.GetName()+"/"+subresource)
+	case res.copyStatus != nil:
+		res.copyStatus(obj, existing)
+	}
+
+	m.SetNamespace(namespace)
+	m.SetUID(em.GetUID())
+	m.SetCreationTimestamp(em.GetCreationTimestamp())
+	m.SetResourceVersion(rv)
+
+	return watch.Event{Type: watch.Modified, Object: obj}, putObject(tx, res, obj)
+}
+
+func (f *File) patch(res *resource, namespace, name string, pt types.PatchType, data []byte, subresource string) (runtime.Object, error) {
+	return f.write(func(tx *bolt.Tx, rv string) (watch.Event, error) {
+		existing, err := getObject(tx, res, namespace, name)
+		if err != nil {
+			return watch.Event{}, err
+		}
+
+		original, err := json.Marshal(existing)
+		if err != nil {
+			return watch.Event{}, errors.Wrap(err, "failed to encode object")
+		}
+
+		var patched []byte
+		switch pt {
+		case types.MergePatchType:
+			patched, err = jsonpatch.MergePatch(original, data)
+		case types.JSONPatchType:
+			var p jsonpatch.Patch
+			p, err = jsonpatch.DecodePatch(data)
+			if err == nil {
+				patched, err = p.Apply(original)
+			}
+		default:
+			return watch.Event{}, kerrors.NewBadRequest(fmt.Sprintf("patch type '%s' isn't supported", pt))
+		}
+		if err != nil {
+			return watch.Event{}, kerrors.NewBadRequest(err.Error())
+		}
+
+		obj := res.newObject()
+		if err := json.Unmarshal(patched, obj); err != nil {
+			return watch.Event{}, kerrors.NewBadRequest(err.Error())
+		}
+
+		return updateObject(tx, res, namespace, obj, subresource, rv)
+	})
+}
+
+func (f *File) delete(res *resource, namespace, name string) error {
+	_, err := f.write(func(tx *bolt.Tx, rv string) (watch.Event, error) {
+		obj, err := getObject(tx, res, namespace, name)
+		if err != nil {
+			return watch.Event{}, err
+		}
+
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return watch.Event{}, err
+		}
+		m.SetResourceVersion(rv)
+
+		return watch.Event{Type: watch.Deleted, Object: obj}, tx.Bucket(res.bucket).Delete(objectKey(namespace, name))
+	})
+	return err
+}
+
+func (f *File) deleteCollection(res *resource, namespace string, opts metav1.ListOptions) error {
+	list, err := f.list(res, namespace, opts)
+	if err != nil {
+		return err
+	}
+
+	objs, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	for _, obj := range objs {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+
+		if err := f.delete(res, m.GetNamespace(), m.GetName()); err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// watch returns a watch of the objects matching opts. Events after the
+// resource version of opts are replayed, if they're still kept.
+func (f *File) watch(res *resource, namespace string, opts metav1.ListOptions) (watch.Interface, error) {
+	label, field, err := selectors(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := reflect.TypeOf(res.newObject())
+	filter := func(e watch.Event) (watch.Event, bool) {
+		if e.Type == watch.Error {
+			return e, true
+		}
+
+		if reflect.TypeOf(e.Object) != kind {
+			return e, false
+		}
+
+		m, err := meta.Accessor(e.Object)
+		if err != nil || (namespace != "" && m.GetNamespace() != namespace) {
+			return e, false
+		}
+
+		return e, matches(e.Object, label, field)
+	}
+
+	// holding the lock means no events are sent while the watch is started,
+	// so none are missed or sent twice
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var replay []watch.Event
+	if opts.ResourceVersion != "" && opts.ResourceVersion != "0" {
+		from, err := strconv.ParseUint(opts.ResourceVersion, 10, 64)
+		if err != nil {
+			return nil, kerrors.NewBadRequest(fmt.Sprintf("invalid resource version '%s'", opts.ResourceVersion))
+		}
+
+		if from < f.expired {
+			replay = []watch.Event{{
+				Type:   watch.Error,
+				Object: &kerrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", from, f.expired)).ErrStatus,
+			}}
+		} else {
+			for _, e := range f.history {
+				if resourceVersion(e.Object) > from {
+					replay = append(replay, e)
+				}
+			}
+		}
+	}
+
+	return f.startWatch(replay, filter), nil
+}
+
+// selectors returns the label and field selectors of opts
+func selectors(opts metav1.ListOptions) (labels.Selector, fields.Selector, error) {
+	label, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, nil, kerrors.NewBadRequest(err.Error())
+	}
+
+	field, err := fields.ParseSelector(opts.FieldSelector)
+	if err != nil {
+		return nil, nil, kerrors.NewBadRequest(err.Error())
+	}
+
+	return label, field, nil
+}
+
+// matches returns true if obj matches the selectors. Only the name and
+// namespace fields are supported.
+func matches(obj runtime.Object, label labels.Selector, field fields.Selector) bool {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+
+	if !label.Matches(labels.Set(m.GetLabels())) {
+		return false
+	}
+
+	return field.Matches(fields.Set{
+		"metadata.name":      m.GetName(),
+		"metadata.namespace": m.GetNamespace(),
+	})
+}
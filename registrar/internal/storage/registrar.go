@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	registrar "github.com/jaredallard-home/worker-nodes/registrar/apis/types/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// verify we satisfy the interface on compile time
+var (
+	_ v1alpha1.RegistrarV1Alpha1Interface = &fileRegistrar{}
+	_ v1alpha1.DeviceInterface            = &fileDevices{}
+	_ v1alpha1.JoinTokenInterface         = &fileJoinTokens{}
+)
+
+// fileRegistrar is a registrar client for objects in the file backend
+type fileRegistrar struct {
+	f *File
+}
+
+// Devices returns a client for devices in a namespace
+func (r *fileRegistrar) Devices(namespace string) v1alpha1.DeviceInterface {
+	return &fileDevices{r.f, namespace}
+}
+
+// JoinTokens returns a client for join tokens in a namespace
+func (r *fileRegistrar) JoinTokens(namespace string) v1alpha1.JoinTokenInterface {
+	return &fileJoinTokens{r.f, namespace}
+}
+
+// fileDevices is a client for devices in a namespace
+type fileDevices struct {
+	f  *File
+	ns string
+}
+
+// List returns all devices in a namespace matching the selectors of opts
+func (c *fileDevices) List(ctx context.Context, opts metav1.ListOptions) (*registrar.DeviceList, error) {
+	obj, err := c.f.list(devices, c.ns, opts)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.DeviceList), nil
+}
+
+// Get returns a given device by it's name
+func (c *fileDevices) Get(ctx context.Context, name string, opts metav1.GetOptions) (*registrar.Device, error) {
+	obj, err := c.f.get(devices, c.ns, name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), nil
+}
+
+// Delete deletes a device by it's name
+func (c *fileDevices) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.f.delete(devices, c.ns, name)
+}
+
+// DeleteCollection deletes the devices matching the selectors of listOpts
+func (c *fileDevices) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return c.f.deleteCollection(devices, c.ns, listOpts)
+}
+
+// Update updates a device, ignoring changes to it's status
+func (c *fileDevices) Update(ctx context.Context, d *registrar.Device) (*registrar.Device, error) {
+	obj, err := c.f.update(devices, c.ns, d, "")
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), nil
+}
+
+// UpdateStatus updates the status subresource of a device
+func (c *fileDevices) UpdateStatus(ctx context.Context, d *registrar.Device) (*registrar.Device, error) {
+	obj, err := c.f.update(devices, c.ns, d, "status")
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), nil
+}
+
+// Patch applies the patch and returns the patched device
+func (c *fileDevices) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (*registrar.Device, error) {
+	obj, err := c.f.patch(devices, c.ns, name, pt, data, strings.Join(subresources, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), nil
+}
+
+// Create creates a device, ignoring it's status
+func (c *fileDevices) Create(ctx context.Context, d *registrar.Device, opts metav1.CreateOptions) (*registrar.Device, error) {
+	obj, err := c.f.create(devices, c.ns, d)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.Device), nil
+}
+
+// Watch returns a watch of the devices matching the selectors of opts
+func (c *fileDevices) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.f.watch(devices, c.ns, opts)
+}
+
+// fileJoinTokens is a client for join tokens in a namespace
+type fileJoinTokens struct {
+	f  *File
+	ns string
+}
+
+// List returns all join tokens in a namespace matching the selectors of opts
+func (c *fileJoinTokens) List(ctx context.Context, opts metav1.ListOptions) (*registrar.JoinTokenList, error) {
+	obj, err := c.f.list(joinTokens, c.ns, opts)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinTokenList), nil
+}
+
+// Get returns a given join token by it's name
+func (c *fileJoinTokens) Get(ctx context.Context, name string, opts metav1.GetOptions) (*registrar.JoinToken, error) {
+	obj, err := c.f.get(joinTokens, c.ns, name)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), nil
+}
+
+// Delete deletes a join token by it's name
+func (c *fileJoinTokens) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.f.delete(joinTokens, c.ns, name)
+}
+
+// DeleteCollection deletes the join tokens matching the selectors of listOpts
+func (c *fileJoinTokens) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return c.f.deleteCollection(joinTokens, c.ns, listOpts)
+}
+
+// Update updates a join token
+func (c *fileJoinTokens) Update(ctx context.Context, t *registrar.JoinToken) (*registrar.JoinToken, error) {
+	obj, err := c.f.update(joinTokens, c.ns, t, "")
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), nil
+}
+
+// Patch applies the patch and returns the patched join token
+func (c *fileJoinTokens) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, subresources ...string) (*registrar.JoinToken, error) {
+	obj, err := c.f.patch(joinTokens, c.ns, name, pt, data, strings.Join(subresources, "/"))
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), nil
+}
+
+// Create creates a join token
+func (c *fileJoinTokens) Create(ctx context.Context, t *registrar.JoinToken, opts metav1.CreateOptions) (*registrar.JoinToken, error) {
+	obj, err := c.f.create(joinTokens, c.ns, t)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*registrar.JoinToken), nil
+}
+
+// Watch returns a watch of the join tokens matching the selectors of opts
+func (c *fileJoinTokens) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return c.f.watch(joinTokens, c.ns, opts)
+}
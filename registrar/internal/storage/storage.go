@@ -0,0 +1,32 @@
+// Package storage contains the backends registrard stores devices, join
+// tokens, tunnel address leases and it's CA in.
+package storage
+
+import (
+	"context"
+
+	"github.com/jaredallard-home/worker-nodes/registrar/apis/clientset/v1alpha1"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/ipam"
+	"github.com/jaredallard-home/worker-nodes/registrar/internal/pki"
+)
+
+// Backend stores the state of registrard
+type Backend interface {
+	// Registrar returns the client devices and join tokens are stored with
+	Registrar() v1alpha1.RegistrarV1Alpha1Interface
+
+	// IPAMStore returns the store tunnel address leases are kept in
+	IPAMStore() ipam.Store
+
+	// LoadOrCreateCA loads the CA device certificates are issued by,
+	// creating it if it doesn't exist
+	LoadOrCreateCA(ctx context.Context) (*pki.CA, error)
+
+	// ImportCA stores ca as the CA device certificates are issued by. If a
+	// CA is already stored, it has to be ca, otherwise pki.ErrDifferentCA is
+	// returned.
+	ImportCA(ctx context.Context, ca *pki.CA) error
+
+	// Close releases the resources of the backend
+	Close() error
+}
@@ -0,0 +1,286 @@
+package wireguard
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Config is the desired configuration of a WireGuard interface
+type Config struct {
+	// PrivateKey is the private key of the interface
+	PrivateKey Key
+
+	// Address is the address, in CIDR notation, of the interface
+	Address string
+
+	// Peers is the complete list of peers of the interface, any other
+	// peers are removed
+	Peers []Peer
+}
+
+// state is the current state of an interface, as reported by wg(8)
+type state struct {
+	privateKey Key
+	peers      map[Key]Peer
+}
+
+// Configure creates the interface, if needed, and brings it to the state
+// described by c. Nothing is changed if the interface is already configured
+// correctly. Returns true if anything was changed.
+func (d *Device) Configure(ctx context.Context, c *Config) (bool, error) {
+	changed, err := d.ensureLink(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	addrChanged, err := d.ensureAddress(ctx, c.Address)
+	if err != nil {
+		return false, err
+	}
+	changed = changed || addrChanged
+
+	cur, err := d.state(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if cur.privateKey != c.PrivateKey {
+		if err := d.setPrivateKey(ctx, c.PrivateKey); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	desired := make(map[Key]bool)
+	for i := range c.Peers {
+		p := c.Peers[i]
+		desired[p.PublicKey] = true
+
+		if existing, ok := cur.peers[p.PublicKey]; ok && peerEqual(&existing, &p) {
+			continue
+		}
+
+		if err := d.AddPeer(ctx, &p); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	for pub := range cur.peers {
+		if desired[pub] {
+			continue
+		}
+
+		if err := d.RemovePeer(ctx, pub); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	upChanged, err := d.ensureUp(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return changed || upChanged, nil
+}
+
+// ensureLink creates the interface if it doesn't exist
+func (d *Device) ensureLink(ctx context.Context) (bool, error) {
+	if _, err := d.run(ctx, "ip", "link", "show", "dev", d.name); err == nil {
+		return false, nil
+	}
+
+	_, err := d.run(ctx, "ip", "link", "add", "dev", d.name, "type", "wireguard")
+	return true, errors.Wrap(err, "failed to create interface")
+}
+
+// Delete removes the interface, if it exists
+func (d *Device) Delete(ctx context.Context) error {
+	if _, err := d.run(ctx, "ip", "link", "show", "dev", d.name); err != nil {
+		return nil
+	}
+
+	_, err := d.run(ctx, "ip", "link", "del", "dev", d.name)
+	return errors.Wrap(err, "failed to delete interface")
+}
+
+// ensureAddress makes addr the only address of the interface
+func (d *Device) ensureAddress(ctx context.Context, addr string) (bool, error) {
+	b, err := d.run(ctx, "ip", "-o", "-4", "address", "show", "dev", d.name)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list addresses")
+	}
+
+	changed := false
+	found := false
+	for _, line := range strings.Split(string(b), "\n") {
+		// 5: wg0    inet 10.10.0.2/24 scope global wg0\       valid_lft forever ...
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields)-1; i++ {
+			if fields[i] != "inet" {
+				continue
+			}
+
+			if fields[i+1] == addr {
+				found = true
+				continue
+			}
+
+			if _, err := d.run(ctx, "ip", "address", "del", fields[i+1], "dev", d.name); err != nil {
+				return false, errors.Wrap(err, "failed to remove address")
+			}
+			changed = true
+		}
+	}
+
+	if !found {
+		if _, err := d.run(ctx, "ip", "address", "add", addr, "dev", d.name); err != nil {
+			return false, errors.Wrap(err, "failed to add address")
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// ensureUp sets the interface up, if it isn't already
+func (d *Device) ensureUp(ctx context.Context) (bool, error) {
+	b, err := d.run(ctx, "ip", "-o", "link", "show", "dev", d.name)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get interface")
+	}
+
+	// 5: wg0: <POINTOPOINT,NOARP,UP,LOWER_UP> mtu 1420 ...
+	line := string(b)
+	if start, end := strings.Index(line, "<"), strings.Index(line, ">"); start != -1 && end > start {
+		for _, flag := range strings.Split(line[start+1:end], ",") {
+			if flag == "UP" {
+				return false, nil
+			}
+		}
+	}
+
+	_, err = d.run(ctx, "ip", "link", "set", "up", "dev", d.name)
+	return true, errors.Wrap(err, "failed to set interface up")
+}
+
+// setPrivateKey sets the private key of the interface. wg(8) only reads keys
+// from files, so it's written to a temporary one.
+func (d *Device) setPrivateKey(ctx context.Context, k Key) error {
+	f, err := ioutil.TempFile("", "wg-key")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary key file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(k.String()); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write key")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to write key")
+	}
+
+	_, err = d.run(ctx, "wg", "set", d.name, "private-key", f.Name())
+	return errors.Wrap(err, "failed to set private key")
+}
+
+// state returns the current configuration of the interface
+func (d *Device) state(ctx context.Context) (*state, error) {
+	b, err := d.run(ctx, "wg", "show", d.name, "dump")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get interface configuration")
+	}
+
+	return parseDump(string(b))
+}
+
+// parseDump parses the output of `wg show <interface> dump`. The first line
+// describes the interface, every other line is a peer.
+func parseDump(dump string) (*state, error) {
+	s := &state{peers: make(map[Key]Peer)}
+
+	lines := strings.Split(strings.TrimSpace(dump), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, "\t")
+		if i == 0 {
+			// private-key public-key listen-port fwmark
+			if len(fields) != 4 {
+				return nil, errors.Errorf("unexpected interface line %q", line)
+			}
+
+			// a new interface has no private key yet
+			if k, err := ParseKey(fields[0]); err == nil {
+				s.privateKey = k
+			}
+			continue
+		}
+
+		// public-key preshared-key endpoint allowed-ips latest-handshake
+		// transfer-rx transfer-tx persistent-keepalive
+		if len(fields) != 8 {
+			return nil, errors.Errorf("unexpected peer line %q", line)
+		}
+
+		pub, err := ParseKey(fields[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse peer public key")
+		}
+
+		p := Peer{PublicKey: pub}
+		if fields[2] != "(none)" {
+			p.Endpoint = fields[2]
+		}
+		if fields[3] != "(none)" {
+			p.AllowedIPs = strings.Split(fields[3], ",")
+		}
+		if fields[7] != "off" {
+			p.PersistentKeepalive, err = strconv.Atoi(fields[7])
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse persistent keepalive")
+			}
+		}
+		s.peers[pub] = p
+	}
+
+	return s, nil
+}
+
+// peerEqual returns true if the current peer cur matches the desired peer
+// want. Endpoints are resolved first, as wg(8) only reports addresses.
+func peerEqual(cur, want *Peer) bool {
+	if cur.PersistentKeepalive != want.PersistentKeepalive {
+		return false
+	}
+
+	if want.Endpoint != "" {
+		addr, err := net.ResolveUDPAddr("udp", want.Endpoint)
+		if err != nil || addr.String() != cur.Endpoint {
+			return false
+		}
+	}
+
+	return reflect.DeepEqual(sortedCIDRs(cur.AllowedIPs), sortedCIDRs(want.AllowedIPs))
+}
+
+// sortedCIDRs returns a normalized, sorted, copy of a list of CIDRs
+func sortedCIDRs(cidrs []string) []string {
+	out := make([]string, 0, len(cidrs))
+	for _, c := range cidrs {
+		if _, n, err := net.ParseCIDR(c); err == nil {
+			c = n.String()
+		}
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
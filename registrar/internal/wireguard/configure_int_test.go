@@ -0,0 +1,143 @@
+//go:build tm_int && linux
+// +build tm_int,linux
+
+package wireguard
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// inNetNS runs fn in a new network namespace, skipping the test if
+// we're unable to create one or wireguard isn't supported.
+func inNetNS(t *testing.T, fn func()) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+
+	for _, bin := range []string{"ip", "wg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	// t.Skip can't be called outside of the test goroutine
+	skip := make(chan string, 1)
+	go func() {
+		defer close(skip)
+
+		// never unlocked, so this thread is thrown away when we're done
+		// instead of being reused in another namespace
+		runtime.LockOSThread()
+		if err := syscall.Unshare(syscall.CLONE_NEWNET); err != nil {
+			t.Errorf("failed to create network namespace: %v", err)
+			return
+		}
+
+		if b, err := exec.Command("ip", "link", "add", "dev", "wgtest", "type", "wireguard").CombinedOutput(); err != nil {
+			skip <- "wireguard is not supported: " + string(b)
+			return
+		}
+		if err := exec.Command("ip", "link", "del", "dev", "wgtest").Run(); err != nil {
+			t.Errorf("failed to delete test interface: %v", err)
+			return
+		}
+
+		fn()
+	}()
+
+	if reason, ok := <-skip; ok {
+		t.Skip(reason)
+	}
+}
+
+func TestConfigureNetNS(t *testing.T) {
+	inNetNS(t, func() {
+		ctx := context.Background()
+
+		priv, err := GeneratePrivateKey()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		hub, err := GeneratePrivateKey()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		c := &Config{
+			PrivateKey: priv,
+			Address:    "10.10.0.2/24",
+			Peers: []Peer{{
+				PublicKey:           hub.PublicKey(),
+				Endpoint:            "127.0.0.1:51820",
+				AllowedIPs:          []string{"10.10.0.0/24"},
+				PersistentKeepalive: 25,
+			}},
+		}
+
+		d := NewDevice(DefaultInterface)
+		changed, err := d.Configure(ctx, c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !changed {
+			t.Error("expected the first configure to change the interface")
+		}
+
+		pub, err := d.PublicKey(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if pub != priv.PublicKey() {
+			t.Errorf("expected interface public key %s, got %s", priv.PublicKey(), pub)
+		}
+
+		changed, err = d.Configure(ctx, c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if changed {
+			t.Error("expected re-running configure to change nothing")
+		}
+
+		// changing the address should only swap the address
+		c.Address = "10.10.0.3/24"
+		changed, err = d.Configure(ctx, c)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !changed {
+			t.Error("expected address change to change the interface")
+		}
+
+		out, err := exec.Command("ip", "-o", "-4", "address", "show", "dev", DefaultInterface).CombinedOutput()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got := string(out); !containsField(got, "10.10.0.3/24") || containsField(got, "10.10.0.2/24") {
+			t.Errorf("unexpected addresses: %s", got)
+		}
+	})
+}
+
+func containsField(s, field string) bool {
+	for _, f := range strings.Fields(s) {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
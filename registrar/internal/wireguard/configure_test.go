@@ -0,0 +1,111 @@
+package wireguard
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDump(t *testing.T) {
+	dump := strings.Join([]string{
+		"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=\thSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=\t51820\toff",
+		"hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=\t(none)\t127.0.0.1:51820\t10.10.0.0/24\t0\t0\t0\t25",
+	}, "\n")
+
+	s, err := parseDump(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := s.privateKey.String(); got != "dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=" {
+		t.Errorf("unexpected private key %q", got)
+	}
+
+	pub, _ := ParseKey("hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=")
+	want := Peer{
+		PublicKey:           pub,
+		Endpoint:            "127.0.0.1:51820",
+		AllowedIPs:          []string{"10.10.0.0/24"},
+		PersistentKeepalive: 25,
+	}
+	if got := s.peers[pub]; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected peer %+v, got %+v", want, got)
+	}
+}
+
+func TestParseDumpNewInterface(t *testing.T) {
+	s, err := parseDump("(none)\t(none)\t0\toff\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.privateKey != (Key{}) {
+		t.Errorf("expected no private key")
+	}
+	if len(s.peers) != 0 {
+		t.Errorf("expected no peers, got %d", len(s.peers))
+	}
+}
+
+// TestConfigureNoop ensures that nothing is run that changes an already
+// configured interface
+func TestConfigureNoop(t *testing.T) {
+	priv, _ := ParseKey("dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=")
+	pub := priv.PublicKey()
+
+	d := &Device{name: "wg0", run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		cmd := name + " " + strings.Join(args, " ")
+		switch cmd {
+		case "ip link show dev wg0":
+			return nil, nil
+		case "ip -o -4 address show dev wg0":
+			return []byte("5: wg0    inet 10.10.0.2/24 scope global wg0\\       valid_lft forever preferred_lft forever\n"), nil
+		case "wg show wg0 dump":
+			return []byte(priv.String() + "\t" + pub.String() + "\t51820\toff\n" +
+				pub.String() + "\t(none)\t127.0.0.1:51820\t10.10.0.0/24\t0\t0\t0\t25\n"), nil
+		case "ip -o link show dev wg0":
+			return []byte("5: wg0: <POINTOPOINT,NOARP,UP,LOWER_UP> mtu 1420 qdisc noqueue state UNKNOWN\n"), nil
+		}
+
+		t.Errorf("unexpected command: %s", cmd)
+		return nil, nil
+	}}
+
+	changed, err := d.Configure(context.Background(), &Config{
+		PrivateKey: priv,
+		Address:    "10.10.0.2/24",
+		Peers: []Peer{{
+			PublicKey:           pub,
+			Endpoint:            "127.0.0.1:51820",
+			AllowedIPs:          []string{"10.10.0.0/24"},
+			PersistentKeepalive: 25,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed {
+		t.Errorf("expected nothing to change")
+	}
+}
+
+func TestPeers(t *testing.T) {
+	pub, _ := ParseKey("hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=")
+
+	d := &Device{name: "wg0", run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return []byte("(none)\t(none)\t51820\toff\n" +
+			pub.String() + "\t(none)\t(none)\t10.10.0.2/32\t0\t0\t0\toff\n"), nil
+	}}
+
+	peers, err := d.Peers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[Key]Peer{pub: {PublicKey: pub, AllowedIPs: []string{"10.10.0.2/32"}}}
+	if !reflect.DeepEqual(peers, want) {
+		t.Errorf("expected peers %+v, got %+v", want, peers)
+	}
+}
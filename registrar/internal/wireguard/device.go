@@ -0,0 +1,145 @@
+// Package wireguard manages WireGuard keys and interfaces using wg(8).
+package wireguard
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultInterface is the name of the WireGuard interface we manage
+const DefaultInterface = "wg0"
+
+// Peer is a WireGuard peer
+type Peer struct {
+	// PublicKey is the public key of this peer
+	PublicKey Key
+
+	// Endpoint is the host:port of this peer, if known
+	Endpoint string
+
+	// AllowedIPs is a list of CIDRs routed to this peer
+	AllowedIPs []string
+
+	// PersistentKeepalive is the keepalive interval in seconds, 0 disables it
+	PersistentKeepalive int
+}
+
+// runFunc runs a command and returns it's combined output
+type runFunc func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func execRun(ctx context.Context, name string, args ...string) ([]byte, error) {
+	b, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return b, errors.Wrapf(err, "%s %s: %s", name, strings.Join(args, " "), strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// Device is a WireGuard interface on this host
+type Device struct {
+	name string
+	run  runFunc
+}
+
+// NewDevice returns a new WireGuard device handle for the interface
+// with the given name
+func NewDevice(name string) *Device {
+	return &Device{
+		name: name,
+		run:  execRun,
+	}
+}
+
+// Name returns the name of the interface
+func (d *Device) Name() string {
+	return d.name
+}
+
+// PublicKey returns the public key of this interface
+func (d *Device) PublicKey(ctx context.Context) (Key, error) {
+	b, err := d.run(ctx, "wg", "show", d.name, "public-key")
+	if err != nil {
+		return Key{}, errors.Wrap(err, "failed to get interface public key")
+	}
+
+	return ParseKey(strings.TrimSpace(string(b)))
+}
+
+// AddPeer adds, or updates, a peer on this interface
+func (d *Device) AddPeer(ctx context.Context, p *Peer) error {
+	args := []string{"set", d.name, "peer", p.PublicKey.String()}
+	if p.Endpoint != "" {
+		args = append(args, "endpoint", p.Endpoint)
+	}
+	if p.PersistentKeepalive != 0 {
+		args = append(args, "persistent-keepalive", strconv.Itoa(p.PersistentKeepalive))
+	}
+	args = append(args, "allowed-ips", strings.Join(p.AllowedIPs, ","))
+
+	_, err := d.run(ctx, "wg", args...)
+	return errors.Wrap(err, "failed to add peer")
+}
+
+// RemovePeer removes a peer from this interface
+func (d *Device) RemovePeer(ctx context.Context, pub Key) error {
+	_, err := d.run(ctx, "wg", "set", d.name, "peer", pub.String(), "remove")
+	return errors.Wrap(err, "failed to remove peer")
+}
+
+// Peers returns the peers of this interface, keyed by their public key
+func (d *Device) Peers(ctx context.Context) (map[Key]Peer, error) {
+	s, err := d.state(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.peers, nil
+}
+
+// LatestHandshakes returns when the last handshake with each peer of this
+// interface was. Peers that haven't completed a handshake yet are omitted.
+func (d *Device) LatestHandshakes(ctx context.Context) (map[Key]time.Time, error) {
+	b, err := d.run(ctx, "wg", "show", d.name, "latest-handshakes")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get latest handshakes")
+	}
+
+	return parseHandshakes(string(b))
+}
+
+// parseHandshakes parses the output of wg show <interface> latest-handshakes
+func parseHandshakes(out string) (map[Key]time.Time, error) {
+	handshakes := make(map[Key]time.Time)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("unexpected handshake line %q", line)
+		}
+
+		pub, err := ParseKey(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse handshake time")
+		}
+
+		if sec == 0 {
+			continue
+		}
+		handshakes[pub] = time.Unix(sec, 0)
+	}
+
+	return handshakes, nil
+}
@@ -0,0 +1,36 @@
+package wireguard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHandshakes(t *testing.T) {
+	out := "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=\t1600000000\n" +
+		"dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=\t0\n"
+
+	handshakes, err := parseHandshakes(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handshakes) != 1 {
+		t.Fatalf("expected peers without a handshake to be omitted, got %d handshakes", len(handshakes))
+	}
+
+	pub, _ := ParseKey("hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo=")
+	if got := handshakes[pub]; !got.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("unexpected handshake time %v", got)
+	}
+}
+
+func TestParseHandshakesEmpty(t *testing.T) {
+	handshakes, err := parseHandshakes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handshakes) != 0 {
+		t.Errorf("expected no handshakes, got %d", len(handshakes))
+	}
+}
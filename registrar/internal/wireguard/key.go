@@ -0,0 +1,58 @@
+package wireguard
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/curve25519"
+)
+
+// KeyLen is the length of a WireGuard key in bytes
+const KeyLen = 32
+
+// Key is a WireGuard (curve25519) key
+type Key [KeyLen]byte
+
+// GeneratePrivateKey generates a new, clamped, WireGuard private key
+func GeneratePrivateKey() (Key, error) {
+	var k Key
+	if _, err := rand.Read(k[:]); err != nil {
+		return Key{}, errors.Wrap(err, "failed to read random bytes")
+	}
+
+	// clamp the key, see https://cr.yp.to/ecdh.html
+	k[0] &= 248
+	k[31] = (k[31] & 127) | 64
+	return k, nil
+}
+
+// ParseKey parses a base64 encoded key, as used by wg(8)
+func ParseKey(s string) (Key, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Key{}, errors.Wrap(err, "failed to decode key")
+	}
+
+	if len(b) != KeyLen {
+		return Key{}, fmt.Errorf("invalid key length %d, expected %d", len(b), KeyLen)
+	}
+
+	var k Key
+	copy(k[:], b)
+	return k, nil
+}
+
+// PublicKey returns the public key of a private key
+func (k Key) PublicKey() Key {
+	var pub Key
+	priv := [KeyLen]byte(k)
+	curve25519.ScalarBaseMult((*[KeyLen]byte)(&pub), &priv)
+	return pub
+}
+
+// String returns the base64 encoded representation of a key
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
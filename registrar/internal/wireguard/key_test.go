@@ -0,0 +1,76 @@
+package wireguard
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	priv, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseKey(priv.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed != priv {
+		t.Errorf("expected parsed key to equal original key")
+	}
+
+	if priv.PublicKey() == priv {
+		t.Errorf("expected public key to differ from private key")
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	// test vector from RFC 7748, section 6.1
+	priv, err := ParseKey("dwdtCnMYpX08FsFyUbJmRd9ML4frwJkqsXf7pR25LCo=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := priv.PublicKey().String(), "hSDwCYkwp1R0i33ctD73Wg2/Og0mOBr066SpjqqbTmo="; got != want {
+		t.Errorf("expected public key %q, got %q", want, got)
+	}
+}
+
+func TestParseKeyInvalid(t *testing.T) {
+	if _, err := ParseKey("aGVsbG8="); err == nil {
+		t.Errorf("expected error for short key")
+	}
+
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Errorf("expected error for invalid base64")
+	}
+}
+
+func TestAddPeer(t *testing.T) {
+	var got []string
+	d := &Device{name: "wg0", run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		got = append([]string{name}, args...)
+		return nil, nil
+	}}
+
+	var k Key
+	err := d.AddPeer(context.Background(), &Peer{
+		PublicKey:           k,
+		Endpoint:            "127.0.0.1:51820",
+		AllowedIPs:          []string{"10.10.0.2/32"},
+		PersistentKeepalive: 25,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"wg", "set", "wg0", "peer", k.String(), "endpoint", "127.0.0.1:51820",
+		"persistent-keepalive", "25", "allowed-ips", "10.10.0.2/32",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected command %v, got %v", want, got)
+	}
+}
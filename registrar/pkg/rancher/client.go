@@ -1,17 +1,32 @@
 package rancher
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// DefaultTimeout is how long a request to rancher can take
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxRetries is how many times a request is retried when rancher
+	// is rate limiting us or fails
+	DefaultMaxRetries = 4
+
+	// maxBackoff is the longest we wait before retrying a request
+	maxBackoff = 30 * time.Second
+)
+
 type ClusterRegistrationTokenResponse struct {
 	Type         string                         `json:"type"`
 	Links        Links                          `json:"links"`
@@ -29,6 +44,11 @@ type ClusterRegistrationTokenLinks struct {
 type Pagination struct {
 	Limit int `json:"limit"`
 	Total int `json:"total"`
+
+	// Next is the URL of the next page of a collection, it's empty on the
+	// last page
+	Next    string `json:"next,omitempty"`
+	Partial bool   `json:"partial,omitempty"`
 }
 type ClusterRegistrationTokenDataLinks struct {
 	Command              string `json:"command"`
@@ -95,67 +115,283 @@ type ClusterRegistrationTokenData struct {
 	WindowsNodeCommand   string                            `json:"windowsNodeCommand"`
 }
 
+// Error is an error returned by the rancher API
+type Error struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int `json:"status"`
+
+	// Code is the rancher error code, e.g. NotFound
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" && e.Message == "" {
+		return fmt.Sprintf("rancher returned status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("rancher returned status code %d (%s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound returns true if err is a rancher error for an object that
+// doesn't exist
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized returns true if err is a rancher error for a missing or
+// invalid token
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+func hasStatus(err error, code int) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.StatusCode == code
+}
+
+// retryable returns true if a request that failed with a status code
+// should be retried
+func retryable(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// collection is a page of a rancher collection, with the data left to
+// the caller to decode
+type collection struct {
+	Pagination Pagination      `json:"pagination"`
+	Data       json.RawMessage `json:"data"`
+}
+
 // Client is a rancher client
 type Client struct {
 	h       *http.Client
 	authKey string
 	baseURL *url.URL
+
+	// MaxRetries is how many times a request is retried when rancher returns
+	// 429 or a 5xx status code
+	MaxRetries int
+
+	// backoff is how long to wait before the first retry, it's doubled
+	// every retry
+	backoff time.Duration
 }
 
-func NewClient(hostname, authKey string) *Client {
+// NewClient returns a client for the rancher server at hostname, e.g.
+// https://rancher.example.com, authenticated with an API key
+func NewClient(hostname, authKey string) (*Client, error) {
 	u, err := url.Parse(hostname)
 	if err != nil {
-		panic(err)
+		return nil, errors.Wrap(err, "failed to parse rancher url")
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid rancher url '%s', expected e.g. https://rancher.example.com", hostname)
 	}
 
 	return &Client{
-		h:       &http.Client{},
-		authKey: authKey,
-		// TODO(jaredallard): fix this hardcode
-		baseURL: u,
+		h:          &http.Client{Timeout: DefaultTimeout},
+		authKey:    authKey,
+		baseURL:    u,
+		MaxRetries: DefaultMaxRetries,
+		backoff:    500 * time.Millisecond,
+	}, nil
+}
+
+// url returns the url of an API path
+func (c *Client) url(path string, query url.Values) *url.URL {
+	return &url.URL{
+		Scheme:   c.baseURL.Scheme,
+		Host:     c.baseURL.Host,
+		Path:     path,
+		RawQuery: query.Encode(),
 	}
 }
 
-// GetClusterRegistrationToken returns all cluster registration tokens or, if clusterId is provided
-// all tokens for a given server.
-func (c *Client) GetClusterRegistrationToken(ctx context.Context, clusterID string) ([]ClusterRegistrationTokenData, error) { //nolint:funlen
-	u := url.URL{
-		Scheme: c.baseURL.Scheme,
-		Host:   c.baseURL.Host,
-		Path:   "/v3/clusterregistrationtokens",
+// do sends a request to the rancher API, decoding the response into out if
+// it's not nil. Requests are retried with backoff when rancher returns 429
+// or a 5xx status code, other errors are returned as an *Error.
+func (c *Client) do(ctx context.Context, method string, u *url.URL, body, out interface{}) error {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode body")
+		}
 	}
 
-	if clusterID != "" {
-		q := u.Query()
-		q.Set("clusterId", clusterID)
-		u.RawQuery = q.Encode()
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		wait, err := c.try(ctx, method, u, b, out)
+		if err == nil {
+			return nil
+		}
+
+		if wait < 0 || attempt >= c.MaxRetries {
+			return err
+		}
+
+		// rancher may tell us how long to wait, otherwise back off
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return errors.Wrapf(ctx.Err(), "gave up retrying after: %v", err)
+		case <-t.C:
+		}
+	}
+}
+
+// try sends a request once. When it fails it returns how long to wait
+// before retrying, based on Retry-After, or -1 if it shouldn't be retried.
+func (c *Client) try(ctx context.Context, method string, u *url.URL, body []byte, out interface{}) (time.Duration, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+		return -1, errors.Wrap(err, "failed to create request")
 	}
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.authKey))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := c.h.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to execute request")
+		return -1, errors.Wrap(err, "failed to execute request")
 	}
 	defer resp.Body.Close()
 
 	// handle any errors that pop up
-	if resp.StatusCode != http.StatusOK {
-		raw, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		raw, _ := ioutil.ReadAll(resp.Body)
+
+		apiErr := &Error{}
+		if json.Unmarshal(raw, apiErr) != nil || apiErr.Code == "" {
+			apiErr.Message = string(bytes.TrimSpace(raw))
+		}
+		apiErr.StatusCode = resp.StatusCode
+
+		if !retryable(resp.StatusCode) {
+			return -1, apiErr
+		}
+
+		var wait time.Duration
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+		return wait, apiErr
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return 0, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return -1, errors.Wrap(err, "failed to parse body")
+	}
+
+	return 0, nil
+}
+
+// list fetches every page of a collection, following pagination.next,
+// calling appendPage with the data of each page
+func (c *Client) list(ctx context.Context, path string, query url.Values, appendPage func(data json.RawMessage) error) error {
+	u := c.url(path, query)
+	for {
+		var page collection
+		if err := c.do(ctx, http.MethodGet, u, nil, &page); err != nil {
+			return err
+		}
+
+		if err := appendPage(page.Data); err != nil {
+			return errors.Wrap(err, "failed to parse collection")
+		}
+
+		if page.Pagination.Next == "" {
+			return nil
+		}
+
+		next, err := url.Parse(page.Pagination.Next)
 		if err != nil {
-			raw = []byte("failed to read body")
+			return errors.Wrap(err, "failed to parse next page url")
+		}
+
+		// the next page is on the server we're talking to, even if rancher
+		// thinks it's reachable elsewhere
+		u = c.url(next.Path, next.Query())
+	}
+}
+
+// Verify checks that the client can reach rancher and that it's token is
+// valid, returning an *Error if rancher rejects it
+func (c *Client) Verify(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, c.url("/v3/users", url.Values{"me": {"true"}}), nil, nil)
+}
+
+// ListClusterRegistrationTokens returns all cluster registration tokens or,
+// if clusterID is provided, all tokens for a given cluster.
+func (c *Client) ListClusterRegistrationTokens(ctx context.Context, clusterID string) ([]ClusterRegistrationTokenData, error) {
+	q := url.Values{}
+	if clusterID != "" {
+		q.Set("clusterId", clusterID)
+	}
+
+	var tokens []ClusterRegistrationTokenData
+	err := c.list(ctx, "/v3/clusterregistrationtokens", q, func(data json.RawMessage) error {
+		var page []ClusterRegistrationTokenData
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("got non 200 status code %d: %s", resp.StatusCode, string(raw))
+		tokens = append(tokens, page...)
+		return nil
+	})
+	return tokens, err
+}
+
+// GetClusterRegistrationToken returns a cluster registration token by it's
+// ID, e.g. c-abcde:default-token
+func (c *Client) GetClusterRegistrationToken(ctx context.Context, id string) (*ClusterRegistrationTokenData, error) {
+	var crt ClusterRegistrationTokenData
+	if err := c.do(ctx, http.MethodGet, c.url("/v3/clusterregistrationtokens/"+id, nil), nil, &crt); err != nil {
+		return nil, err
+	}
+
+	return &crt, nil
+}
+
+// CreateClusterRegistrationToken creates a cluster registration token for a
+// cluster. Rancher generates the token itself, so it can be empty until
+// Rancher is done creating it.
+func (c *Client) CreateClusterRegistrationToken(ctx context.Context, clusterID string) (*ClusterRegistrationTokenData, error) {
+	body := map[string]string{
+		"type":      "clusterRegistrationToken",
+		"clusterId": clusterID,
 	}
 
-	var crt ClusterRegistrationTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&crt); err != nil {
-		return nil, errors.Wrap(err, "failed to parse body")
+	var crt ClusterRegistrationTokenData
+	if err := c.do(ctx, http.MethodPost, c.url("/v3/clusterregistrationtokens", nil), body, &crt); err != nil {
+		return nil, err
 	}
 
-	return crt.Data, nil
+	return &crt, nil
+}
+
+// DeleteClusterRegistrationToken deletes a cluster registration token by
+// it's ID
+func (c *Client) DeleteClusterRegistrationToken(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, c.url("/v3/clusterregistrationtokens/"+id, nil), nil, nil)
 }
@@ -1,45 +1,267 @@
 package rancher
 
-// TODO(jaredallard): renenable when automatable
-// func TestGetClusterRegistrationToken(t *testing.T) {
-// 	c := NewClient(os.Getenv("RANCHER_HOST"), os.Getenv("RANCHER_TOKEN"))
-// 	ctx := context.Background()
-
-// 	d, err := c.GetClusterRegistrationToken(ctx, "")
-// 	if err != nil {
-// 		t.Error(err)
-// 		return
-// 	}
-
-// 	if len(d) == 0 {
-// 		spew.Dump(d)
-// 		t.Errorf("expected to get > 0 clusterregistrationtokens, but got %d", len(d))
-// 		return
-// 	}
-
-// 	if d[0].Token == "" {
-// 		t.Errorf("expected to get a token, but didn't")
-// 	}
-
-// 	d, err = c.GetClusterRegistrationToken(ctx, "c-l7jc8")
-// 	if err != nil {
-// 		t.Error(err)
-// 		return
-// 	}
-
-// 	if len(d) == 0 {
-// 		spew.Dump(d)
-// 		t.Errorf("expected to get > 0 clusterregistrationtokens, but got %d (filter)", len(d))
-// 		return
-// 	}
-
-// 	if d[0].Token == "" {
-// 		t.Errorf("expected to get a token, but didn't (filter)")
-// 		return
-// 	}
-
-// 	if d[0].ClusterID != "c-l7jc8" {
-// 		t.Errorf("expected to get filtered clusterId, but go one that isn't matching the filter: %s", d[0].ClusterID)
-// 		return
-// 	}
-// }
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAuthKey = "token-abcde:secret"
+
+// fakeRancher is an in-memory rancher API that pages collections two items
+// at a time, and can be told to fail requests
+type fakeRancher struct {
+	t *testing.T
+
+	lock   sync.Mutex
+	nodes  []Node
+	tokens []ClusterRegistrationTokenData
+
+	// failures are the status codes the next requests fail with
+	failures []int
+	requests int
+}
+
+func (f *fakeRancher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.requests++
+	if r.Header.Get("Authorization") != "Bearer "+testAuthKey {
+		f.writeError(w, http.StatusUnauthorized, "Unauthorized", "must authenticate")
+		return
+	}
+
+	if len(f.failures) > 0 {
+		code := f.failures[0]
+		f.failures = f.failures[1:]
+		f.writeError(w, code, "ServerError", "try again")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v3/users":
+		f.write(w, http.StatusOK, map[string]interface{}{"type": "collection", "data": []interface{}{}})
+	case r.Method == http.MethodGet && r.URL.Path == "/v3/nodes":
+		var nodes []Node
+		for _, n := range f.nodes {
+			if cid := r.URL.Query().Get("clusterId"); cid == "" || n.ClusterID == cid {
+				nodes = append(nodes, n)
+			}
+		}
+		f.writePage(w, r, len(nodes), func(start, end int) interface{} { return nodes[start:end] })
+	case r.Method == http.MethodPost && r.URL.Path == "/v3/nodes":
+		var n Node
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil || n.Type != "node" {
+			f.writeError(w, http.StatusUnprocessableEntity, "InvalidBodyContent", "bad node")
+			return
+		}
+		n.ID = fmt.Sprintf("%s:m-%d", n.ClusterID, len(f.nodes))
+		f.nodes = append(f.nodes, n)
+		f.write(w, http.StatusCreated, n)
+	case r.Method == http.MethodGet && r.URL.Path == "/v3/clusterregistrationtokens":
+		f.writePage(w, r, len(f.tokens), func(start, end int) interface{} { return f.tokens[start:end] })
+	case r.Method == http.MethodDelete:
+		for i, n := range f.nodes {
+			if r.URL.Path == "/v3/nodes/"+n.ID {
+				f.nodes = append(f.nodes[:i], f.nodes[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		f.writeError(w, http.StatusNotFound, "NotFound", "not found")
+	case r.Method == http.MethodGet:
+		for _, n := range f.nodes {
+			if r.URL.Path == "/v3/nodes/"+n.ID {
+				f.write(w, http.StatusOK, n)
+				return
+			}
+		}
+		f.writeError(w, http.StatusNotFound, "NotFound", "not found")
+	default:
+		f.writeError(w, http.StatusNotFound, "NotFound", "not found")
+	}
+}
+
+func (f *fakeRancher) write(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		f.t.Errorf("failed to write response: %v", err)
+	}
+}
+
+func (f *fakeRancher) writeError(w http.ResponseWriter, code int, errCode, message string) {
+	f.write(w, code, map[string]interface{}{"type": "error", "status": code, "code": errCode, "message": message})
+}
+
+// writePage writes the page of a collection of total items starting at the
+// marker query parameter, linking to the next page like rancher does
+func (f *fakeRancher) writePage(w http.ResponseWriter, r *http.Request, total int, data func(start, end int) interface{}) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("marker"))
+	end := start + 2
+	if end > total {
+		end = total
+	}
+
+	p := Pagination{Limit: 2, Total: total}
+	if end < total {
+		q := r.URL.Query()
+		q.Set("marker", strconv.Itoa(end))
+		p.Next = "https://rancher.example.com" + r.URL.Path + "?" + q.Encode()
+	}
+
+	f.write(w, http.StatusOK, map[string]interface{}{"type": "collection", "pagination": p, "data": data(start, end)})
+}
+
+func newTestClient(t *testing.T, f *fakeRancher, authKey string) (*Client, func()) {
+	f.t = t
+	srv := httptest.NewServer(f)
+
+	c, err := NewClient(srv.URL, authKey)
+	if err != nil {
+		srv.Close()
+		t.Fatalf("failed to create client: %v", err)
+	}
+	c.backoff = time.Millisecond
+
+	return c, srv.Close
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"valid", "https://rancher.example.com", false},
+		{"no scheme", "rancher.example.com", true},
+		{"invalid", "https://rancher example.com/%", true},
+		{"empty", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(tt.url, testAuthKey); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	f := &fakeRancher{}
+	c, closeServer := newTestClient(t, f, testAuthKey)
+	defer closeServer()
+
+	if err := c.Verify(context.Background()); err != nil {
+		t.Errorf("expected a valid token to be verified, got %v", err)
+	}
+
+	c.authKey = "invalid"
+	if err := c.Verify(context.Background()); !IsUnauthorized(err) {
+		t.Errorf("expected an invalid token to be unauthorized, got %v", err)
+	}
+}
+
+func TestListFollowsPagination(t *testing.T) {
+	f := &fakeRancher{}
+	for i := 0; i < 5; i++ {
+		f.tokens = append(f.tokens, ClusterRegistrationTokenData{ID: fmt.Sprintf("c-abcde:token-%d", i), ClusterID: "c-abcde"})
+		f.nodes = append(f.nodes, Node{ID: fmt.Sprintf("c-abcde:m-%d", i), ClusterID: "c-abcde"})
+	}
+	f.nodes = append(f.nodes, Node{ID: "c-fghij:m-0", ClusterID: "c-fghij"})
+
+	c, closeServer := newTestClient(t, f, testAuthKey)
+	defer closeServer()
+
+	tokens, err := c.ListClusterRegistrationTokens(context.Background(), "")
+	if err != nil {
+		t.Fatalf("failed to list tokens: %v", err)
+	}
+
+	if len(tokens) != 5 || tokens[4].ID != "c-abcde:token-4" {
+		t.Errorf("expected every page of tokens, got %v", tokens)
+	}
+
+	nodes, err := c.ListNodes(context.Background(), "c-abcde")
+	if err != nil {
+		t.Fatalf("failed to list nodes: %v", err)
+	}
+
+	if len(nodes) != 5 {
+		t.Errorf("expected the 5 nodes of the cluster, got %d", len(nodes))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusTooManyRequests}, false, 3},
+		{"server error", []int{http.StatusBadGateway}, false, 2},
+		{"gives up", []int{500, 500, 500, 500, 500}, true, 5},
+		{"client error", []int{http.StatusBadRequest}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeRancher{failures: tt.failures}
+			c, closeServer := newTestClient(t, f, testAuthKey)
+			defer closeServer()
+
+			err := c.Verify(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if f.requests != tt.wantRequests {
+				t.Errorf("expected %d requests, got %d", tt.wantRequests, f.requests)
+			}
+		})
+	}
+}
+
+func TestNodes(t *testing.T) {
+	f := &fakeRancher{}
+	c, closeServer := newTestClient(t, f, testAuthKey)
+	defer closeServer()
+
+	ctx := context.Background()
+	n, err := c.CreateNode(ctx, &Node{ClusterID: "c-abcde", RequestedHostname: "pi"})
+	if err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+
+	got, err := c.GetNode(ctx, n.ID)
+	if err != nil {
+		t.Fatalf("failed to get node: %v", err)
+	}
+
+	if got.RequestedHostname != "pi" {
+		t.Errorf("expected the created node, got %+v", got)
+	}
+
+	if err := c.DeleteNode(ctx, n.ID); err != nil {
+		t.Fatalf("failed to delete node: %v", err)
+	}
+
+	_, err = c.GetNode(ctx, n.ID)
+	if !IsNotFound(err) {
+		t.Fatalf("expected deleted node to not be found, got %v", err)
+	}
+
+	e := err.(*Error)
+	if e.Code != "NotFound" || e.Message != "not found" {
+		t.Errorf("expected the rancher error to be parsed, got %+v", e)
+	}
+}
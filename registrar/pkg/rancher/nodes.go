@@ -0,0 +1,85 @@
+package rancher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Node is a node of a rancher cluster
+type Node struct {
+	ID                   string            `json:"id,omitempty"`
+	Type                 string            `json:"type,omitempty"`
+	Name                 string            `json:"name,omitempty"`
+	ClusterID            string            `json:"clusterId,omitempty"`
+	NodePoolID           string            `json:"nodePoolId,omitempty"`
+	UUID                 string            `json:"uuid,omitempty"`
+	Created              time.Time         `json:"created,omitempty"`
+	Annotations          map[string]string `json:"annotations,omitempty"`
+	Labels               map[string]string `json:"labels,omitempty"`
+	Links                Links             `json:"links,omitempty"`
+	State                string            `json:"state,omitempty"`
+	Transitioning        string            `json:"transitioning,omitempty"`
+	TransitioningMessage string            `json:"transitioningMessage,omitempty"`
+
+	// NodeName is the name of the Kubernetes node, Hostname the hostname
+	// the agent reported and RequestedHostname the one it was created with
+	NodeName          string `json:"nodeName,omitempty"`
+	Hostname          string `json:"hostname,omitempty"`
+	RequestedHostname string `json:"requestedHostname,omitempty"`
+	IPAddress         string `json:"ipAddress,omitempty"`
+
+	ControlPlane bool `json:"controlPlane,omitempty"`
+	Etcd         bool `json:"etcd,omitempty"`
+	Worker       bool `json:"worker,omitempty"`
+}
+
+// ListNodes returns all nodes or, if clusterID is provided, all nodes of a
+// given cluster
+func (c *Client) ListNodes(ctx context.Context, clusterID string) ([]Node, error) {
+	q := url.Values{}
+	if clusterID != "" {
+		q.Set("clusterId", clusterID)
+	}
+
+	var nodes []Node
+	err := c.list(ctx, "/v3/nodes", q, func(data json.RawMessage) error {
+		var page []Node
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		nodes = append(nodes, page...)
+		return nil
+	})
+	return nodes, err
+}
+
+// GetNode returns a node by it's ID, e.g. c-abcde:m-fghij
+func (c *Client) GetNode(ctx context.Context, id string) (*Node, error) {
+	var n Node
+	if err := c.do(ctx, http.MethodGet, c.url("/v3/nodes/"+id, nil), nil, &n); err != nil {
+		return nil, err
+	}
+
+	return &n, nil
+}
+
+// CreateNode creates a node, only ClusterID is required
+func (c *Client) CreateNode(ctx context.Context, n *Node) (*Node, error) {
+	body := *n
+	body.Type = "node"
+
+	var created Node
+	if err := c.do(ctx, http.MethodPost, c.url("/v3/nodes", nil), &body, &created); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// DeleteNode deletes a node by it's ID, which removes it from it's cluster
+func (c *Client) DeleteNode(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, c.url("/v3/nodes/"+id, nil), nil, nil)
+}